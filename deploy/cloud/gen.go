@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,21 +14,81 @@ import (
 )
 
 const (
-	libriExpVersionVar         = "libri_exp_version"
-	durationVar                = "duration"
-	numAuthorsVar              = "num_authors"
-	docsPerDayVar              = "docs_per_day"
-	contentSizeKBGammaShapeVar = "content_size_kb_gamma_shape"
-	contentSizeKBGammaRateVar  = "content_size_kb_gamma_rate"
-	sharesPerUploadVar         = "shares_per_upload"
-	numLibrariansVar           = "num_librarians"
-	librarianLocalPortVar      = "librarian_local_port"
-	numUploadesVar             = "num_uploaders"
-	numDownloadersVar          = "num_downloaders"
+	libriExpVersionVar             = "libri_exp_version"
+	durationVar                    = "duration"
+	numAuthorsVar                  = "num_authors"
+	docsPerDayVar                  = "docs_per_day"
+	contentSizeKBGammaShapeVar     = "content_size_kb_gamma_shape"
+	contentSizeKBGammaRateVar      = "content_size_kb_gamma_rate"
+	contentSizeDistVar             = "content_size_dist"
+	contentSizeKBLogNormalMuVar    = "content_size_kb_lognormal_mu"
+	contentSizeKBLogNormalSigmaVar = "content_size_kb_lognormal_sigma"
+	contentSizeKBParetoMinVar      = "content_size_kb_pareto_min"
+	contentSizeKBParetoAlphaVar    = "content_size_kb_pareto_alpha"
+	contentSizeKBFixedVar          = "content_size_kb_fixed"
+	contentSizeMixtureVar          = "content_size_mixture"
+	contentSizeHistogramVar        = "content_size_histogram"
+	scenarioVar                    = "scenario"
+	contentTypesVar                = "content_types"
+	largeDocFracVar                = "large_doc_frac"
+	largeDocSizeMBMinVar           = "large_doc_size_mb_min"
+	largeDocSizeMBMaxVar           = "large_doc_size_mb_max"
+	sharesPerUploadVar             = "shares_per_upload"
+	numLibrariansVar               = "num_librarians"
+	librarianLocalPortVar          = "librarian_local_port"
+	numUploadesVar                 = "num_uploaders"
+	numDownloadersVar              = "num_downloaders"
+	maxUploadersVar                = "max_uploaders"
+	maxDownloadersVar              = "max_downloaders"
+	loadProfileVar                 = "load_profile"
+	loadRampStartVar               = "load_ramp_start"
+	loadRampEndVar                 = "load_ramp_end"
+	loadRampDurationVar            = "load_ramp_duration"
+	loadStepDurationVar            = "load_step_duration"
+	loadStepLevelsVar              = "load_step_levels"
+	loadSinePeriodVar              = "load_sine_period"
+	loadSineAmplitudeVar           = "load_sine_amplitude"
+	loadSpikeIntervalVar           = "load_spike_interval"
+	loadSpikeDurationVar           = "load_spike_duration"
+	loadSpikeScaleVar              = "load_spike_scale"
+	socialGraphVar                 = "social_graph"
+	socialGraphMeanDegreeVar       = "social_graph_mean_degree"
+	socialGraphCommunitiesVar      = "social_graph_communities"
+	socialGraphCrossFracVar        = "social_graph_cross_frac"
+	shareNeighborFracVar           = "share_neighbor_frac"
+	activityVar                    = "activity"
+	activityZipfExponentVar        = "activity_zipf_exponent"
+	activityGammaShapeVar          = "activity_gamma_shape"
+	reReadsPerDayVar               = "re_reads_per_day"
+	popularityVar                  = "popularity"
+	popularityZipfExponentVar      = "popularity_zipf_exponent"
+	prefillDocsVar                 = "prefill_docs"
+	prefillGBVar                   = "prefill_gb"
+	queryTimeoutsVar               = "query_timeouts"
+	drainPeriodVar                 = "drain_period"
+	retryPoliciesVar               = "retry_policies"
+	retryableErrorsVar             = "retryable_errors"
+	retryJitterVar                 = "retry_jitter"
+	pinLibrariansVar               = "pin_librarians"
+	persistAuthorsVar              = "persist_authors"
+	tuiVar                         = "tui"
+	numWorkersVar                  = "num_workers"
+	seedVar                        = "seed"
 
 	kubeTemplateDir            = "kubernetes"
 	kubeConfigTemplateFilename = "libri-sim.template.yml"
 	kubeConfigFilename         = "libri-sim.yml"
+
+	// contentSizeHistogramFilepath is where the content size histogram from the
+	// content_size_histogram tfvar file is mounted in the Pod
+	contentSizeHistogramFilepath = "/config/content-size-histogram.csv"
+
+	// configDir is where the config files from the tfvars file are mounted in the Pod
+	configDir = "/config"
+
+	// scenarioFilenameBase is the name, without its extension, of the scenario file mounted from
+	// the scenario tfvar file; the extension is kept so libri-exp knows the file's format
+	scenarioFilenameBase = "scenario"
 )
 
 // SimConfig defines the simulation config params.
@@ -42,6 +103,88 @@ type SimConfig struct {
 	SharesPerUpload         uint
 	NumUploaders            uint
 	NumDownloaders          uint
+	MaxUploaders            uint
+	MaxDownloaders          uint
+	OptionalArgs            []*Arg
+
+	// ContentSizeHistogram contains the lines of the content size histogram CSV, if any.
+	ContentSizeHistogram []string
+
+	// Scenario contains the lines of the scenario file, if any, and ScenarioFilename is its name
+	// in the config volume.
+	Scenario         []string
+	ScenarioFilename string
+
+	// PersistAuthors is whether the data volume outlives the Pod, so later trials can load the
+	// authors saved by earlier ones.
+	PersistAuthors bool
+
+	// TUI is whether the sim shows a live dashboard, viewed with kubectl attach -it, instead of
+	// logs.
+	TUI bool
+
+	// NumWorkers is the number of worker Pods generating the load, and Workers contains their
+	// indices. With more than one, the libri-experimenter Pod coordinates the workers and merges
+	// their results.
+	NumWorkers uint
+	Workers    []uint
+}
+
+// Arg is a libri-exp run flag and its value.
+type Arg struct {
+	Flag  string
+	Value string
+}
+
+// optionalArgFlags maps optional tfvars to the libri-exp run flags they set; the flags' defaults
+// apply when the tfvars are absent.
+var optionalArgFlags = []struct {
+	tfvar string
+	flag  string
+}{
+	{contentSizeDistVar, "contentSizeDist"},
+	{contentSizeKBLogNormalMuVar, "contentSizeKBLogNormalMu"},
+	{contentSizeKBLogNormalSigmaVar, "contentSizeKBLogNormalSigma"},
+	{contentSizeKBParetoMinVar, "contentSizeKBParetoMin"},
+	{contentSizeKBParetoAlphaVar, "contentSizeKBParetoAlpha"},
+	{contentSizeKBFixedVar, "contentSizeKBFixed"},
+	{contentSizeMixtureVar, "contentSizeMixture"},
+	{contentTypesVar, "contentTypes"},
+	{largeDocFracVar, "largeDocFrac"},
+	{largeDocSizeMBMinVar, "largeDocSizeMBMin"},
+	{largeDocSizeMBMaxVar, "largeDocSizeMBMax"},
+	{loadProfileVar, "loadProfile"},
+	{loadRampStartVar, "loadRampStart"},
+	{loadRampEndVar, "loadRampEnd"},
+	{loadRampDurationVar, "loadRampDuration"},
+	{loadStepDurationVar, "loadStepDuration"},
+	{loadStepLevelsVar, "loadStepLevels"},
+	{loadSinePeriodVar, "loadSinePeriod"},
+	{loadSineAmplitudeVar, "loadSineAmplitude"},
+	{loadSpikeIntervalVar, "loadSpikeInterval"},
+	{loadSpikeDurationVar, "loadSpikeDuration"},
+	{loadSpikeScaleVar, "loadSpikeScale"},
+	{socialGraphVar, "socialGraph"},
+	{socialGraphMeanDegreeVar, "socialGraphMeanDegree"},
+	{socialGraphCommunitiesVar, "socialGraphCommunities"},
+	{socialGraphCrossFracVar, "socialGraphCrossFrac"},
+	{shareNeighborFracVar, "shareNeighborFrac"},
+	{activityVar, "activity"},
+	{activityZipfExponentVar, "activityZipfExponent"},
+	{activityGammaShapeVar, "activityGammaShape"},
+	{reReadsPerDayVar, "reReadsPerDay"},
+	{popularityVar, "popularity"},
+	{popularityZipfExponentVar, "popularityZipfExponent"},
+	{prefillDocsVar, "prefillDocs"},
+	{prefillGBVar, "prefillGB"},
+	{queryTimeoutsVar, "queryTimeouts"},
+	{drainPeriodVar, "drainPeriod"},
+	{retryPoliciesVar, "retryPolicies"},
+	{retryableErrorsVar, "retryableErrors"},
+	{retryJitterVar, "retryJitter"},
+	{pinLibrariansVar, "pinLibrarians"},
+	{persistAuthorsVar, "persistAuthors"},
+	{seedVar, "seed"},
 }
 
 var (
@@ -119,5 +262,90 @@ func getSimConfig(tfvarsFilepath string) (*SimConfig, error) {
 		NumUploaders:            uint(tfvars[numUploadesVar].(int)),
 		NumDownloaders:          uint(tfvars[numDownloadersVar].(int)),
 	}
+
+	// optional vars fall back to fixed-size worker pools
+	config.MaxUploaders = getOptionalUint(tfvars, maxUploadersVar, config.NumUploaders)
+	config.MaxDownloaders = getOptionalUint(tfvars, maxDownloadersVar, config.NumDownloaders)
+	config.OptionalArgs = getOptionalArgs(tfvars)
+	if value, in := tfvars[persistAuthorsVar]; in {
+		config.PersistAuthors = value.(bool)
+	}
+	if value, in := tfvars[tuiVar]; in {
+		config.TUI = value.(bool)
+	}
+	config.NumWorkers = getOptionalUint(tfvars, numWorkersVar, 1)
+	if config.NumWorkers > 1 {
+		config.Workers = make([]uint, config.NumWorkers)
+		for i := range config.Workers {
+			config.Workers[i] = uint(i)
+		}
+	}
+	if value, in := tfvars[contentSizeHistogramVar]; in {
+		histogram, err := readConfigLines(tfvarsFilepath, value.(string))
+		if err != nil {
+			return nil, err
+		}
+		config.ContentSizeHistogram = histogram
+		config.OptionalArgs = append(config.OptionalArgs, &Arg{
+			Flag:  "contentSizeHistogram",
+			Value: contentSizeHistogramFilepath,
+		})
+	}
+	if value, in := tfvars[scenarioVar]; in {
+		scenario, err := readConfigLines(tfvarsFilepath, value.(string))
+		if err != nil {
+			return nil, err
+		}
+		config.Scenario = scenario
+		config.ScenarioFilename = scenarioFilenameBase + filepath.Ext(value.(string))
+		config.OptionalArgs = append(config.OptionalArgs, &Arg{
+			Flag:  "scenario",
+			Value: path.Join(configDir, config.ScenarioFilename),
+		})
+	}
 	return config, nil
 }
+
+// readConfigLines reads the lines of a config file given by a tfvar, whose path, if relative, is
+// relative to the tfvars file.
+func readConfigLines(tfvarsFilepath, configFilepath string) ([]string, error) {
+	if !filepath.IsAbs(configFilepath) {
+		configFilepath = filepath.Join(filepath.Dir(tfvarsFilepath), configFilepath)
+	}
+	config, err := ioutil.ReadFile(configFilepath)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(config), "\n"), "\n"), nil
+}
+
+func getOptionalArgs(tfvars variables.FlagFile) []*Arg {
+	args := make([]*Arg, 0, len(optionalArgFlags))
+	for _, oaf := range optionalArgFlags {
+		value, in := tfvars[oaf.tfvar]
+		if !in {
+			continue
+		}
+		args = append(args, &Arg{Flag: oaf.flag, Value: formatArgValue(value)})
+	}
+	return args
+}
+
+// formatArgValue formats a tfvar value as a flag value, joining lists with commas.
+func formatArgValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		strs := make([]string, len(list))
+		for i, item := range list {
+			strs[i] = fmt.Sprint(item)
+		}
+		return strings.Join(strs, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func getOptionalUint(tfvars variables.FlagFile, name string, defaultValue uint) uint {
+	if value, in := tfvars[name]; in {
+		return uint(value.(int))
+	}
+	return defaultValue
+}
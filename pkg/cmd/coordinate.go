@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/drausin/libri-experiments/pkg/sim"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	nWorkersFlag        = "nWorkers"
+	coordinatorPortFlag = "coordinatorPort"
+)
+
+// coordinateCmd shares runCmd's experiment parameter flags (see run.go init), which it hands out
+// to the workers.
+var coordinateCmd = &cobra.Command{
+	Use:   "coordinate",
+	Short: "coordinate an experiment over several workers",
+	Long: "assign each worker (started with run --coordinator --workerID i) a shard of the authors, " +
+		"a seed, and a share of the upload rate, start them together, and merge their results " +
+		"into a single summary",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// bind here rather than in init since other commands share some flag names
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return coordinate()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(coordinateCmd)
+
+	coordinateCmd.Flags().Uint(nWorkersFlag, sim.DefaultNWorkers,
+		"number of workers to wait for")
+	coordinateCmd.Flags().Int(coordinatorPortFlag, sim.DefaultCoordinatorPort,
+		"port to listen for workers on")
+}
+
+func coordinate() error {
+	dataDir := viper.GetString(dataDirFlag)
+	if err := readScenario(viper.GetString(scenarioFlag)); err != nil {
+		return err
+	}
+	params, err := getParameters()
+	if err != nil {
+		return err
+	}
+	coordinator, err := sim.NewCoordinator(params, uint(viper.GetInt(nWorkersFlag)),
+		fmt.Sprintf(":%d", viper.GetInt(coordinatorPortFlag)))
+	if err != nil {
+		return err
+	}
+	summary, err := coordinator.Coordinate()
+	if err != nil {
+		return err
+	}
+	return writeSummary(summary, getResultsFilepath(dataDir))
+}
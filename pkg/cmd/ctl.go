@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/drausin/libri-experiments/pkg/sim"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	adminAddrFlag = "adminAddr"
+)
+
+var errMissingCtlArg = errors.New("rate and shares actions require a value argument")
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl [status|pause|resume|rate DOCS_PER_DAY|shares SHARES_PER_UPLOAD|reset|stop]",
+	Short: "control a running experiment",
+	Long: "control a running experiment through its admin endpoint: pause and resume event " +
+		"generation, override the docs per day or shares per upload of every phase (until " +
+		"reset), or end the run early; prints the resulting state",
+	Args: cobra.RangeArgs(1, 2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// bind here rather than in init since other commands share some flag names
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return control(args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ctlCmd)
+
+	ctlCmd.Flags().String(adminAddrFlag, sim.DefaultControlAddr,
+		"address (host:port) of the running experiment's admin endpoint")
+}
+
+func control(args []string) error {
+	client := sim.NewControlClient(viper.GetString(adminAddrFlag))
+	var state *sim.ControlState
+	var err error
+	switch args[0] {
+	case "status":
+		state, err = client.State()
+	case "pause":
+		state, err = client.Pause()
+	case "resume":
+		state, err = client.Resume()
+	case "rate", "shares":
+		if len(args) < 2 {
+			return errMissingCtlArg
+		}
+		var value uint64
+		if value, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			return err
+		}
+		if args[0] == "rate" {
+			state, err = client.SetDocsPerDay(uint(value))
+		} else {
+			state, err = client.SetSharesPerUpload(uint(value))
+		}
+	case "reset":
+		state, err = client.Reset()
+	case "stop":
+		state, err = client.Stop()
+	default:
+		return fmt.Errorf("unknown ctl action %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
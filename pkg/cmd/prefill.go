@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/drausin/libri-experiments/pkg/sim"
+	"github.com/drausin/libri/libri/common/parse"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	prefillDocsFlag     = "prefillDocs"
+	prefillGBFlag       = "prefillGB"
+	prefillManifestFlag = "prefillManifest"
+
+	defaultPrefillManifestFilename = "prefill-manifest.jsonl"
+)
+
+var errNoPrefillTarget = errors.New("prefill requires --prefillDocs or --prefillGB")
+
+// prefillCmd shares runCmd's experiment parameter flags (see run.go init), so a prefill generates
+// the same documents a run with the same flags would.
+var prefillCmd = &cobra.Command{
+	Use:   "prefill",
+	Short: "seed the cluster with documents",
+	Long: "upload and share documents as fast as allowed, without measuring them, and save the " +
+		"authors so later runs with --persistAuthors and the same data directory can re-read them",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// bind here rather than in init since other commands share some flag names
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return prefill()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(prefillCmd)
+}
+
+func prefill() error {
+	librarianAddrs, err := parse.Addrs(viper.GetStringSlice(librariansFlag))
+	if err != nil {
+		return err
+	}
+	dataDir := viper.GetString(dataDirFlag)
+	if err := readScenario(viper.GetString(scenarioFlag)); err != nil {
+		return err
+	}
+	params, err := getParameters()
+	if err != nil {
+		return err
+	}
+	if params.PrefillDocs == 0 && params.PrefillGB == 0 {
+		return errNoPrefillTarget
+	}
+	params.PersistAuthors = true
+	runner, err := sim.NewRunner(params, dataDir, librarianAddrs)
+	if err != nil {
+		return err
+	}
+	manifest, err := os.Create(getPrefillManifestFilepath(dataDir))
+	if err != nil {
+		return err
+	}
+	runner.WritePrefillManifest(manifest)
+	runner.Prefill()
+	return manifest.Close()
+}
+
+func getPrefillManifestFilepath(dataDir string) string {
+	if manifestFilepath := viper.GetString(prefillManifestFlag); manifestFilepath != "" {
+		return manifestFilepath
+	}
+	return filepath.Join(dataDir, defaultPrefillManifestFilename)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/drausin/libri-experiments/pkg/sim"
+	"github.com/drausin/libri/libri/common/parse"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	traceFlag = "trace"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "replay a recorded experiment trace",
+	Long: "replay the exact workload recorded by run --recordTrace, e.g., to compare two " +
+		"libri versions under identical load; it can be paused and resumed through the control " +
+		"endpoint, which rejects rate changes",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// bind here rather than in init since other commands share some flag names
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return replayExperiment()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+
+	// replay shares runCmd's experiment parameter flags (see run.go init), ignoring those set
+	// from the trace
+	replayCmd.Flags().String(traceFlag, "",
+		"trace file recorded by run --recordTrace")
+}
+
+func replayExperiment() error {
+	librarianAddrs, err := parse.Addrs(viper.GetStringSlice(librariansFlag))
+	if err != nil {
+		return err
+	}
+	dataDir := viper.GetString(dataDirFlag)
+	if err := readScenario(viper.GetString(scenarioFlag)); err != nil {
+		return err
+	}
+	traceFile, err := os.Open(viper.GetString(traceFlag))
+	if err != nil {
+		return err
+	}
+	defer traceFile.Close()
+	trace, err := sim.NewTraceReader(traceFile)
+	if err != nil {
+		return err
+	}
+	params, err := getParameters()
+	if err != nil {
+		return err
+	}
+	runner, err := sim.NewRunner(trace.ReplayParameters(params), dataDir, librarianAddrs)
+	if err != nil {
+		return err
+	}
+
+	runner.Replay(trace)
+	return writeSummary(runner.Summary(), getResultsFilepath(dataDir))
+}
@@ -1,31 +1,103 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
 	"github.com/drausin/libri-experiments/pkg/sim"
 	"github.com/drausin/libri/libri/common/parse"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
-	durationFlag                = "duration"
-	numAuthorsFlag              = "numAuthors"
-	docsPerDayFlag              = "docsPerDay"
-	contentSizeKBGammaShapeFlag = "contentSizeKBGammaShape"
-	contentSizeKBGammaRateFlag  = "contentSizeKBGammaRate"
-	sharesPerUploadFlag         = "sharesPerUpload"
-	downloadWaitMinFlag         = "downloadWaitMin"
-	downloadWaitMaxFlag         = "downloadWaitMax"
-	nUploadersFlag              = "nUploaders"
-	nDownloadersFlag            = "nDownloaders"
-	librariansFlag              = "librarians"
-	profileFlag                 = "profile"
+	durationFlag                    = "duration"
+	numAuthorsFlag                  = "numAuthors"
+	docsPerDayFlag                  = "docsPerDay"
+	contentSizeKBGammaShapeFlag     = "contentSizeKBGammaShape"
+	contentSizeKBGammaRateFlag      = "contentSizeKBGammaRate"
+	contentSizeDistFlag             = "contentSizeDist"
+	contentSizeKBLogNormalMuFlag    = "contentSizeKBLogNormalMu"
+	contentSizeKBLogNormalSigmaFlag = "contentSizeKBLogNormalSigma"
+	contentSizeKBParetoMinFlag      = "contentSizeKBParetoMin"
+	contentSizeKBParetoAlphaFlag    = "contentSizeKBParetoAlpha"
+	contentSizeKBFixedFlag          = "contentSizeKBFixed"
+	contentSizeMixtureFlag          = "contentSizeMixture"
+	contentSizeHistogramFlag        = "contentSizeHistogram"
+	contentTypesFlag                = "contentTypes"
+	largeDocFracFlag                = "largeDocFrac"
+	largeDocSizeMBMinFlag           = "largeDocSizeMBMin"
+	largeDocSizeMBMaxFlag           = "largeDocSizeMBMax"
+	sharesPerUploadFlag             = "sharesPerUpload"
+	downloadWaitMinFlag             = "downloadWaitMin"
+	downloadWaitMaxFlag             = "downloadWaitMax"
+	nUploadersFlag                  = "nUploaders"
+	nDownloadersFlag                = "nDownloaders"
+	maxUploadersFlag                = "maxUploaders"
+	maxDownloadersFlag              = "maxDownloaders"
+	openLoopFlag                    = "openLoop"
+	loadProfileFlag                 = "loadProfile"
+	loadRampStartFlag               = "loadRampStart"
+	loadRampEndFlag                 = "loadRampEnd"
+	loadRampDurationFlag            = "loadRampDuration"
+	loadStepDurationFlag            = "loadStepDuration"
+	loadStepLevelsFlag              = "loadStepLevels"
+	loadSinePeriodFlag              = "loadSinePeriod"
+	loadSineAmplitudeFlag           = "loadSineAmplitude"
+	loadSpikeIntervalFlag           = "loadSpikeInterval"
+	loadSpikeDurationFlag           = "loadSpikeDuration"
+	loadSpikeScaleFlag              = "loadSpikeScale"
+	socialGraphFlag                 = "socialGraph"
+	socialGraphMeanDegreeFlag       = "socialGraphMeanDegree"
+	socialGraphCommunitiesFlag      = "socialGraphCommunities"
+	socialGraphCrossFracFlag        = "socialGraphCrossFrac"
+	shareNeighborFracFlag           = "shareNeighborFrac"
+	activityFlag                    = "activity"
+	activityZipfExponentFlag        = "activityZipfExponent"
+	activityGammaShapeFlag          = "activityGammaShape"
+	reReadsPerDayFlag               = "reReadsPerDay"
+	popularityFlag                  = "popularity"
+	popularityZipfExponentFlag      = "popularityZipfExponent"
+	queryTimeoutsFlag               = "queryTimeouts"
+	drainPeriodFlag                 = "drainPeriod"
+	retryPoliciesFlag               = "retryPolicies"
+	retryableErrorsFlag             = "retryableErrors"
+	retryJitterFlag                 = "retryJitter"
+	pinLibrariansFlag               = "pinLibrarians"
+	persistAuthorsFlag              = "persistAuthors"
+	librariansFlag                  = "librarians"
+	profileFlag                     = "profile"
+	resultsFileFlag                 = "resultsFile"
+	scenarioFlag                    = "scenario"
+	seedFlag                        = "seed"
+	recordTraceFlag                 = "recordTrace"
+	tuiFlag                         = "tui"
+	coordinatorFlag                 = "coordinator"
+	workerIDFlag                    = "workerID"
+	adminPortFlag                   = "adminPort"
+
+	// phasesKey is the scenario file key for the list of experiment phases
+	phasesKey = "phases"
+
+	defaultResultsFilename = "summary.json"
+	tuiLogFilename         = "libri-exp.log"
 )
 
+var errMissingWorkerID = errors.New("running with --coordinator requires --workerID")
+
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "run an experiment",
 	Long:  "run an experiment",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// bind here rather than in init since other commands share some flag names
+		return viper.BindPFlags(cmd.Flags())
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runExperiment()
 	},
@@ -34,33 +106,172 @@ var runCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(runCmd)
 
-	runCmd.Flags().StringSliceP(librariansFlag, "a", nil,
-		"comma-separated addresses (IPv4:Port) of librarian(s)")
-	runCmd.Flags().Duration(durationFlag, sim.DefaultDuration,
+	// experiment parameters, which prefill uses to generate the same docs as run, coordinate hands
+	// out to its workers, and replay takes its query, retry, and drain settings from
+	parameterFlags := pflag.NewFlagSet("parameters", pflag.ExitOnError)
+	parameterFlags.Duration(durationFlag, sim.DefaultDuration,
 		"experiment duration")
-	runCmd.Flags().Uint(numAuthorsFlag, sim.DefaultNAuthors,
+	parameterFlags.Uint(numAuthorsFlag, sim.DefaultNAuthors,
 		"number of authors (users)")
-	runCmd.Flags().Uint(docsPerDayFlag, sim.DefaultDocsPerDay,
+	parameterFlags.Uint(docsPerDayFlag, sim.DefaultDocsPerDay,
 		"number docs an author uploads per day")
-	runCmd.Flags().Float64(contentSizeKBGammaShapeFlag, sim.DefaultContentSizeKBGammaShape,
+	parameterFlags.Float64(contentSizeKBGammaShapeFlag, sim.DefaultContentSizeKBGammaShape,
 		"shape param of gamma distribution for content size (KBs)")
-	runCmd.Flags().Float64(contentSizeKBGammaRateFlag, sim.DefaultContentSizeKBGammaRate,
+	parameterFlags.Float64(contentSizeKBGammaRateFlag, sim.DefaultContentSizeKBGammaRate,
 		"rate param of gamma distribution for content size (KBs)")
-	runCmd.Flags().Uint(sharesPerUploadFlag, sim.DefaultSharesPerUpload,
+	parameterFlags.String(contentSizeDistFlag, sim.DefaultContentSizeDist,
+		"content size distribution [gamma|lognormal|pareto|fixed|mixture|empirical]")
+	parameterFlags.Float64(contentSizeKBLogNormalMuFlag, sim.DefaultContentSizeKBLogNormalMu,
+		"mu (mean of log) param of lognormal distribution for content size (KBs)")
+	parameterFlags.Float64(contentSizeKBLogNormalSigmaFlag,
+		sim.DefaultContentSizeKBLogNormalSigma,
+		"sigma (std dev of log) param of lognormal distribution for content size (KBs)")
+	parameterFlags.Float64(contentSizeKBParetoMinFlag, sim.DefaultContentSizeKBParetoMin,
+		"min param of pareto distribution for content size (KBs)")
+	parameterFlags.Float64(contentSizeKBParetoAlphaFlag, sim.DefaultContentSizeKBParetoAlpha,
+		"alpha (shape) param of pareto distribution for content size (KBs)")
+	parameterFlags.Float64(contentSizeKBFixedFlag, sim.DefaultContentSizeKBFixed,
+		"content size (KBs) for fixed distribution")
+	parameterFlags.StringSlice(contentSizeMixtureFlag, sim.DefaultContentSizeMixture,
+		"comma-separated weight:dist:param[:param] components of mixture content size "+
+			"distribution, where dist is gamma, lognormal, pareto, or fixed")
+	parameterFlags.String(contentSizeHistogramFlag, "",
+		"CSV file with lower_kb,upper_kb,count rows for empirical content size distribution")
+	parameterFlags.StringSlice(contentTypesFlag, sim.DefaultContentTypes,
+		"comma-separated weight:generator:mediaType content types, where generator is random, "+
+			"text, or repetitive; the author compresses all but already-compressed media types")
+	parameterFlags.Float64(largeDocFracFlag, sim.DefaultLargeDocFrac,
+		"fraction of uploads that are large, multi-page documents")
+	parameterFlags.Float64(largeDocSizeMBMinFlag, sim.DefaultLargeDocSizeMBMin,
+		"lower bound of log-uniform distribution for large document size (MBs)")
+	parameterFlags.Float64(largeDocSizeMBMaxFlag, sim.DefaultLargeDocSizeMBMax,
+		"upper bound of log-uniform distribution for large document size (MBs)")
+	parameterFlags.Uint(sharesPerUploadFlag, sim.DefaultSharesPerUpload,
 		"number of times each uploaded doc is shared")
-	runCmd.Flags().Duration(downloadWaitMinFlag, sim.DefaultDownloadWaitMin,
+	parameterFlags.Duration(downloadWaitMinFlag, sim.DefaultDownloadWaitMin,
 		"lower bound of uniform distribution for wait time before downloading")
-	runCmd.Flags().Duration(downloadWaitMaxFlag, sim.DefaultDownloadWaitMax,
+	parameterFlags.Duration(downloadWaitMaxFlag, sim.DefaultDownloadWaitMax,
 		"upper bound of uniform distribution for wait time before downloading")
-	runCmd.Flags().Uint(nUploadersFlag, sim.DefaultNUploaders,
+	parameterFlags.Uint(nUploadersFlag, sim.DefaultNUploaders,
 		"number of uploader workers")
-	runCmd.Flags().Uint(nDownloadersFlag, sim.DefaultNDownloaders,
+	parameterFlags.Uint(nDownloadersFlag, sim.DefaultNDownloaders,
 		"number of downloader workers")
+	parameterFlags.Uint(maxUploadersFlag, sim.DefaultMaxUploaders,
+		"max number of uploader workers when the upload queue is backlogged")
+	parameterFlags.Uint(maxDownloadersFlag, sim.DefaultMaxDownloaders,
+		"max number of downloader workers when the download queue is backlogged")
+	parameterFlags.Bool(openLoopFlag, sim.DefaultOpenLoop,
+		"schedule events independently of query latency and measure latency from intended start")
+	parameterFlags.String(loadProfileFlag, sim.DefaultLoadProfile,
+		"shape of upload rate over time [constant|ramp|step|sine|spike]")
+	parameterFlags.Float64(loadRampStartFlag, sim.DefaultLoadRampStart,
+		"multiple of upload rate at start of ramp load profile")
+	parameterFlags.Float64(loadRampEndFlag, sim.DefaultLoadRampEnd,
+		"multiple of upload rate at end of ramp load profile")
+	parameterFlags.Duration(loadRampDurationFlag, sim.DefaultLoadRampDuration,
+		"time to ramp from start to end multiple of upload rate")
+	parameterFlags.Duration(loadStepDurationFlag, sim.DefaultLoadStepDuration,
+		"duration of each level in step load profile")
+	parameterFlags.StringSlice(loadStepLevelsFlag, formatFloats(sim.DefaultLoadStepLevels),
+		"comma-separated multiples of upload rate for each level in step load profile")
+	parameterFlags.Duration(loadSinePeriodFlag, sim.DefaultLoadSinePeriod,
+		"period of sine load profile")
+	parameterFlags.Float64(loadSineAmplitudeFlag, sim.DefaultLoadSineAmplitude,
+		"amplitude (as multiple of upload rate) of sine load profile")
+	parameterFlags.Duration(loadSpikeIntervalFlag, sim.DefaultLoadSpikeInterval,
+		"time between starts of spikes in spike load profile")
+	parameterFlags.Duration(loadSpikeDurationFlag, sim.DefaultLoadSpikeDuration,
+		"duration of each spike in spike load profile")
+	parameterFlags.Float64(loadSpikeScaleFlag, sim.DefaultLoadSpikeScale,
+		"multiple of upload rate during each spike in spike load profile")
+	parameterFlags.String(socialGraphFlag, sim.DefaultSocialGraph,
+		"social graph authors mostly share within [uniform|preferential|communities]")
+	parameterFlags.Float64(socialGraphMeanDegreeFlag, sim.DefaultSocialGraphMeanDegree,
+		"mean number of neighbors of each author in the social graph")
+	parameterFlags.Uint(socialGraphCommunitiesFlag, sim.DefaultSocialGraphCommunities,
+		"number of communities in communities social graph")
+	parameterFlags.Float64(socialGraphCrossFracFlag, sim.DefaultSocialGraphCrossFrac,
+		"fraction of each author's neighbors outside its community in communities social graph")
+	parameterFlags.Float64(shareNeighborFracFlag, sim.DefaultShareNeighborFrac,
+		"fraction of shares to a social graph neighbor rather than a random author")
+	parameterFlags.String(activityFlag, sim.DefaultActivity,
+		"distribution of upload and share activity over authors [uniform|zipf|gamma]")
+	parameterFlags.Float64(activityZipfExponentFlag, sim.DefaultActivityZipfExponent,
+		"exponent of zipf activity distribution; larger gives more skew")
+	parameterFlags.Float64(activityGammaShapeFlag, sim.DefaultActivityGammaShape,
+		"shape of gamma activity distribution; smaller gives more skew")
+	parameterFlags.Uint(reReadsPerDayFlag, sim.DefaultReReadsPerDay,
+		"number of times per day each author re-reads a previously shared doc")
+	parameterFlags.String(popularityFlag, sim.DefaultPopularity,
+		"popularity model for choosing docs to re-read [zipf|recency]")
+	parameterFlags.Float64(popularityZipfExponentFlag, sim.DefaultPopularityZipfExponent,
+		"exponent (> 1) of doc popularity distribution; larger gives hotter docs")
+	parameterFlags.Uint(prefillDocsFlag, sim.DefaultPrefillDocs,
+		"number of docs to upload and share, unmeasured, before the experiment starts")
+	parameterFlags.Float64(prefillGBFlag, sim.DefaultPrefillGB,
+		"size (GBs) of content to upload and share, unmeasured, before the experiment starts")
+	parameterFlags.StringSlice(queryTimeoutsFlag, sim.DefaultQueryTimeouts,
+		"query timeouts of form operation:timeout, e.g., download:30s; operations without a "+
+			"timeout wait for the author library")
+	parameterFlags.Duration(drainPeriodFlag, sim.DefaultDrainPeriod,
+		"max time after stopping for in-flight uploads to finish and pending downloads to run")
+	parameterFlags.StringSlice(retryPoliciesFlag, nil,
+		"retry policies of form operation:maxAttempts:initialBackoff:maxBackoff[:class/class/...], "+
+			"e.g., download:5:100ms:10s; operations without a policy aren't retried")
+	parameterFlags.StringSlice(retryableErrorsFlag, sim.DefaultRetryableErrors,
+		"error classes retried by retry policies that don't list their own, except timeout "+
+			"for upload and share policies, whose timed-out attempts may still complete")
+	parameterFlags.Float64(retryJitterFlag, sim.DefaultRetryJitter,
+		"max fraction of each retry backoff randomly removed from it")
+	parameterFlags.Bool(pinLibrariansFlag, sim.DefaultPinLibrarians,
+		"pin each author to a single librarian, assigned round-robin, to break down queries "+
+			"by the pinned librarian they're sent to, which may query other librarians in turn")
+	parameterFlags.Bool(persistAuthorsFlag, sim.DefaultPersistAuthors,
+		"save author keychains and received docs to the data directory, loading those saved "+
+			"by earlier runs")
+	parameterFlags.Int64(seedFlag, sim.DefaultSeed,
+		"seed for all random sampling; use different seeds for concurrent sims")
+	parameterFlags.String(scenarioFlag, "",
+		"YAML/JSON scenario file with parameters (keyed by flag name) and a list of phases")
+	addFlags(parameterFlags, runCmd, prefillCmd, coordinateCmd, replayCmd)
+
+	librarianFlags := pflag.NewFlagSet("librarians", pflag.ExitOnError)
+	librarianFlags.StringSliceP(librariansFlag, "a", nil,
+		"comma-separated addresses (IPv4:Port) of librarian(s)")
+	addFlags(librarianFlags, runCmd, prefillCmd, replayCmd)
+
+	resultsFlags := pflag.NewFlagSet("results", pflag.ExitOnError)
+	resultsFlags.String(resultsFileFlag, "",
+		"JSON run summary output file (default summary.json in data directory)")
+	addFlags(resultsFlags, runCmd, coordinateCmd, replayCmd)
+
+	prefillManifestFlags := pflag.NewFlagSet("prefillManifest", pflag.ExitOnError)
+	prefillManifestFlags.String(prefillManifestFlag, "",
+		"JSON lines file to record the envelope key and recipient of each prefilled doc to "+
+			"(default prefill-manifest.jsonl in data directory)")
+	addFlags(prefillManifestFlags, runCmd, prefillCmd)
+
+	// flags only run takes, e.g., to run as a coordinator's worker
 	runCmd.Flags().Bool(profileFlag, false,
 		"enable /debug/pprof profiler endpoint")
+	runCmd.Flags().String(recordTraceFlag, "",
+		"file to record every generated event to for later replay")
+	runCmd.Flags().Bool(tuiFlag, false,
+		"show a live dashboard instead of logs, which go to libri-exp.log in the data directory")
+	runCmd.Flags().String(coordinatorFlag, "",
+		"address (host:port) of a coordinator to run as a worker of, taking the experiment "+
+			"parameters from it instead of the flags")
+	runCmd.Flags().String(workerIDFlag, "",
+		"index of the worker among the coordinator's workers, from 0 to nWorkers - 1; required "+
+			"with --coordinator")
+	runCmd.Flags().Int(adminPortFlag, sim.DefaultAdminPort,
+		"port to serve the /metrics and /control admin endpoints on")
+}
 
-	if err := viper.BindPFlags(runCmd.Flags()); err != nil {
-		panic(err)
+// addFlags adds the given flags to each of the commands.
+func addFlags(flags *pflag.FlagSet, cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.Flags().AddFlagSet(flags)
 	}
 }
 
@@ -70,26 +281,236 @@ func runExperiment() error {
 		return err
 	}
 	dataDir := viper.GetString(dataDirFlag)
-	params := getParameters()
-	runner := sim.NewRunner(params, dataDir, librarianAddrs)
+	if err := readScenario(viper.GetString(scenarioFlag)); err != nil {
+		return err
+	}
+	params, err := getParameters()
+	if err != nil {
+		return err
+	}
+	var coordinator *sim.CoordinatorClient
+	var assignment *sim.Assignment
+	if coordinatorAddr := viper.GetString(coordinatorFlag); coordinatorAddr != "" {
+		worker, err := getWorker()
+		if err != nil {
+			return err
+		}
+		coordinator = sim.NewCoordinatorClient(coordinatorAddr)
+		if assignment, err = coordinator.Register(worker); err != nil {
+			return err
+		}
+		params = assignment.Params
+	}
+	logOut := io.Writer(os.Stderr)
+	if viper.GetBool(tuiFlag) {
+		// send logs, including the author libraries', to a file so they don't garble the
+		// dashboard
+		logFile, err := os.Create(filepath.Join(dataDir, tuiLogFilename))
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		logOut = logFile
+	}
+	runner, err := sim.NewRunnerWithLog(params, dataDir, librarianAddrs, logOut)
+	if err != nil {
+		return err
+	}
+	runner.SetAdminAddr(fmt.Sprintf(":%d", viper.GetInt(adminPortFlag)))
+	if viper.GetBool(tuiFlag) {
+		runner.ShowDashboard(os.Stdout)
+	}
+	run := func() error {
+		if assignment == nil {
+			runner.Run()
+			return nil
+		}
+		return runner.RunWorker(coordinator, assignment.Worker)
+	}
+
+	if params.PrefillDocs > 0 || params.PrefillGB > 0 {
+		manifest, err := os.Create(getPrefillManifestFilepath(dataDir))
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		runner.WritePrefillManifest(manifest)
+	}
+
+	traceFilepath := viper.GetString(recordTraceFlag)
+	if traceFilepath == "" {
+		if err := run(); err != nil {
+			return err
+		}
+		return writeResults(runner, coordinator, assignment, dataDir)
+	}
+	traceFile, err := os.Create(traceFilepath)
+	if err != nil {
+		return err
+	}
+	trace, err := sim.NewTraceWriter(traceFile, params)
+	if err != nil {
+		return err
+	}
+	runner.RecordTrace(trace)
+	if err := run(); err != nil {
+		return err
+	}
+	if err := trace.Close(); err != nil {
+		return err
+	}
+	if err := traceFile.Close(); err != nil {
+		return err
+	}
+	return writeResults(runner, coordinator, assignment, dataDir)
+}
+
+// writeResults writes the run's summary and, if running as a worker, submits its result to the
+// coordinator.
+func writeResults(
+	runner *sim.Runner, coordinator *sim.CoordinatorClient, assignment *sim.Assignment,
+	dataDir string,
+) error {
+	if err := writeSummary(runner.Summary(), getResultsFilepath(dataDir)); err != nil {
+		return err
+	}
+	if coordinator == nil {
+		return nil
+	}
+	return coordinator.SubmitResult(runner.WorkerResult(assignment.Worker))
+}
 
-	runner.Run()
-	return nil
+// getWorker returns the worker index given by the worker ID flag. The index, rather than the
+// order workers register in, determines a worker's shard, so it gets the same shard (and data
+// directory) across runs.
+func getWorker() (uint, error) {
+	workerID := viper.GetString(workerIDFlag)
+	if workerID == "" {
+		return 0, errMissingWorkerID
+	}
+	worker, err := strconv.ParseUint(workerID, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid worker ID %q: %s", workerID, err)
+	}
+	return uint(worker), nil
 }
 
-func getParameters() *sim.Parameters {
+func getResultsFilepath(dataDir string) string {
+	if resultsFilepath := viper.GetString(resultsFileFlag); resultsFilepath != "" {
+		return resultsFilepath
+	}
+	return filepath.Join(dataDir, defaultResultsFilename)
+}
+
+func writeSummary(summary *sim.Summary, resultsFilepath string) error {
+	out, err := os.Create(resultsFilepath)
+	if err != nil {
+		return err
+	}
+	if err := summary.Write(out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func getParameters() (*sim.Parameters, error) {
+	loadStepLevels, err := parseFloats(viper.GetStringSlice(loadStepLevelsFlag))
+	if err != nil {
+		return nil, err
+	}
+	var phases []*sim.Phase
+	if err := viper.UnmarshalKey(phasesKey, &phases); err != nil {
+		return nil, err
+	}
 	return &sim.Parameters{
-		Duration:                viper.GetDuration(durationFlag),
-		NAuthors:                uint(viper.GetInt(numAuthorsFlag)),
-		DocsPerDay:              uint(viper.GetInt(docsPerDayFlag)),
-		ContentSizeKBGammaShape: viper.GetFloat64(contentSizeKBGammaShapeFlag),
-		ContentSizeKBGammaRate:  viper.GetFloat64(contentSizeKBGammaRateFlag),
-		SharesPerUpload:         uint(viper.GetInt(sharesPerUploadFlag)),
-		DownloadWaitMin:         viper.GetDuration(downloadWaitMinFlag),
-		DownloadWaitMax:         viper.GetDuration(downloadWaitMaxFlag),
-		NUploaders:              uint(viper.GetInt(nUploadersFlag)),
-		NDownloaders:            uint(viper.GetInt(nDownloadersFlag)),
-		Profile:                 viper.GetBool(profileFlag),
-		LogLevel:                viper.GetString(logLevelFlag),
+		Duration:                    viper.GetDuration(durationFlag),
+		NAuthors:                    uint(viper.GetInt(numAuthorsFlag)),
+		DocsPerDay:                  uint(viper.GetInt(docsPerDayFlag)),
+		ContentSizeKBGammaShape:     viper.GetFloat64(contentSizeKBGammaShapeFlag),
+		ContentSizeKBGammaRate:      viper.GetFloat64(contentSizeKBGammaRateFlag),
+		ContentSizeDist:             viper.GetString(contentSizeDistFlag),
+		ContentSizeKBLogNormalMu:    viper.GetFloat64(contentSizeKBLogNormalMuFlag),
+		ContentSizeKBLogNormalSigma: viper.GetFloat64(contentSizeKBLogNormalSigmaFlag),
+		ContentSizeKBParetoMin:      viper.GetFloat64(contentSizeKBParetoMinFlag),
+		ContentSizeKBParetoAlpha:    viper.GetFloat64(contentSizeKBParetoAlphaFlag),
+		ContentSizeKBFixed:          viper.GetFloat64(contentSizeKBFixedFlag),
+		ContentSizeMixture:          viper.GetStringSlice(contentSizeMixtureFlag),
+		ContentSizeHistogram:        viper.GetString(contentSizeHistogramFlag),
+		ContentTypes:                viper.GetStringSlice(contentTypesFlag),
+		LargeDocFrac:                viper.GetFloat64(largeDocFracFlag),
+		LargeDocSizeMBMin:           viper.GetFloat64(largeDocSizeMBMinFlag),
+		LargeDocSizeMBMax:           viper.GetFloat64(largeDocSizeMBMaxFlag),
+		SharesPerUpload:             uint(viper.GetInt(sharesPerUploadFlag)),
+		DownloadWaitMin:             viper.GetDuration(downloadWaitMinFlag),
+		DownloadWaitMax:             viper.GetDuration(downloadWaitMaxFlag),
+		NUploaders:                  uint(viper.GetInt(nUploadersFlag)),
+		NDownloaders:                uint(viper.GetInt(nDownloadersFlag)),
+		MaxUploaders:                uint(viper.GetInt(maxUploadersFlag)),
+		MaxDownloaders:              uint(viper.GetInt(maxDownloadersFlag)),
+		OpenLoop:                    viper.GetBool(openLoopFlag),
+		LoadProfile:                 viper.GetString(loadProfileFlag),
+		LoadRampStart:               viper.GetFloat64(loadRampStartFlag),
+		LoadRampEnd:                 viper.GetFloat64(loadRampEndFlag),
+		LoadRampDuration:            viper.GetDuration(loadRampDurationFlag),
+		LoadStepDuration:            viper.GetDuration(loadStepDurationFlag),
+		LoadStepLevels:              loadStepLevels,
+		LoadSinePeriod:              viper.GetDuration(loadSinePeriodFlag),
+		LoadSineAmplitude:           viper.GetFloat64(loadSineAmplitudeFlag),
+		LoadSpikeInterval:           viper.GetDuration(loadSpikeIntervalFlag),
+		LoadSpikeDuration:           viper.GetDuration(loadSpikeDurationFlag),
+		LoadSpikeScale:              viper.GetFloat64(loadSpikeScaleFlag),
+		SocialGraph:                 viper.GetString(socialGraphFlag),
+		SocialGraphMeanDegree:       viper.GetFloat64(socialGraphMeanDegreeFlag),
+		SocialGraphCommunities:      uint(viper.GetInt(socialGraphCommunitiesFlag)),
+		SocialGraphCrossFrac:        viper.GetFloat64(socialGraphCrossFracFlag),
+		ShareNeighborFrac:           viper.GetFloat64(shareNeighborFracFlag),
+		Activity:                    viper.GetString(activityFlag),
+		ActivityZipfExponent:        viper.GetFloat64(activityZipfExponentFlag),
+		ActivityGammaShape:          viper.GetFloat64(activityGammaShapeFlag),
+		ReReadsPerDay:               uint(viper.GetInt(reReadsPerDayFlag)),
+		Popularity:                  viper.GetString(popularityFlag),
+		PopularityZipfExponent:      viper.GetFloat64(popularityZipfExponentFlag),
+		PrefillDocs:                 uint(viper.GetInt(prefillDocsFlag)),
+		PrefillGB:                   viper.GetFloat64(prefillGBFlag),
+		QueryTimeouts:               viper.GetStringSlice(queryTimeoutsFlag),
+		DrainPeriod:                 viper.GetDuration(drainPeriodFlag),
+		RetryPolicies:               viper.GetStringSlice(retryPoliciesFlag),
+		RetryableErrors:             viper.GetStringSlice(retryableErrorsFlag),
+		RetryJitter:                 viper.GetFloat64(retryJitterFlag),
+		PinLibrarians:               viper.GetBool(pinLibrariansFlag),
+		PersistAuthors:              viper.GetBool(persistAuthorsFlag),
+		Phases:                      phases,
+		Seed:                        viper.GetInt64(seedFlag),
+		Profile:                     viper.GetBool(profileFlag),
+		LogLevel:                    viper.GetString(logLevelFlag),
+	}, nil
+}
+
+// readScenario reads the parameters and phases in the given scenario file into viper. Flags given
+// explicitly still take precedence over scenario file values.
+func readScenario(scenarioFilepath string) error {
+	if scenarioFilepath == "" {
+		return nil
+	}
+	viper.SetConfigFile(scenarioFilepath)
+	return viper.ReadInConfig()
+}
+
+func parseFloats(strs []string) ([]float64, error) {
+	floats := make([]float64, len(strs))
+	for i, str := range strs {
+		var err error
+		if floats[i], err = strconv.ParseFloat(str, 64); err != nil {
+			return nil, err
+		}
+	}
+	return floats, nil
+}
+
+func formatFloats(floats []float64) []string {
+	strs := make([]string, len(floats))
+	for i, f := range floats {
+		strs[i] = strconv.FormatFloat(f, 'g', -1, 64)
 	}
+	return strs
 }
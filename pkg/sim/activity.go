@@ -0,0 +1,136 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	erand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	// UniformActivity gives every author the same chance of uploading and receiving shares.
+	UniformActivity = "uniform"
+
+	// ZipfActivity gives the author with activity rank k a weight proportional to 1/k^s for
+	// exponent s, so a few heavy authors dominate and there is a long tail of light ones.
+	ZipfActivity = "zipf"
+
+	// GammaActivity draws each author's weight from a gamma distribution with unit mean, where
+	// smaller shapes give more skewed activity.
+	GammaActivity = "gamma"
+
+	// topActivityFrac is the fraction of most active authors whose share of events the summary
+	// reports.
+	topActivityFrac = 0.1
+)
+
+// newActivityWeights returns the relative activity of each author, or nil for uniform activity.
+func newActivityWeights(params *Parameters, rng *rand.Rand) ([]float64, error) {
+	n := int(params.NAuthors)
+	switch params.Activity {
+	case UniformActivity, "":
+		return nil, nil
+	case ZipfActivity:
+		if params.ActivityZipfExponent <= 0 {
+			return nil, fmt.Errorf("%s activity requires a positive exponent", ZipfActivity)
+		}
+		// shuffle ranks so activity is independent of author index, which other parts of the
+		// sim (e.g., social graphs) may not be
+		weights := make([]float64, n)
+		for i, rank := range rng.Perm(n) {
+			weights[i] = math.Pow(float64(rank+1), -params.ActivityZipfExponent)
+		}
+		return weights, nil
+	case GammaActivity:
+		if params.ActivityGammaShape <= 0 {
+			return nil, fmt.Errorf("%s activity requires a positive shape", GammaActivity)
+		}
+		g := &distuv.Gamma{
+			Alpha: params.ActivityGammaShape,
+			Beta:  params.ActivityGammaShape, // mean = alpha / beta = 1
+			Src:   erand.New(erand.NewSource(rng.Uint64())),
+		}
+		weights := make([]float64, n)
+		for i := range weights {
+			weights[i] = g.Rand()
+		}
+		return weights, nil
+	}
+	return nil, fmt.Errorf("unknown activity distribution %q", params.Activity)
+}
+
+// weightedIndex samples indices with probability proportional to their weights.
+type weightedIndex struct {
+	cum []float64
+}
+
+func newWeightedIndex(weights []float64) *weightedIndex {
+	cum := make([]float64, len(weights))
+	total := 0.0
+	for i, w := range weights {
+		total += w
+		cum[i] = total
+	}
+	return &weightedIndex{cum: cum}
+}
+
+func (w *weightedIndex) sample(rng *rand.Rand) int {
+	u := rng.Float64() * w.cum[len(w.cum)-1]
+	return sort.Search(len(w.cum), func(i int) bool { return w.cum[i] > u })
+}
+
+// authorActivity counts the uploads and received shares of each author.
+type authorActivity struct {
+	uploads []uint64
+	shares  []uint64
+	mu      sync.Mutex
+}
+
+func newAuthorActivity(nAuthors uint) *authorActivity {
+	return &authorActivity{
+		uploads: make([]uint64, nAuthors),
+		shares:  make([]uint64, nAuthors),
+	}
+}
+
+func (a *authorActivity) record(event *uploadEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.uploads[event.fromIdx]++
+	for _, i := range event.shareWithIdxs {
+		a.shares[i]++
+	}
+}
+
+func (a *authorActivity) summary() *AuthorsSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &AuthorsSummary{
+		Uploads:               append([]uint64(nil), a.uploads...),
+		SharesReceived:        append([]uint64(nil), a.shares...),
+		TopUploadsFrac:        topFrac(a.uploads, topActivityFrac),
+		TopSharesReceivedFrac: topFrac(a.shares, topActivityFrac),
+	}
+}
+
+// topFrac returns the fraction of the total count from the given fraction of largest counts.
+func topFrac(counts []uint64, frac float64) float64 {
+	sorted := append([]uint64(nil), counts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	nTop := int(math.Ceil(frac * float64(len(sorted))))
+	top, total := uint64(0), uint64(0)
+	for i, c := range sorted {
+		if i < nTop {
+			top += c
+		}
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(top) / float64(total)
+}
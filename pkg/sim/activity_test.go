@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewActivityWeights(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	params := newDefaultParameters()
+	params.NAuthors = 100
+
+	params.Activity = UniformActivity
+	weights, err := newActivityWeights(params, rng)
+	assert.Nil(t, err)
+	assert.Nil(t, weights)
+
+	params.Activity = ZipfActivity
+	weights, err = newActivityWeights(params, rng)
+	assert.Nil(t, err)
+	assert.Len(t, weights, 100)
+	assert.Contains(t, weights, 1.0)
+	assert.Contains(t, weights, 1.0/100)
+
+	params.Activity = GammaActivity
+	weights, err = newActivityWeights(params, rng)
+	assert.Nil(t, err)
+	assert.Len(t, weights, 100)
+	for _, w := range weights {
+		assert.True(t, w > 0)
+	}
+}
+
+func TestNewActivityWeights_err(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	cases := []func(p *Parameters){
+		func(p *Parameters) { p.Activity = "unknown" },
+		func(p *Parameters) {
+			p.Activity = ZipfActivity
+			p.ActivityZipfExponent = 0
+		},
+		func(p *Parameters) {
+			p.Activity = GammaActivity
+			p.ActivityGammaShape = -1
+		},
+	}
+	for i, c := range cases {
+		params := newDefaultParameters()
+		c(params)
+		weights, err := newActivityWeights(params, rng)
+		assert.NotNil(t, err, i)
+		assert.Nil(t, weights, i)
+	}
+}
+
+func TestWeightedIndex_sample(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	w := newWeightedIndex([]float64{1, 0, 3})
+	counts := make([]int, 3)
+	n := 10000
+	for c := 0; c < n; c++ {
+		counts[w.sample(rng)]++
+	}
+	assert.InDelta(t, 0.25, float64(counts[0])/float64(n), 0.02)
+	assert.Equal(t, 0, counts[1])
+	assert.InDelta(t, 0.75, float64(counts[2])/float64(n), 0.02)
+}
+
+func TestAuthorActivity(t *testing.T) {
+	a := newAuthorActivity(10)
+	for c := 0; c < 9; c++ {
+		a.record(&uploadEvent{fromIdx: 0, shareWithIdxs: []int{1, 2}})
+	}
+	a.record(&uploadEvent{fromIdx: 3, shareWithIdxs: []int{2, 4}})
+	s := a.summary()
+	assert.Equal(t, []uint64{9, 0, 0, 1, 0, 0, 0, 0, 0, 0}, s.Uploads)
+	assert.Equal(t, []uint64{0, 9, 10, 0, 1, 0, 0, 0, 0, 0}, s.SharesReceived)
+	assert.Equal(t, 0.9, s.TopUploadsFrac)
+	assert.Equal(t, 0.5, s.TopSharesReceivedFrac)
+}
+
+func TestTopFrac(t *testing.T) {
+	assert.Equal(t, 0.0, topFrac([]uint64{0, 0}, 0.1))
+	assert.Equal(t, 1.0, topFrac([]uint64{5}, 0.1))
+	assert.Equal(t, 0.5, topFrac([]uint64{1, 1, 1, 1}, 0.5))
+}
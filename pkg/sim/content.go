@@ -0,0 +1,52 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+)
+
+// newContent generates content of the given size from the given seed with the given generator,
+// so the same size, seed, and generator always give the same content.
+func newContent(size int, seed int64, generator string) *bytes.Buffer {
+	rng := rand.New(rand.NewSource(seed))
+	content := make([]byte, size)
+	switch generator {
+	case TextContent:
+		generateText(content, rng)
+	case RepetitiveContent:
+		generateRepetitive(content, rng)
+	default:
+		generateRandom(content, rng)
+	}
+	return bytes.NewBuffer(content)
+}
+
+// contentDigest identifies uploaded content so downloads can be verified against it.
+type contentDigest struct {
+	sum  [sha256.Size]byte
+	size int
+}
+
+func newContentDigest(content []byte) contentDigest {
+	return contentDigest{
+		sum:  sha256.Sum256(content),
+		size: len(content),
+	}
+}
+
+// check returns the outcome of comparing the digest of downloaded content against the digest of
+// the uploaded content: success if they match, missing if nothing was downloaded, truncated if
+// the downloaded content is shorter than what was uploaded, and corrupt otherwise.
+func (d contentDigest) check(downloaded contentDigest) string {
+	if downloaded == d {
+		return successOutcome
+	}
+	if downloaded.size == 0 {
+		return missingOutcome
+	}
+	if downloaded.size < d.size {
+		return truncatedOutcome
+	}
+	return corruptOutcome
+}
@@ -0,0 +1,332 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	erand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	// GammaContentSize samples content sizes from a gamma distribution.
+	GammaContentSize = "gamma"
+
+	// LogNormalContentSize samples content sizes from a log-normal distribution.
+	LogNormalContentSize = "lognormal"
+
+	// ParetoContentSize samples content sizes from a Pareto distribution.
+	ParetoContentSize = "pareto"
+
+	// FixedContentSize gives all content the same size.
+	FixedContentSize = "fixed"
+
+	// MixtureContentSize samples content sizes from a weighted mixture of the gamma, log-normal,
+	// Pareto, and fixed distributions, e.g., to model bimodal sizes.
+	MixtureContentSize = "mixture"
+
+	// EmpiricalContentSize samples content sizes from a histogram of real document sizes.
+	EmpiricalContentSize = "empirical"
+
+	// maxContentSizeKB bounds sampled content sizes so heavy-tailed distributions can't generate
+	// content too large to hold in memory.
+	maxContentSizeKB = 64 * 1024
+)
+
+// randSampler samples random values, e.g., from a gonum distribution.
+type randSampler interface {
+	Rand() float64
+}
+
+// contentSizeDist is a distribution of content sizes in KB.
+type contentSizeDist interface {
+	// sampler returns a sampler of content sizes drawing randomness from the given source.
+	sampler(src erand.Source) randSampler
+}
+
+// newContentSizeDist returns the phase's content size distribution.
+func newContentSizeDist(phase *Phase) (contentSizeDist, error) {
+	switch phase.ContentSizeDist {
+	case GammaContentSize, "":
+		return newComponentSizeDist(GammaContentSize, []float64{
+			phase.ContentSizeKBGammaShape, phase.ContentSizeKBGammaRate,
+		})
+	case LogNormalContentSize:
+		return newComponentSizeDist(LogNormalContentSize, []float64{
+			phase.ContentSizeKBLogNormalMu, phase.ContentSizeKBLogNormalSigma,
+		})
+	case ParetoContentSize:
+		return newComponentSizeDist(ParetoContentSize, []float64{
+			phase.ContentSizeKBParetoMin, phase.ContentSizeKBParetoAlpha,
+		})
+	case FixedContentSize:
+		return newComponentSizeDist(FixedContentSize, []float64{phase.ContentSizeKBFixed})
+	case MixtureContentSize:
+		return newMixtureSizeDist(phase.ContentSizeMixture)
+	case EmpiricalContentSize:
+		return readEmpiricalSizeDist(phase.ContentSizeHistogram)
+	}
+	return nil, fmt.Errorf("unknown content size distribution %q", phase.ContentSizeDist)
+}
+
+// newComponentSizeDist returns a gamma, log-normal, Pareto, or fixed distribution with the given
+// parameters.
+func newComponentSizeDist(dist string, params []float64) (contentSizeDist, error) {
+	nParams := 2
+	if dist == FixedContentSize {
+		nParams = 1
+	}
+	if len(params) != nParams {
+		return nil, fmt.Errorf("%s content size distribution takes %d params, found %d", dist,
+			nParams, len(params))
+	}
+	for _, p := range params {
+		if p <= 0 {
+			return nil, fmt.Errorf("%s content size distribution params must be positive, "+
+				"found %v", dist, params)
+		}
+	}
+	switch dist {
+	case GammaContentSize:
+		return gammaSizeDist{shape: params[0], rate: params[1]}, nil
+	case LogNormalContentSize:
+		return logNormalSizeDist{mu: params[0], sigma: params[1]}, nil
+	case ParetoContentSize:
+		return paretoSizeDist{min: params[0], alpha: params[1]}, nil
+	case FixedContentSize:
+		return fixedSizeDist(params[0]), nil
+	}
+	return nil, fmt.Errorf("unknown content size mixture component %q", dist)
+}
+
+type gammaSizeDist struct {
+	shape float64
+	rate  float64
+}
+
+func (d gammaSizeDist) sampler(src erand.Source) randSampler {
+	return &distuv.Gamma{Alpha: d.shape, Beta: d.rate, Src: src}
+}
+
+type logNormalSizeDist struct {
+	mu    float64
+	sigma float64
+}
+
+func (d logNormalSizeDist) sampler(src erand.Source) randSampler {
+	return &distuv.LogNormal{Mu: d.mu, Sigma: d.sigma, Src: src}
+}
+
+type paretoSizeDist struct {
+	min   float64
+	alpha float64
+}
+
+func (d paretoSizeDist) sampler(src erand.Source) randSampler {
+	return &distuv.Pareto{Xm: d.min, Alpha: d.alpha, Src: src}
+}
+
+type fixedSizeDist float64
+
+func (d fixedSizeDist) sampler(src erand.Source) randSampler {
+	return d
+}
+
+func (d fixedSizeDist) Rand() float64 {
+	return float64(d)
+}
+
+// mixtureSizeDist chooses a component distribution by weight for each sample.
+type mixtureSizeDist struct {
+	weights    *weightedIndex
+	components []contentSizeDist
+}
+
+// newMixtureSizeDist parses mixture components of the form "weight:dist:param[:param]", e.g.,
+// "0.8:lognormal:3:0.75" for 80% of sizes coming from a log-normal distribution with mu 3 and
+// sigma 0.75.
+func newMixtureSizeDist(specs []string) (contentSizeDist, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%s content size distribution requires at least one component",
+			MixtureContentSize)
+	}
+	weights := make([]float64, len(specs))
+	components := make([]contentSizeDist, len(specs))
+	for i, spec := range specs {
+		fields := strings.Split(spec, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("content size mixture component %q not of form "+
+				"weight:dist:param[:param]", spec)
+		}
+		floats, err := parseFloats(append(fields[:1:1], fields[2:]...))
+		if err != nil {
+			return nil, fmt.Errorf("content size mixture component %q: %s", spec, err)
+		}
+		if weights[i] = floats[0]; weights[i] <= 0 {
+			return nil, fmt.Errorf("content size mixture component %q has non-positive weight",
+				spec)
+		}
+		if components[i], err = newComponentSizeDist(fields[1], floats[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return &mixtureSizeDist{
+		weights:    newWeightedIndex(weights),
+		components: components,
+	}, nil
+}
+
+func (d *mixtureSizeDist) sampler(src erand.Source) randSampler {
+	components := make([]randSampler, len(d.components))
+	for i, c := range d.components {
+		components[i] = c.sampler(src)
+	}
+	return &mixtureSampler{
+		weights:    d.weights,
+		components: components,
+		rng:        rand.New(rand.NewSource(int64(src.Uint64()))),
+	}
+}
+
+type mixtureSampler struct {
+	weights    *weightedIndex
+	components []randSampler
+	rng        *rand.Rand
+}
+
+func (s *mixtureSampler) Rand() float64 {
+	return s.components[s.weights.sample(s.rng)].Rand()
+}
+
+// histogramBin is a range of content sizes in KB and the number of documents with sizes in it.
+type histogramBin struct {
+	lower float64
+	upper float64
+	count float64
+}
+
+// empiricalSizeDist chooses a histogram bin by count for each sample and then a size uniformly
+// within the bin.
+type empiricalSizeDist struct {
+	bins    []*histogramBin
+	weights *weightedIndex
+}
+
+// readEmpiricalSizeDist reads a histogram of content sizes from a CSV file with rows of
+// lower_kb,upper_kb,count and an optional header.
+func readEmpiricalSizeDist(histogramFilepath string) (contentSizeDist, error) {
+	if histogramFilepath == "" {
+		return nil, fmt.Errorf("%s content size distribution requires a histogram file",
+			EmpiricalContentSize)
+	}
+	f, err := os.Open(histogramFilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return newEmpiricalSizeDist(f)
+}
+
+func newEmpiricalSizeDist(r io.Reader) (contentSizeDist, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		if _, err := strconv.ParseFloat(records[0][0], 64); err != nil {
+			// skip header
+			records = records[1:]
+		}
+	}
+	bins := make([]*histogramBin, 0, len(records))
+	weights := make([]float64, 0, len(records))
+	for i, record := range records {
+		if len(record) != 3 {
+			return nil, fmt.Errorf("content size histogram row %d has %d fields, expected 3",
+				i, len(record))
+		}
+		floats, err := parseFloats(record)
+		if err != nil {
+			return nil, fmt.Errorf("content size histogram row %d: %s", i, err)
+		}
+		bin := &histogramBin{lower: floats[0], upper: floats[1], count: floats[2]}
+		if bin.lower < 0 || bin.upper < bin.lower || bin.count < 0 {
+			return nil, fmt.Errorf("content size histogram row %d invalid: %v", i, record)
+		}
+		bins = append(bins, bin)
+		weights = append(weights, bin.count)
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("content size histogram has no documents")
+	}
+	return &empiricalSizeDist{
+		bins:    bins,
+		weights: newWeightedIndex(weights),
+	}, nil
+}
+
+func (d *empiricalSizeDist) sampler(src erand.Source) randSampler {
+	return &empiricalSampler{
+		dist: d,
+		rng:  rand.New(rand.NewSource(int64(src.Uint64()))),
+	}
+}
+
+type empiricalSampler struct {
+	dist *empiricalSizeDist
+	rng  *rand.Rand
+}
+
+func (s *empiricalSampler) Rand() float64 {
+	bin := s.dist.bins[s.dist.weights.sample(s.rng)]
+	return bin.lower + s.rng.Float64()*(bin.upper-bin.lower)
+}
+
+// sizeContentSampler samples content sizes from a distribution in KB and content seeds.
+type sizeContentSampler struct {
+	sizeKB randSampler
+	rng    *rand.Rand
+	mu     sync.Mutex
+}
+
+func newSizeContentSampler(dist contentSizeDist, rng *rand.Rand) contentSampler {
+	return &sizeContentSampler{
+		sizeKB: dist.sampler(erand.New(erand.NewSource(rng.Uint64()))),
+		rng:    rng,
+	}
+}
+
+func (s *sizeContentSampler) sample() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizeKB := s.sizeKB.Rand()
+	if sizeKB > maxContentSizeKB {
+		sizeKB = maxContentSizeKB
+	}
+	if sizeKB < 0 {
+		sizeKB = 0
+	}
+	return int(sizeKB * 1024), s.rng.Int63()
+}
+
+// parseFloats parses each string as a float.
+func parseFloats(strs []string) ([]float64, error) {
+	floats := make([]float64, len(strs))
+	for i, str := range strs {
+		var err error
+		if floats[i], err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			return nil, err
+		}
+	}
+	return floats, nil
+}
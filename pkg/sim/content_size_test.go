@@ -0,0 +1,168 @@
+package sim
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewContentSizeDist(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	dists := []string{GammaContentSize, LogNormalContentSize, ParetoContentSize,
+		FixedContentSize, MixtureContentSize}
+	for _, dist := range dists {
+		params := newDefaultParameters()
+		params.ContentSizeDist = dist
+		d, err := newParamsContentSizeDist(params)
+		assert.Nil(t, err, dist)
+		s := newSizeContentSampler(d, rng)
+		for c := 0; c < 100; c++ {
+			size, _ := s.sample()
+			assert.True(t, size >= 0, dist)
+			assert.True(t, size <= maxContentSizeKB*1024, dist)
+		}
+	}
+
+	params := newDefaultParameters()
+	params.ContentSizeDist = FixedContentSize
+	params.ContentSizeKBFixed = 2
+	d, err := newParamsContentSizeDist(params)
+	assert.Nil(t, err)
+	size, _ := newSizeContentSampler(d, rng).sample()
+	assert.Equal(t, 2048, size)
+}
+
+func TestNewContentSizeDist_err(t *testing.T) {
+	cases := []func(p *Parameters){
+		func(p *Parameters) { p.ContentSizeDist = "unknown" },
+		func(p *Parameters) {
+			p.ContentSizeDist = LogNormalContentSize
+			p.ContentSizeKBLogNormalSigma = 0
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = ParetoContentSize
+			p.ContentSizeKBParetoAlpha = -1
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = MixtureContentSize
+			p.ContentSizeMixture = nil
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = MixtureContentSize
+			p.ContentSizeMixture = []string{"0.5:lognormal"}
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = MixtureContentSize
+			p.ContentSizeMixture = []string{"0.5:uniform:1:2"}
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = MixtureContentSize
+			p.ContentSizeMixture = []string{"0:fixed:1"}
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = MixtureContentSize
+			p.ContentSizeMixture = []string{"a:fixed:1"}
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = EmpiricalContentSize
+			p.ContentSizeHistogram = ""
+		},
+		func(p *Parameters) {
+			p.ContentSizeDist = EmpiricalContentSize
+			p.ContentSizeHistogram = "/does/not/exist.csv"
+		},
+	}
+	for i, c := range cases {
+		params := newDefaultParameters()
+		c(params)
+		d, err := newParamsContentSizeDist(params)
+		assert.NotNil(t, err, i)
+		assert.Nil(t, d, i)
+	}
+}
+
+func TestMixtureSizeDist(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	d, err := newMixtureSizeDist([]string{"0.75:fixed:1", "0.25:fixed:100"})
+	assert.Nil(t, err)
+	s := newSizeContentSampler(d, rng)
+	n, nSmall := 10000, 0
+	for c := 0; c < n; c++ {
+		size, _ := s.sample()
+		assert.True(t, size == 1024 || size == 100*1024)
+		if size == 1024 {
+			nSmall++
+		}
+	}
+	assert.InDelta(t, 0.75, float64(nSmall)/float64(n), 0.02)
+}
+
+func TestEmpiricalSizeDist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-size-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	histogramFilepath := filepath.Join(dir, "histogram.csv")
+	histogram := "lower_kb,upper_kb,count\n0,10,30\n10,20,0\n1000,2000,10\n"
+	err = ioutil.WriteFile(histogramFilepath, []byte(histogram), 0600)
+	assert.Nil(t, err)
+
+	params := newDefaultParameters()
+	params.ContentSizeDist = EmpiricalContentSize
+	params.ContentSizeHistogram = histogramFilepath
+	d, err := newParamsContentSizeDist(params)
+	assert.Nil(t, err)
+	s := newSizeContentSampler(d, rand.New(rand.NewSource(0)))
+	n, nSmall := 10000, 0
+	for c := 0; c < n; c++ {
+		size, _ := s.sample()
+		sizeKB := float64(size) / 1024
+		small := sizeKB >= 0 && sizeKB < 10
+		large := sizeKB >= 1000 && sizeKB < 2000
+		assert.True(t, small || large, sizeKB)
+		if small {
+			nSmall++
+		}
+	}
+	assert.InDelta(t, 0.75, float64(nSmall)/float64(n), 0.02)
+}
+
+func TestNewEmpiricalSizeDist_err(t *testing.T) {
+	cases := []string{
+		"",                  // no bins
+		"0,10\n",            // too few fields
+		"0,10,a\n",          // bad count
+		"10,0,5\n",          // upper below lower
+		"0,10,0\n10,20,0\n", // no documents
+		"0,10,5\n\"bad",     // bad CSV
+	}
+	for _, c := range cases {
+		d, err := newEmpiricalSizeDist(strings.NewReader(c))
+		assert.NotNil(t, err, c)
+		assert.Nil(t, d, c)
+	}
+}
+
+func TestParseFloats(t *testing.T) {
+	floats, err := parseFloats([]string{"1", " 2.5", "1e3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1, 2.5, 1000}, floats)
+
+	floats, err = parseFloats([]string{"1", "NaN?"})
+	assert.NotNil(t, err)
+	assert.Nil(t, floats)
+}
+
+// newParamsContentSizeDist returns the content size distribution of the parameters' default
+// phase.
+func newParamsContentSizeDist(params *Parameters) (contentSizeDist, error) {
+	phases, err := getPhases(params)
+	if err != nil {
+		return nil, err
+	}
+	return newContentSizeDist(phases[0])
+}
@@ -0,0 +1,63 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewContent(t *testing.T) {
+	for _, g := range []string{RandomContent, TextContent, RepetitiveContent} {
+		c1, c2, c3 := newContent(1024, 1, g), newContent(1024, 1, g), newContent(1024, 2, g)
+		assert.Equal(t, 1024, c1.Len(), g)
+		assert.Equal(t, c1.Bytes(), c2.Bytes(), g)
+		assert.NotEqual(t, c1.Bytes(), c3.Bytes(), g)
+		assert.Equal(t, 0, newContent(0, 1, g).Len(), g)
+		assert.Equal(t, 1, newContent(1, 1, g).Len(), g)
+	}
+}
+
+func TestNewContent_compressibility(t *testing.T) {
+	size := 256 * 1024
+	random := compressedSize(newContent(size, 1, RandomContent).Bytes())
+	text := compressedSize(newContent(size, 1, TextContent).Bytes())
+	repetitive := compressedSize(newContent(size, 1, RepetitiveContent).Bytes())
+
+	// random content doesn't compress, text compresses a few times, and repetitive content
+	// compresses many times
+	assert.True(t, random > size)
+	assert.True(t, text < size/2)
+	assert.True(t, text > size/10)
+	assert.True(t, repetitive < size/20)
+}
+
+// compressedSize returns the size of the given content after the author compresses it.
+func compressedSize(content []byte) int {
+	o := newContentObserver(true)
+	_, err := o.Write(content)
+	maybePanic(err)
+	return o.compressedSize()
+}
+
+func TestContentDigest_Check(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	content := make([]byte, 1024)
+	rng.Read(content)
+	d := newContentDigest(content)
+
+	corrupted := make([]byte, len(content))
+	copy(corrupted, content)
+	corrupted[512]++
+
+	cases := map[string][]byte{
+		successOutcome:   content,
+		missingOutcome:   {},
+		truncatedOutcome: content[:512],
+		corruptOutcome:   corrupted,
+	}
+	for expected, downloaded := range cases {
+		assert.Equal(t, expected, d.check(newContentDigest(downloaded)))
+	}
+	assert.Equal(t, corruptOutcome, d.check(newContentDigest(append(content, 0))))
+}
@@ -0,0 +1,239 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// RandomContent is uniformly random bytes, which are incompressible.
+	RandomContent = "random"
+
+	// TextContent is text-like words with a skewed frequency, which compresses a few times over.
+	TextContent = "text"
+
+	// RepetitiveContent is a short block repeated with occasional changes, which compresses
+	// many times over.
+	RepetitiveContent = "repetitive"
+
+	// repetitiveBlockSize is the size of the block repetitive content repeats.
+	repetitiveBlockSize = 64
+
+	// repetitiveChangeInterval is the number of bytes per random byte change in repetitive
+	// content.
+	repetitiveChangeInterval = 1024
+
+	// textWordsPerLine is the mean number of words per line of text content.
+	textWordsPerLine = 12
+
+	// textZipfExponent is the exponent of the Zipf distribution over text content words.
+	textZipfExponent = 1.1
+
+	stageLabel       = "stage"
+	uploadedStage    = "uploaded"
+	compressedStage  = "compressed"
+	defaultMediaType = "application/x-gzip"
+)
+
+// DefaultContentTypes are the default content type mixture components, which upload only random
+// content with a media type the author doesn't try to compress.
+var DefaultContentTypes = []string{"1:" + RandomContent + ":" + defaultMediaType}
+
+// noCompressMediaTypes are the media types of already-compressed content, which the author
+// uploads without compressing.
+var noCompressMediaTypes = map[string]struct{}{
+	"application/x-gzip": {},
+	"application/gzip":   {},
+	"application/zip":    {},
+	"image/jpeg":         {},
+	"image/png":          {},
+	"image/gif":          {},
+	"video/mp4":          {},
+	"audio/mpeg":         {},
+}
+
+// textWords are the words of text content, in decreasing order of frequency.
+var textWords = strings.Fields(`the of and to a in is it you that he was for on are with as I his
+	they be at one have this from or had by hot word but what some we can out other were all there
+	when up use your how said an each she which do their time if will way about many then them
+	write would like so these her long make thing see him two has look more day could go come did
+	number sound no most people my over know water than call first who may down side been now find
+	any new work part take get place made live where after back little only round man year came
+	show every good me give our under name very through just form sentence great think say help
+	low line differ turn cause much mean before move right boy old too same tell does set three
+	want air well also play small end put home read hand port large spell add even land here must
+	big high such follow act why ask men change went light kind off need house picture try us
+	again animal point mother world near build self earth father head stand own page should
+	country found answer school grow study still learn plant cover food sun four between state
+	keep eye never last let thought city tree cross farm hard start might story saw far sea draw
+	left late run while press close night real life few north`)
+
+// contentType is the generator and media type of uploaded content.
+type contentType struct {
+	generator string
+	mediaType string
+}
+
+// contentTypeSampler samples content types from a weighted mixture.
+type contentTypeSampler struct {
+	types   []*contentType
+	weights *weightedIndex
+	rng     *rand.Rand
+	mu      sync.Mutex
+}
+
+// newContentTypeSampler parses content type mixture components of the form
+// "weight:generator:mediaType", e.g., "0.5:text:text/plain" for half of uploads being text-like
+// content with a text/plain media type.
+func newContentTypeSampler(specs []string, rng *rand.Rand) (*contentTypeSampler, error) {
+	if len(specs) == 0 {
+		specs = DefaultContentTypes
+	}
+	types := make([]*contentType, len(specs))
+	weights := make([]float64, len(specs))
+	for i, spec := range specs {
+		fields := strings.SplitN(spec, ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("content type %q not of form weight:generator:mediaType",
+				spec)
+		}
+		floats, err := parseFloats(fields[:1])
+		if err != nil {
+			return nil, fmt.Errorf("content type %q: %s", spec, err)
+		}
+		if weights[i] = floats[0]; weights[i] <= 0 {
+			return nil, fmt.Errorf("content type %q has non-positive weight", spec)
+		}
+		switch fields[1] {
+		case RandomContent, TextContent, RepetitiveContent:
+		default:
+			return nil, fmt.Errorf("content type %q has unknown generator %q", spec, fields[1])
+		}
+		if fields[2] == "" {
+			return nil, fmt.Errorf("content type %q has empty media type", spec)
+		}
+		types[i] = &contentType{generator: fields[1], mediaType: fields[2]}
+	}
+	return &contentTypeSampler{
+		types:   types,
+		weights: newWeightedIndex(weights),
+		rng:     rng,
+	}, nil
+}
+
+func (s *contentTypeSampler) sample() *contentType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.types[s.weights.sample(s.rng)]
+}
+
+// compressible returns whether the author compresses content of the given media type.
+func compressible(mediaType string) bool {
+	_, in := noCompressMediaTypes[mediaType]
+	return !in
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+// generateRandom fills the content with uniformly random bytes.
+func generateRandom(content []byte, rng *rand.Rand) {
+	for c := 0; c < 3; c++ {
+		if n, err := rng.Read(content); err != nil {
+			panic(err)
+		} else if n == len(content) {
+			return
+		}
+	}
+	panic(fmt.Errorf("unable to read %d bytes of content", len(content)))
+}
+
+// generateText fills the content with words separated by spaces and newlines.
+func generateText(content []byte, rng *rand.Rand) {
+	words := rand.NewZipf(rng, textZipfExponent, 1, uint64(len(textWords)-1))
+	buf := bytes.NewBuffer(content[:0])
+	for buf.Len() < len(content) {
+		buf.WriteString(textWords[words.Uint64()])
+		if rng.Intn(textWordsPerLine) == 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	copy(content, buf.Bytes())
+}
+
+// generateRepetitive fills the content with a repeated random block, changing a random byte
+// every so often.
+func generateRepetitive(content []byte, rng *rand.Rand) {
+	block := make([]byte, repetitiveBlockSize)
+	_, err := rng.Read(block)
+	maybePanic(err) // should never happen
+	for i := 0; i < len(content); i += repetitiveBlockSize {
+		copy(content[i:], block)
+	}
+	for i := 0; i < len(content); i += repetitiveChangeInterval {
+		n := len(content) - i
+		if n > repetitiveChangeInterval {
+			n = repetitiveChangeInterval
+		}
+		content[i+rng.Intn(n)] = byte(rng.Intn(256))
+	}
+}
+
+// contentMetrics records the bytes uploaded and what they compress to.
+type contentMetrics struct {
+	bytes *prometheus.CounterVec
+
+	uploaded   uint64
+	compressed uint64
+	mu         sync.Mutex
+}
+
+func newContentMetrics(registry *prometheus.Registry) *contentMetrics {
+	contentBytes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "content_bytes",
+			Help:      "bytes of successfully uploaded content before and after compression",
+		},
+		[]string{stageLabel},
+	)
+	registry.MustRegister(contentBytes)
+	return &contentMetrics{bytes: contentBytes}
+}
+
+// observe records the uploaded and compressed sizes of successfully uploaded content.
+func (m *contentMetrics) observe(uploaded, compressed int) {
+	m.bytes.WithLabelValues(uploadedStage).Add(float64(uploaded))
+	m.bytes.WithLabelValues(compressedStage).Add(float64(compressed))
+	m.mu.Lock()
+	m.uploaded += uint64(uploaded)
+	m.compressed += uint64(compressed)
+	m.mu.Unlock()
+}
+
+func (m *contentMetrics) summary() *ContentSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &ContentSummary{
+		UploadedBytes:   m.uploaded,
+		CompressedBytes: m.compressed,
+	}
+	if m.compressed > 0 {
+		s.CompressionRatio = float64(m.uploaded) / float64(m.compressed)
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewContentTypeSampler(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	s, err := newContentTypeSampler(nil, rng)
+	assert.Nil(t, err)
+	assert.Equal(t, &contentType{generator: RandomContent, mediaType: defaultMediaType}, s.sample())
+
+	s, err = newContentTypeSampler([]string{
+		"3:text:text/plain; charset=utf-8",
+		"1:repetitive:application/json",
+	}, rng)
+	assert.Nil(t, err)
+	n, nText := 10000, 0
+	for c := 0; c < n; c++ {
+		ct := s.sample()
+		if ct.generator == TextContent {
+			assert.Equal(t, "text/plain; charset=utf-8", ct.mediaType)
+			nText++
+		} else {
+			assert.Equal(t, RepetitiveContent, ct.generator)
+			assert.Equal(t, "application/json", ct.mediaType)
+		}
+	}
+	assert.InDelta(t, 0.75, float64(nText)/float64(n), 0.02)
+}
+
+func TestNewContentTypeSampler_err(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	cases := []string{
+		"1:text",
+		"a:text:text/plain",
+		"0:text:text/plain",
+		"1:unknown:text/plain",
+		"1:text:",
+	}
+	for _, c := range cases {
+		s, err := newContentTypeSampler([]string{c}, rng)
+		assert.NotNil(t, err, c)
+		assert.Nil(t, s, c)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, compressible("text/plain"))
+	assert.True(t, compressible("application/json"))
+	assert.False(t, compressible("application/x-gzip"))
+	assert.False(t, compressible("image/jpeg"))
+}
+
+func TestContentMetrics(t *testing.T) {
+	m := newContentMetrics(prometheus.NewRegistry())
+	assert.Equal(t, 0.0, m.summary().CompressionRatio)
+
+	m.observe(1000, 250)
+	m.observe(1000, 1000)
+	s := m.summary()
+	assert.Equal(t, uint64(2000), s.UploadedBytes)
+	assert.Equal(t, uint64(1250), s.CompressedBytes)
+	assert.Equal(t, 1.6, s.CompressionRatio)
+	assert.Equal(t, float64(s.UploadedBytes),
+		testutil.ToFloat64(m.bytes.WithLabelValues(uploadedStage)))
+	assert.Equal(t, float64(s.CompressedBytes),
+		testutil.ToFloat64(m.bytes.WithLabelValues(compressedStage)))
+}
@@ -0,0 +1,387 @@
+package sim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	controlPath = "/control"
+
+	pauseAction  = "pause"
+	resumeAction = "resume"
+	rateAction   = "rate"
+	sharesAction = "shares"
+	resetAction  = "reset"
+	stopAction   = "stop"
+
+	docsPerDayParam      = "docsPerDay"
+	sharesPerUploadParam = "sharesPerUpload"
+
+	controlClientTimeout = 10 * time.Second
+)
+
+// DefaultControlAddr is the default address of a running experiment's control endpoint.
+var DefaultControlAddr = fmt.Sprintf("localhost:%d", DefaultAdminPort)
+
+var (
+	errZeroDocsPerDay = errors.New("docs per day must be positive")
+
+	// errReplayRates is returned for rate changes while replaying a trace, whose events are
+	// fixed when recorded
+	errReplayRates = errors.New("rates can't be changed while replaying a trace")
+)
+
+// ControlState is the state of a running experiment's event generation.
+type ControlState struct {
+	// Paused is whether event generation is paused.
+	Paused bool
+
+	// Phase is the name of the current phase.
+	Phase string
+
+	// DocsPerDay and SharesPerUpload are the rates in effect, either the current phase's or the
+	// overrides set through the control endpoint.
+	DocsPerDay      uint
+	SharesPerUpload uint
+
+	// Overridden is whether either rate has been overridden.
+	Overridden bool
+
+	// Stopped is whether the experiment has been stopped.
+	Stopped bool
+}
+
+// controller holds the changes to event generation made through the control endpoint while the
+// experiment runs. Rate overrides apply on top of every phase until they're reset.
+type controller struct {
+	paused  bool
+	resumed chan struct{}
+
+	// changed is closed and replaced on every change so the generator can stop waiting for its
+	// next event
+	changed chan struct{}
+
+	phase           *Phase
+	docsPerDay      *uint
+	sharesPerUpload *uint
+
+	// version is incremented by every rate change so the generator can tell when to rebuild
+	// its samplers
+	version uint64
+	mu      sync.Mutex
+}
+
+func newController(phase *Phase) *controller {
+	return &controller{
+		phase:   phase,
+		changed: make(chan struct{}),
+	}
+}
+
+// changes returns a channel that's closed on the next change.
+func (c *controller) changes() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.changed
+}
+
+// change signals a change to any waiting generator. It must be called with the lock held.
+func (c *controller) change() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *controller) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+		c.change()
+	}
+}
+
+func (c *controller) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+}
+
+// waitWhilePaused blocks while event generation is paused or until done is closed, returning
+// whether it was paused.
+func (c *controller) waitWhilePaused(done <-chan struct{}) bool {
+	c.mu.Lock()
+	if !c.paused {
+		c.mu.Unlock()
+		return false
+	}
+	resumed := c.resumed
+	c.mu.Unlock()
+	select {
+	case <-resumed:
+	case <-done:
+	}
+	return true
+}
+
+func (c *controller) setDocsPerDay(docsPerDay uint) error {
+	if docsPerDay == 0 {
+		return errZeroDocsPerDay
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docsPerDay = &docsPerDay
+	c.version++
+	c.change()
+	return nil
+}
+
+func (c *controller) setSharesPerUpload(sharesPerUpload uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sharesPerUpload = &sharesPerUpload
+	c.version++
+	c.change()
+}
+
+func (c *controller) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docsPerDay, c.sharesPerUpload = nil, nil
+	c.version++
+	c.change()
+}
+
+// startPhase records the phase the generator has switched to.
+func (c *controller) startPhase(phase *Phase) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.phase = phase
+}
+
+// currentVersion returns the version of the rate overrides.
+func (c *controller) currentVersion() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// apply returns a copy of the given phase with any rate overrides applied.
+func (c *controller) apply(phase *Phase) *Phase {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	applied := *phase
+	if c.docsPerDay != nil {
+		applied.DocsPerDay = *c.docsPerDay
+	}
+	if c.sharesPerUpload != nil {
+		applied.SharesPerUpload = *c.sharesPerUpload
+	}
+	return &applied
+}
+
+func (c *controller) state() *ControlState {
+	applied := c.apply(c.currentPhase())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &ControlState{
+		Paused:          c.paused,
+		Phase:           applied.Name,
+		DocsPerDay:      applied.DocsPerDay,
+		SharesPerUpload: applied.SharesPerUpload,
+		Overridden:      c.docsPerDay != nil || c.sharesPerUpload != nil,
+	}
+}
+
+func (c *controller) currentPhase() *Phase {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.phase
+}
+
+// controlHandler handles GET requests for the control state and POST requests of the actions
+// under /control/, e.g., /control/rate?docsPerDay=10000. Every request is answered with the
+// resulting control state.
+func (r *Runner) controlHandler(w http.ResponseWriter, req *http.Request) {
+	action := req.URL.Path[len(controlPath):]
+	if len(action) > 0 && action[0] == '/' {
+		action = action[1:]
+	}
+	if action == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "control state only supports GET", http.StatusMethodNotAllowed)
+			return
+		}
+		r.writeControlState(w)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "control actions only support POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.doControlAction(action, req.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.writeControlState(w)
+}
+
+func (r *Runner) doControlAction(action string, query url.Values) error {
+	if r.replaying && (action == rateAction || action == sharesAction || action == resetAction) {
+		return errReplayRates
+	}
+	switch action {
+	case pauseAction:
+		r.control.pause()
+	case resumeAction:
+		r.control.resume()
+	case rateAction:
+		docsPerDay, err := strconv.ParseUint(query.Get(docsPerDayParam), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", docsPerDayParam, err)
+		}
+		if err := r.control.setDocsPerDay(uint(docsPerDay)); err != nil {
+			return err
+		}
+	case sharesAction:
+		sharesPerUpload, err := strconv.ParseUint(query.Get(sharesPerUploadParam), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", sharesPerUploadParam, err)
+		}
+		r.control.setSharesPerUpload(uint(sharesPerUpload))
+	case resetAction:
+		r.control.reset()
+	case stopAction:
+		r.logger.Info("received stop from control endpoint")
+		r.stop()
+	default:
+		return fmt.Errorf("unknown control action %q", action)
+	}
+	r.logger.Info("applied control action", zap.String("action", action),
+		zap.String("query", query.Encode()))
+	return nil
+}
+
+func (r *Runner) writeControlState(w http.ResponseWriter) {
+	state := r.control.state()
+	state.Stopped = r.stopped()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		r.logger.Error("error writing control state", zap.Error(err))
+	}
+}
+
+// resample rebuilds the upload samplers from the phase with the given index and any rate
+// overrides.
+func (r *Runner) resample(i int) {
+	phase := r.control.apply(r.phases[i])
+	r.nextUploadWait, r.upDocs = phaseSamplers(phase, r.params.NAuthors, r.authors,
+		r.downloadWait, r.contentSizes[i], r.contentTypes, r.largeDocs, r.uploadWaitRNG,
+		r.contentRNG)
+}
+
+// sleepUntil sleeps until the given time, returning false if the runner is stopped or the given
+// channel is closed first.
+func (r *Runner) sleepUntil(t time.Time, interrupt <-chan struct{}) bool {
+	wait := time.NewTimer(time.Until(t))
+	defer wait.Stop()
+	select {
+	case <-wait.C:
+		return true
+	case <-r.done:
+		return false
+	case <-interrupt:
+		return false
+	}
+}
+
+// ControlClient makes requests to a running experiment's control endpoint.
+type ControlClient struct {
+	addr   string
+	client *http.Client
+}
+
+// NewControlClient returns a new ControlClient for the admin endpoint at the given address
+// (host:port).
+func NewControlClient(addr string) *ControlClient {
+	return &ControlClient{
+		addr:   addr,
+		client: &http.Client{Timeout: controlClientTimeout},
+	}
+}
+
+// State returns the experiment's control state.
+func (c *ControlClient) State() (*ControlState, error) {
+	return c.do(http.MethodGet, "", nil)
+}
+
+// Pause pauses event generation.
+func (c *ControlClient) Pause() (*ControlState, error) {
+	return c.do(http.MethodPost, pauseAction, nil)
+}
+
+// Resume resumes paused event generation.
+func (c *ControlClient) Resume() (*ControlState, error) {
+	return c.do(http.MethodPost, resumeAction, nil)
+}
+
+// SetDocsPerDay overrides the number of docs each author uploads per day.
+func (c *ControlClient) SetDocsPerDay(docsPerDay uint) (*ControlState, error) {
+	query := url.Values{docsPerDayParam: {strconv.FormatUint(uint64(docsPerDay), 10)}}
+	return c.do(http.MethodPost, rateAction, query)
+}
+
+// SetSharesPerUpload overrides the number of shares of each upload.
+func (c *ControlClient) SetSharesPerUpload(sharesPerUpload uint) (*ControlState, error) {
+	query := url.Values{sharesPerUploadParam: {strconv.FormatUint(uint64(sharesPerUpload), 10)}}
+	return c.do(http.MethodPost, sharesAction, query)
+}
+
+// Reset removes any rate overrides, reverting to the current phase's rates.
+func (c *ControlClient) Reset() (*ControlState, error) {
+	return c.do(http.MethodPost, resetAction, nil)
+}
+
+// Stop ends the experiment early.
+func (c *ControlClient) Stop() (*ControlState, error) {
+	return c.do(http.MethodPost, stopAction, nil)
+}
+
+func (c *ControlClient) do(method, action string, query url.Values) (*ControlState, error) {
+	u := url.URL{Scheme: "http", Host: c.addr, Path: controlPath}
+	if action != "" {
+		u.Path += "/" + action
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("control request failed with %s: %s", resp.Status, body)
+	}
+	state := &ControlState{}
+	if err := json.NewDecoder(resp.Body).Decode(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
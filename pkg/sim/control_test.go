@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestController_pause(t *testing.T) {
+	c := newController(&Phase{Name: "p1"})
+	done := make(chan struct{})
+	assert.False(t, c.waitWhilePaused(done))
+
+	changed := c.changes()
+	c.pause()
+	c.pause() // no-op
+	select {
+	case <-changed:
+	default:
+		assert.Fail(t, "pause should signal a change")
+	}
+	assert.True(t, c.state().Paused)
+
+	resumed := make(chan bool)
+	go func() { resumed <- c.waitWhilePaused(done) }()
+	time.Sleep(10 * time.Millisecond)
+	c.resume()
+	assert.True(t, <-resumed)
+	assert.False(t, c.state().Paused)
+	c.resume() // no-op
+
+	// waiting ends when done is closed
+	c.pause()
+	close(done)
+	assert.True(t, c.waitWhilePaused(done))
+}
+
+func TestController_overrides(t *testing.T) {
+	phase := &Phase{Name: "p1", DocsPerDay: 10, SharesPerUpload: 2}
+	c := newController(phase)
+	assert.Equal(t, phase, c.apply(phase))
+	assert.Equal(t, uint64(0), c.currentVersion())
+
+	assert.Equal(t, errZeroDocsPerDay, c.setDocsPerDay(0))
+	assert.Nil(t, c.setDocsPerDay(100))
+	c.setSharesPerUpload(0)
+	assert.Equal(t, uint64(2), c.currentVersion())
+	applied := c.apply(phase)
+	assert.Equal(t, uint(100), applied.DocsPerDay)
+	assert.Equal(t, uint(0), applied.SharesPerUpload)
+	assert.Equal(t, uint(10), phase.DocsPerDay) // original unchanged
+
+	// overrides apply to later phases too
+	phase2 := &Phase{Name: "p2", DocsPerDay: 20, SharesPerUpload: 3}
+	c.startPhase(phase2)
+	assert.Equal(t, &ControlState{
+		Phase:           "p2",
+		DocsPerDay:      100,
+		SharesPerUpload: 0,
+		Overridden:      true,
+	}, c.state())
+
+	c.reset()
+	assert.Equal(t, phase2, c.apply(phase2))
+	assert.False(t, c.state().Overridden)
+}
+
+func TestRunner_controlHandler(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	server := httptest.NewServer(r.newAdminServer().Handler)
+	defer server.Close()
+	client := NewControlClient(strings.TrimPrefix(server.URL, "http://"))
+
+	state, err := client.State()
+	assert.Nil(t, err)
+	assert.Equal(t, &ControlState{
+		Phase:           defaultPhaseName,
+		DocsPerDay:      params.DocsPerDay,
+		SharesPerUpload: params.SharesPerUpload,
+	}, state)
+
+	state, err = client.Pause()
+	assert.Nil(t, err)
+	assert.True(t, state.Paused)
+
+	state, err = client.Resume()
+	assert.Nil(t, err)
+	assert.False(t, state.Paused)
+
+	state, err = client.SetDocsPerDay(1000)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), state.DocsPerDay)
+	assert.True(t, state.Overridden)
+
+	state, err = client.SetSharesPerUpload(5)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(5), state.SharesPerUpload)
+
+	state, err = client.Reset()
+	assert.Nil(t, err)
+	assert.Equal(t, params.DocsPerDay, state.DocsPerDay)
+	assert.False(t, state.Overridden)
+
+	state, err = client.SetDocsPerDay(0)
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+
+	state, err = client.do(http.MethodPost, "explode", nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+
+	state, err = client.do(http.MethodGet, pauseAction, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+
+	// only pausing and resuming apply to a replayed trace
+	r.replaying = true
+	state, err = client.SetDocsPerDay(1000)
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+	state, err = client.Reset()
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+	state, err = client.Pause()
+	assert.Nil(t, err)
+	assert.True(t, state.Paused)
+
+	state, err = client.Stop()
+	assert.Nil(t, err)
+	assert.True(t, state.Stopped)
+	assert.True(t, r.stopped())
+}
+
+func TestRunner_RunControl(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 10 * time.Second
+	params.DocsPerDay = 100000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+
+	go func() {
+		client := NewControlClient(DefaultControlAddr)
+		time.Sleep(200 * time.Millisecond)
+		_, err := client.Pause()
+		assert.Nil(t, err)
+
+		// nothing is uploaded while paused once the queued uploads finish
+		paused := r.metrics.count(uploadOp, successOutcome)
+		for settled := false; !settled; {
+			time.Sleep(100 * time.Millisecond)
+			n := r.metrics.count(uploadOp, successOutcome)
+			settled = n == paused && len(r.toUpload) == 0
+			paused = n
+		}
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, paused, r.metrics.count(uploadOp, successOutcome))
+
+		// stop generating shares
+		_, err = client.SetSharesPerUpload(0)
+		assert.Nil(t, err)
+		_, err = client.Resume()
+		assert.Nil(t, err)
+		time.Sleep(300 * time.Millisecond)
+		_, err = client.Stop()
+		assert.Nil(t, err)
+	}()
+	start := time.Now()
+	r.Run()
+	assert.True(t, time.Since(start) < params.Duration)
+
+	summary := r.Summary()
+	assert.True(t, summary.Operations[uploadOp].Succeeded > 0)
+}
+
+func TestRunner_ReplayControl(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 300 * time.Millisecond
+	params.NAuthors = 5
+	params.DocsPerDay = 1000000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	r1, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	traceBuf := new(bytes.Buffer)
+	tw, err := NewTraceWriter(traceBuf, params)
+	assert.Nil(t, err)
+	r1.RecordTrace(tw)
+	r1.Run()
+	assert.Nil(t, tw.Close())
+
+	tr, err := NewTraceReader(bytes.NewReader(traceBuf.Bytes()))
+	assert.Nil(t, err)
+	nRecorded := uint64(0)
+	for _, err = tr.next(); err == nil; _, err = tr.next() {
+		nRecorded++
+	}
+	assert.True(t, nRecorded > 0)
+
+	tr, err = NewTraceReader(bytes.NewReader(traceBuf.Bytes()))
+	assert.Nil(t, err)
+	r2, cleanup := newTestRunner(t, tr.ReplayParameters(newDefaultParameters()))
+	defer cleanup()
+
+	pause := 500 * time.Millisecond
+	go func() {
+		client := NewControlClient(DefaultControlAddr)
+		time.Sleep(100 * time.Millisecond)
+		_, err := client.Pause()
+		assert.Nil(t, err)
+		time.Sleep(pause)
+		_, err = client.Resume()
+		assert.Nil(t, err)
+	}()
+	start := time.Now()
+	r2.Replay(tr)
+
+	// the rest of the trace is replayed after resuming rather than all at once
+	assert.True(t, time.Since(start) > params.Duration+pause)
+	assert.Equal(t, nRecorded, r2.Summary().Operations[uploadOp].Attempted)
+}
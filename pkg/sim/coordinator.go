@@ -0,0 +1,459 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultCoordinatorPort is the default port the coordinator of a multi-worker experiment
+	// listens on.
+	DefaultCoordinatorPort = 20400
+
+	// DefaultNWorkers is the default number of workers a coordinator waits for.
+	DefaultNWorkers = uint(2)
+
+	workersPath = "/workers"
+	readyPath   = "/ready"
+	resultsPath = "/results"
+
+	// startDelay is how long after the last worker is ready that all workers start, which
+	// absorbs the differences in when they receive their start responses.
+	startDelay = 1 * time.Second
+
+	// coordinatorRetryInterval and coordinatorRetryTimeout are how often and for how long workers
+	// retry requests to a coordinator that isn't up (yet).
+	coordinatorRetryInterval = 2 * time.Second
+	coordinatorRetryTimeout  = 10 * time.Minute
+
+	coordinatorClientTimeout = 1 * time.Minute
+)
+
+var (
+	errZeroWorkers = errors.New("coordinator needs at least one worker")
+
+	errWorkerRegistered = errors.New("worker has already registered")
+)
+
+// Assignment is a worker's share of a coordinated experiment.
+type Assignment struct {
+	// Worker is the index of the worker among the NWorkers workers.
+	Worker   uint
+	NWorkers uint
+
+	// Params are the parameters the worker runs with: the experiment's, with the worker's number
+	// of authors, seed, and share of the prefill. The worker's authors only share with each
+	// other, and since the upload rate is per author, the worker generates its share of the
+	// uploads.
+	Params *Parameters
+}
+
+// WorkerResult is the outcome of a worker's run, which the coordinator merges with the other
+// workers' results.
+type WorkerResult struct {
+	Worker  uint
+	Summary *Summary
+	Samples *Samples
+}
+
+// Samples contains the samples behind a summary's percentiles, so the summaries of several
+// workers can be merged.
+type Samples struct {
+	// Latencies and ScheduleLags sample the successful query latencies and schedule lags by
+	// operation.
+	Latencies    map[string]*Reservoir
+	ScheduleLags map[string]*Reservoir
+
+	// EstimatedPages and PageLatencies sample the estimated page counts and mean per-page
+	// latencies of large documents by operation.
+	EstimatedPages map[string]*Reservoir
+	PageLatencies  map[string]*Reservoir
+
+	// LibrarianLatencies samples the successful query latencies by librarian.
+	LibrarianLatencies map[string]*Reservoir
+}
+
+// workerHello is a worker's registration request.
+type workerHello struct {
+	Worker uint
+}
+
+// workerReady is a worker's request to start once every worker is ready.
+type workerReady struct {
+	Worker uint
+}
+
+// workerStart is the coordinator's response to a ready worker.
+type workerStart struct {
+	// StartIn is how long the worker should wait before starting, so all workers start together.
+	StartIn time.Duration
+}
+
+// Coordinator runs a multi-worker experiment, assigning each worker a shard of the authors, a
+// seed, and a share of the upload rate, starting them together once they're all ready, and
+// merging their results.
+type Coordinator struct {
+	params   *Parameters
+	nWorkers uint
+	listener net.Listener
+
+	registered map[uint]struct{}
+	ready      map[uint]struct{}
+	allReady   chan struct{}
+	startAt    time.Time
+	results    []*WorkerResult
+	nResults   uint
+	finished   chan struct{}
+	mu         sync.Mutex
+	logger     *zap.Logger
+}
+
+// NewCoordinator creates a Coordinator of an experiment with the given parameters over the given
+// number of workers, listening on the given address (host:port).
+func NewCoordinator(params *Parameters, nWorkers uint, addr string) (*Coordinator, error) {
+	if nWorkers == 0 {
+		return nil, errZeroWorkers
+	}
+	if params.NAuthors < nWorkers {
+		return nil, fmt.Errorf("%d authors can't be sharded over %d workers", params.NAuthors,
+			nWorkers)
+	}
+	// fail here rather than in every worker
+	if _, err := getPhases(params); err != nil {
+		return nil, err
+	}
+	if _, err := newLoadProfile(params); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Coordinator{
+		params:     params,
+		nWorkers:   nWorkers,
+		listener:   listener,
+		registered: make(map[uint]struct{}),
+		ready:      make(map[uint]struct{}),
+		allReady:   make(chan struct{}),
+		results:    make([]*WorkerResult, nWorkers),
+		finished:   make(chan struct{}),
+		logger:     newDevLogger(getLogLevel(params.LogLevel), os.Stderr),
+	}, nil
+}
+
+// Addr returns the address the coordinator listens on.
+func (c *Coordinator) Addr() string {
+	return c.listener.Addr().String()
+}
+
+// Coordinate serves workers until they've all submitted their results or an external stop
+// signal is received, returning the summary merged from the results received.
+func (c *Coordinator) Coordinate() (*Summary, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(workersPath, c.registerHandler)
+	mux.HandleFunc(readyPath, c.readyHandler)
+	mux.HandleFunc(resultsPath, c.resultsHandler)
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(c.listener); err != http.ErrServerClosed {
+			c.logger.Error("error serving workers", zap.Error(err))
+		}
+	}()
+	c.logger.Info("waiting for workers", zap.String("addr", c.Addr()),
+		zap.Uint("n_workers", c.nWorkers))
+
+	stopSignals := make(chan os.Signal, 3)
+	signal.Notify(stopSignals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(stopSignals)
+	select {
+	case <-c.finished:
+	case <-stopSignals:
+		c.logger.Info("received external stop signal")
+	}
+	if err := server.Close(); err != nil {
+		c.logger.Error("error closing coordinator server", zap.Error(err))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var results []*WorkerResult
+	for i, result := range c.results {
+		if result == nil {
+			c.logger.Warn("missing worker result", zap.Int("worker", i))
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no worker results to merge")
+	}
+	c.logger.Info("merging worker results", zap.Int("n_results", len(results)))
+	return mergeSummaries(c.params, results), nil
+}
+
+// assignment returns the given worker's assignment. Authors are split as evenly as possible, with
+// the first workers taking any remainder.
+func (c *Coordinator) assignment(worker uint) *Assignment {
+	nAuthors, extra := c.params.NAuthors/c.nWorkers, c.params.NAuthors%c.nWorkers
+	offset := worker*nAuthors + minUint(worker, extra)
+	if worker < extra {
+		nAuthors++
+	}
+	params := *c.params
+	params.NAuthors = nAuthors
+	params.Seed = deriveSeed(c.params.Seed, fmt.Sprintf("worker-%d", worker))
+	params.PrefillDocs = uint(splitCount(uint64(c.params.PrefillDocs), c.params.NAuthors, offset,
+		nAuthors))
+	params.PrefillGB = c.params.PrefillGB * float64(nAuthors) / float64(c.params.NAuthors)
+	return &Assignment{
+		Worker:   worker,
+		NWorkers: c.nWorkers,
+		Params:   &params,
+	}
+}
+
+// splitCount returns the share of the total count for the authors [offset, offset + n) of
+// nAuthors, such that the shares of all the authors sum to the total.
+func splitCount(total uint64, nAuthors, offset, n uint) uint64 {
+	return total*uint64(offset+n)/uint64(nAuthors) - total*uint64(offset)/uint64(nAuthors)
+}
+
+func minUint(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// registerHandler assigns a worker its share of the experiment. Each worker may only register
+// once, so two workers mistakenly given the same index don't both run its shard.
+func (c *Coordinator) registerHandler(w http.ResponseWriter, req *http.Request) {
+	hello := &workerHello{}
+	if !decodePost(w, req, hello) || !c.checkWorker(w, hello.Worker) {
+		return
+	}
+	c.mu.Lock()
+	if _, in := c.registered[hello.Worker]; in {
+		c.mu.Unlock()
+		http.Error(w, errWorkerRegistered.Error(), http.StatusConflict)
+		return
+	}
+	c.registered[hello.Worker] = struct{}{}
+	c.mu.Unlock()
+	assignment := c.assignment(hello.Worker)
+	c.logger.Info("registered worker", zap.Uint("worker", hello.Worker),
+		zap.Uint("n_authors", assignment.Params.NAuthors))
+	c.writeJSON(w, assignment)
+}
+
+// readyHandler waits until every worker is ready and then tells them all when to start.
+func (c *Coordinator) readyHandler(w http.ResponseWriter, req *http.Request) {
+	ready := &workerReady{}
+	if !decodePost(w, req, ready) || !c.checkWorker(w, ready.Worker) {
+		return
+	}
+	c.mu.Lock()
+	select {
+	case <-c.allReady: // a restarted worker rejoining after the start
+	default:
+		// a set rather than a count so a worker repeating its request isn't counted twice
+		c.ready[ready.Worker] = struct{}{}
+		if uint(len(c.ready)) == c.nWorkers {
+			c.startAt = time.Now().Add(startDelay)
+			close(c.allReady)
+			c.logger.Info("all workers ready")
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.allReady:
+	case <-req.Context().Done():
+		return
+	}
+	c.mu.Lock()
+	startIn := time.Until(c.startAt)
+	c.mu.Unlock()
+	if startIn < 0 {
+		startIn = 0
+	}
+	c.writeJSON(w, &workerStart{StartIn: startIn})
+}
+
+// resultsHandler records a worker's result, ignoring any repeats.
+func (c *Coordinator) resultsHandler(w http.ResponseWriter, req *http.Request) {
+	result := &WorkerResult{}
+	if !decodePost(w, req, result) || !c.checkWorker(w, result.Worker) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results[result.Worker] == nil {
+		c.results[result.Worker] = result
+		c.nResults++
+		c.logger.Info("received worker result", zap.Uint("worker", result.Worker),
+			zap.Uint("n_results", c.nResults))
+		if c.nResults == c.nWorkers {
+			close(c.finished)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Coordinator) checkWorker(w http.ResponseWriter, worker uint) bool {
+	if worker >= c.nWorkers {
+		http.Error(w, fmt.Sprintf("unknown worker %d", worker), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (c *Coordinator) writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		c.logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+// decodePost decodes the JSON body of a POST request into the given value, responding with an
+// error and returning false if it can't.
+func decodePost(w http.ResponseWriter, req *http.Request, value interface{}) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// RunWorker runs the experiment as the given worker of a coordinated experiment, first waiting
+// for the other workers to be ready so they all start together. Its WorkerResult should be
+// submitted to the coordinator afterwards.
+func (r *Runner) RunWorker(coordinator *CoordinatorClient, worker uint) error {
+	startIn, err := coordinator.Ready(worker)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("starting with other workers", zap.Uint("worker", worker),
+		zap.Duration("start_in", startIn))
+	time.Sleep(startIn)
+	r.Run()
+	return nil
+}
+
+// WorkerResult returns the worker's result of the completed run.
+func (r *Runner) WorkerResult(worker uint) *WorkerResult {
+	samples := &Samples{
+		Latencies:    make(map[string]*Reservoir),
+		ScheduleLags: make(map[string]*Reservoir),
+	}
+	for _, op := range operations {
+		samples.Latencies[op] = r.metrics.latencySamples(op)
+		samples.ScheduleLags[op] = r.metrics.scheduleLagSamples(op)
+	}
+	samples.EstimatedPages, samples.PageLatencies = r.largeDocMetrics.samples()
+	samples.LibrarianLatencies = r.librarians.samples()
+	return &WorkerResult{
+		Worker:  worker,
+		Summary: r.Summary(),
+		Samples: samples,
+	}
+}
+
+// CoordinatorClient makes a worker's requests to the coordinator of a multi-worker experiment.
+// Requests are retried while the coordinator can't be reached, e.g., because it's still starting.
+type CoordinatorClient struct {
+	addr   string
+	client *http.Client
+
+	// readyClient has no timeout since ready requests wait for all the workers
+	readyClient *http.Client
+}
+
+// NewCoordinatorClient returns a new CoordinatorClient for the coordinator at the given address
+// (host:port).
+func NewCoordinatorClient(addr string) *CoordinatorClient {
+	return &CoordinatorClient{
+		addr:        addr,
+		client:      &http.Client{Timeout: coordinatorClientTimeout},
+		readyClient: &http.Client{},
+	}
+}
+
+// Register registers the worker with the given index, unique among the workers, and returns its
+// assignment.
+func (c *CoordinatorClient) Register(worker uint) (*Assignment, error) {
+	assignment := &Assignment{}
+	if err := c.post(c.client, workersPath, &workerHello{Worker: worker}, assignment); err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// Ready waits until every worker is ready and returns how long to wait before starting.
+func (c *CoordinatorClient) Ready(worker uint) (time.Duration, error) {
+	start := &workerStart{}
+	if err := c.post(c.readyClient, readyPath, &workerReady{Worker: worker}, start); err != nil {
+		return 0, err
+	}
+	return start.StartIn, nil
+}
+
+// SubmitResult sends the worker's result to the coordinator.
+func (c *CoordinatorClient) SubmitResult(result *WorkerResult) error {
+	return c.post(c.client, resultsPath, result, nil)
+}
+
+// post posts the given request as JSON to the given path, decoding the JSON response into the
+// given value if it isn't nil.
+func (c *CoordinatorClient) post(
+	client *http.Client, path string, request interface{}, response interface{},
+) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://%s%s", c.addr, path)
+	giveUp := time.Now().Add(coordinatorRetryTimeout)
+	for {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			if time.Now().After(giveUp) {
+				return err
+			}
+			time.Sleep(coordinatorRetryInterval)
+			continue
+		}
+		return readResponse(resp, response)
+	}
+}
+
+// readResponse decodes the JSON body of a successful response into the given value if it isn't
+// nil, returning an error for an unsuccessful response, and closes the body.
+func readResponse(resp *http.Response, response interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("coordinator request failed with %s: %s", resp.Status, msg)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,177 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCoordinator_err(t *testing.T) {
+	params := newDefaultParameters()
+	c, err := NewCoordinator(params, 0, "localhost:0")
+	assert.Equal(t, errZeroWorkers, err)
+	assert.Nil(t, c)
+
+	params.NAuthors = 2
+	c, err = NewCoordinator(params, 3, "localhost:0")
+	assert.NotNil(t, err)
+	assert.Nil(t, c)
+
+	params = newDefaultParameters()
+	params.LoadProfile = "bad"
+	c, err = NewCoordinator(params, 3, "localhost:0")
+	assert.NotNil(t, err)
+	assert.Nil(t, c)
+}
+
+func TestCoordinator_assignment(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 10
+	params.PrefillDocs = 101
+	params.PrefillGB = 1.0
+	c, err := NewCoordinator(params, 3, "localhost:0")
+	assert.Nil(t, err)
+
+	nAuthors := []uint{4, 3, 3}
+	prefillDocs, prefillGB := uint(0), 0.0
+	seeds := make(map[int64]struct{})
+	for i := uint(0); i < 3; i++ {
+		a := c.assignment(i)
+		assert.Equal(t, i, a.Worker)
+		assert.Equal(t, uint(3), a.NWorkers)
+		assert.Equal(t, nAuthors[i], a.Params.NAuthors)
+		assert.InDelta(t, float64(nAuthors[i])/10, a.Params.PrefillGB, 1e-9)
+		assert.Equal(t, params.DocsPerDay, a.Params.DocsPerDay)
+		prefillDocs += a.Params.PrefillDocs
+		prefillGB += a.Params.PrefillGB
+		seeds[a.Params.Seed] = struct{}{}
+	}
+	assert.Equal(t, params.PrefillDocs, prefillDocs)
+	assert.InDelta(t, params.PrefillGB, prefillGB, 1e-9)
+	assert.Len(t, seeds, 3)
+
+	// the experiment's parameters are unchanged
+	assert.Equal(t, uint(10), params.NAuthors)
+	assert.Equal(t, DefaultSeed, params.Seed)
+}
+
+func TestSplitCount(t *testing.T) {
+	for _, total := range []uint64{0, 1, 7, 100, 1001} {
+		sum := uint64(0)
+		for offset := uint(0); offset < 10; offset += 2 {
+			sum += splitCount(total, 10, offset, 2)
+		}
+		assert.Equal(t, total, sum, fmt.Sprintf("total: %d", total))
+	}
+}
+
+func TestCoordinator_register(t *testing.T) {
+	c, err := NewCoordinator(newDefaultParameters(), 2, "localhost:0")
+	assert.Nil(t, err)
+	go func() { _, _ = c.Coordinate() }() // never gets any results
+	client := NewCoordinatorClient(c.Addr())
+
+	a1, err := client.Register(1)
+	assert.Nil(t, err)
+	a0, err := client.Register(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0), a0.Worker)
+	assert.Equal(t, uint(1), a1.Worker)
+
+	// each worker may only register once
+	a1Again, err := client.Register(1)
+	assert.NotNil(t, err)
+	assert.Nil(t, a1Again)
+
+	// no such worker
+	a2, err := client.Register(2)
+	assert.NotNil(t, err)
+	assert.Nil(t, a2)
+
+	// unknown worker
+	_, err = client.Ready(2)
+	assert.NotNil(t, err)
+
+	// ready waits for every worker
+	startIns := make(chan time.Duration, 3)
+	ready := func(worker uint) {
+		startIn, err := client.Ready(worker)
+		assert.Nil(t, err)
+		startIns <- startIn
+	}
+	go ready(0)
+	go ready(0) // repeated requests don't count as another ready worker
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, startIns, 0)
+	go ready(1)
+	for i := 0; i < 3; i++ {
+		startIn := <-startIns
+		assert.True(t, startIn > 0 && startIn <= startDelay)
+	}
+}
+
+func TestCoordinator_Coordinate(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 500 * time.Millisecond
+	params.NAuthors = 9
+	params.DocsPerDay = 100000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+	nWorkers := uint(3)
+	c, err := NewCoordinator(params, nWorkers, "localhost:0")
+	assert.Nil(t, err)
+
+	// each worker runs as it would in its own process
+	runners := make([]*Runner, nWorkers)
+	var wg sync.WaitGroup
+	for i := uint(0); i < nWorkers; i++ {
+		wg.Add(1)
+		go func(i uint) {
+			defer wg.Done()
+			client := NewCoordinatorClient(c.Addr())
+			assignment, err := client.Register(i)
+			assert.Nil(t, err)
+			r, cleanup := newTestRunner(t, assignment.Params)
+			defer cleanup()
+			r.SetAdminAddr("localhost:0")
+			r.querier = &fixedQuerier{
+				uploaded: make(map[string][]byte),
+				rng:      rand.New(rand.NewSource(int64(i))),
+			}
+			runners[assignment.Worker] = r
+			assert.Nil(t, r.RunWorker(client, assignment.Worker))
+			assert.Nil(t, client.SubmitResult(r.WorkerResult(assignment.Worker)))
+		}(i)
+	}
+	summary, err := c.Coordinate()
+	assert.Nil(t, err)
+	wg.Wait()
+
+	assert.Equal(t, params, summary.Parameters)
+	assert.Len(t, summary.Workers, int(nWorkers))
+	assert.Len(t, summary.Authors.Uploads, int(params.NAuthors))
+
+	// workers start together
+	for _, r := range runners {
+		assert.True(t, r.startTime.Sub(runners[0].startTime) < 200*time.Millisecond)
+		assert.True(t, runners[0].startTime.Sub(r.startTime) < 200*time.Millisecond)
+	}
+
+	succeeded, uploadBytes := uint64(0), uint64(0)
+	for i, w := range summary.Workers {
+		assert.Equal(t, uint(3), w.Parameters.NAuthors)
+		assert.Equal(t, runners[i].Summary().Operations[uploadOp].Succeeded,
+			w.Operations[uploadOp].Succeeded)
+		succeeded += w.Operations[uploadOp].Succeeded
+		uploadBytes += w.Content.UploadedBytes
+	}
+	assert.True(t, succeeded > 0)
+	assert.Equal(t, succeeded, summary.Operations[uploadOp].Succeeded)
+	assert.Equal(t, uploadBytes, summary.Content.UploadedBytes)
+	assert.Contains(t, summary.Operations[uploadOp].LatencyMS, "p50")
+}
@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// dashboardInterval is how often the dashboard is redrawn.
+	dashboardInterval = 1 * time.Second
+
+	// dashboardWindow is the number of intervals the dashboard's rolling stats cover.
+	dashboardWindow = 10
+
+	// clearScreen moves the cursor to the top left and clears the terminal, which works over
+	// kubectl attach as well as locally.
+	clearScreen = "\033[H\033[2J"
+)
+
+// dashboardOps are the operations the dashboard always shows; others are shown once they've been
+// attempted.
+var dashboardOps = []string{uploadOp, shareOp, downloadOp}
+
+// dashboard periodically draws a live summary of the running experiment to a terminal.
+type dashboard struct {
+	r   *Runner
+	out io.Writer
+
+	// ticks contains the cumulative counts and new latencies at each of the last
+	// dashboardWindow + 1 redraws, oldest first
+	ticks []*dashboardTick
+}
+
+// dashboardTick is a snapshot of the query metrics at a single redraw.
+type dashboardTick struct {
+	time      time.Time
+	succeeded map[string]uint64
+	failed    map[string]uint64
+	latencies map[string][]time.Duration
+}
+
+// dashboardRow contains the rolling stats of a single operation.
+type dashboardRow struct {
+	op         string
+	throughput float64
+	errorRate  float64
+	latencyMS  map[string]float64
+	succeeded  uint64
+	failed     uint64
+}
+
+// ShowDashboard sets the writer, usually a terminal, to draw a live dashboard of throughput,
+// latency, errors, queue depths, and workers to every second during Run and Replay.
+func (r *Runner) ShowDashboard(out io.Writer) {
+	r.dashboard = &dashboard{
+		r:   r,
+		out: out,
+	}
+}
+
+// start draws the dashboard every interval until stop is closed, then draws it a final time and
+// closes finished.
+func (d *dashboard) start(stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	for {
+		d.tick()
+		d.draw()
+		select {
+		case <-stop:
+			d.tick()
+			d.draw()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick snapshots the query metrics.
+func (d *dashboard) tick() {
+	m := d.r.metrics
+	t := &dashboardTick{
+		time:      time.Now(),
+		succeeded: make(map[string]uint64),
+		failed:    make(map[string]uint64),
+		latencies: make(map[string][]time.Duration),
+	}
+	for _, op := range operations {
+		t.succeeded[op] = m.count(op, successOutcome)
+		for _, outcome := range []string{errorOutcome, timeoutOutcome, corruptOutcome,
+			truncatedOutcome, missingOutcome} {
+			t.failed[op] += m.count(op, outcome)
+		}
+		t.latencies[op] = m.takeRecentLatencies(op)
+	}
+	d.ticks = append(d.ticks, t)
+	if len(d.ticks) > dashboardWindow+1 {
+		d.ticks = d.ticks[1:]
+	}
+}
+
+// rows returns the rolling stats over the window of ticks for each operation shown.
+func (d *dashboard) rows() []*dashboardRow {
+	first, last := d.ticks[0], d.ticks[len(d.ticks)-1]
+	elapsed := last.time.Sub(first.time).Seconds()
+	rows := make([]*dashboardRow, 0, len(operations))
+	for _, op := range operations {
+		if !isDashboardOp(op) && last.succeeded[op]+last.failed[op] == 0 {
+			continue
+		}
+		row := &dashboardRow{
+			op:        op,
+			succeeded: last.succeeded[op],
+			failed:    last.failed[op],
+		}
+		succeeded := last.succeeded[op] - first.succeeded[op]
+		failed := last.failed[op] - first.failed[op]
+		if elapsed > 0 {
+			row.throughput = float64(succeeded) / elapsed
+		}
+		if succeeded+failed > 0 {
+			row.errorRate = float64(failed) / float64(succeeded+failed)
+		}
+		var latencies []time.Duration
+		for _, t := range d.ticks[1:] {
+			latencies = append(latencies, t.latencies[op]...)
+		}
+		row.latencyMS = latencyPercentilesMS(latencies)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func isDashboardOp(op string) bool {
+	for _, dop := range dashboardOps {
+		if op == dop {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dashboard) draw() {
+	r := d.r
+	state := r.control.state()
+	status := "running"
+	if state.Paused {
+		status = "paused"
+	}
+	if r.stopped() {
+		status = "draining"
+	}
+	elapsed := time.Since(r.startTime)
+	remaining := "-"
+	if r.duration > 0 && elapsed < r.duration {
+		remaining = (r.duration - elapsed).Round(time.Second).String()
+	} else if r.duration > 0 {
+		remaining = "0s"
+	}
+
+	// draw the whole frame at once so the terminal doesn't flicker
+	frame := new(bytes.Buffer)
+	fmt.Fprint(frame, clearScreen)
+	fmt.Fprintf(frame, "libri-exp  %s  phase: %s  docs/day: %d  shares/upload: %d\n",
+		status, state.Phase, state.DocsPerDay, state.SharesPerUpload)
+	fmt.Fprintf(frame, "elapsed: %s  remaining: %s\n\n", elapsed.Round(time.Second), remaining)
+
+	tw := tabwriter.NewWriter(frame, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "operation\tok/s\terror %%\tp50 ms\tp90 ms\tp99 ms\tsucceeded\tfailed\n")
+	for _, row := range d.rows() {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%s\t%s\t%s\t%d\t%d\n",
+			row.op,
+			row.throughput,
+			row.errorRate*100,
+			formatLatency(row.latencyMS, "p50"),
+			formatLatency(row.latencyMS, "p90"),
+			formatLatency(row.latencyMS, "p99"),
+			row.succeeded,
+			row.failed,
+		)
+	}
+	maybePanic(tw.Flush()) // only errors if frame does, which it doesn't
+
+	fmt.Fprintln(frame)
+	tw = tabwriter.NewWriter(frame, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "queue\tdepth\tcapacity\tworkers\tmax workers\n")
+	fmt.Fprintf(tw, "toUpload\t%d\t%d\t%d\t%d\n", len(r.toUpload), cap(r.toUpload),
+		r.uploaders.workers(), r.uploaders.max)
+	fmt.Fprintf(tw, "toDownload\t%d\t%d\t%d\t%d\n", len(r.toDownload), cap(r.toDownload),
+		r.downloaders.workers(), r.downloaders.max)
+	maybePanic(tw.Flush())
+
+	if _, err := d.out.Write(frame.Bytes()); err != nil {
+		r.logger.Error("error drawing dashboard", zap.Error(err))
+	}
+}
+
+func formatLatency(latencyMS map[string]float64, name string) string {
+	if latency, in := latencyMS[name]; in {
+		return fmt.Sprintf("%.0f", latency)
+	}
+	return "-"
+}
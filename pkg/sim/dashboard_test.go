@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard_rows(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.ShowDashboard(new(bytes.Buffer))
+	d := r.dashboard
+
+	r.metrics.observe(uploadOp, 10*time.Millisecond, nil)
+	d.tick()
+	d.ticks[0].time = d.ticks[0].time.Add(-2 * time.Second)
+	r.metrics.observe(uploadOp, 20*time.Millisecond, nil)
+	r.metrics.observe(uploadOp, 40*time.Millisecond, nil)
+	r.metrics.observe(uploadOp, time.Second, errors.New("some upload error"))
+	r.metrics.observe(uploadOp, time.Second, errQueryTimeout)
+	d.tick()
+
+	rows := d.rows()
+	ops := make([]string, len(rows))
+	for i, row := range rows {
+		ops[i] = row.op
+	}
+	assert.Equal(t, dashboardOps, ops)
+
+	up := rows[0]
+	assert.Equal(t, uint64(3), up.succeeded)
+	assert.Equal(t, uint64(2), up.failed)
+	assert.InDelta(t, 1.0, up.throughput, 0.01) // 2 successes in the last ~2s
+	assert.Equal(t, 0.5, up.errorRate)
+	assert.Equal(t, 20.0, up.latencyMS["p50"]) // only latencies since the first tick
+	assert.Equal(t, 40.0, up.latencyMS["p99"])
+
+	// other operations are shown once attempted
+	r.metrics.observe(rereadOp, 10*time.Millisecond, nil)
+	d.tick()
+	assert.Len(t, d.rows(), len(dashboardOps)+1)
+
+	// window only covers the latest ticks
+	for c := 0; c < 2*dashboardWindow; c++ {
+		d.tick()
+	}
+	assert.Len(t, d.ticks, dashboardWindow+1)
+	assert.Equal(t, 0.0, d.rows()[0].throughput)
+	assert.Empty(t, d.rows()[0].latencyMS)
+}
+
+func TestDashboard_draw(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	out := new(bytes.Buffer)
+	r.ShowDashboard(out)
+	r.startTime = time.Now().Add(-time.Minute)
+	r.duration = time.Hour
+	r.metrics.observe(uploadOp, 10*time.Millisecond, nil)
+
+	r.dashboard.tick()
+	r.dashboard.draw()
+	frame := out.String()
+	assert.True(t, strings.HasPrefix(frame, clearScreen))
+	for _, expected := range []string{"running", "phase: " + defaultPhaseName,
+		"remaining: 59m0s", uploadOp, shareOp, downloadOp, "toUpload", "toDownload"} {
+		assert.Contains(t, frame, expected)
+	}
+
+	out.Reset()
+	r.control.pause()
+	r.dashboard.draw()
+	assert.Contains(t, out.String(), "paused")
+
+	out.Reset()
+	r.stop()
+	r.dashboard.draw()
+	assert.Contains(t, out.String(), "draining")
+}
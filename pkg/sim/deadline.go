@@ -0,0 +1,151 @@
+package sim
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/drausin/libri/libri/common/id"
+	"github.com/drausin/libri/libri/librarian/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// callLabel labels author library calls, i.e., upload, download, or share.
+const callLabel = "call"
+
+// errQueryTimeout indicates a query didn't return before its operation's timeout.
+var errQueryTimeout = errors.New("query timed out")
+
+// DefaultQueryTimeouts are the default per-operation query timeouts. They're generous enough
+// that only stuck queries should hit them.
+var DefaultQueryTimeouts = []string{
+	uploadOp + ":30s",
+	shareOp + ":10s",
+	downloadOp + ":30s",
+	rereadOp + ":30s",
+	largeUploadOp + ":10m",
+	largeDownloadOp + ":10m",
+}
+
+// newQueryTimeouts parses query timeouts of the form "operation:timeout", e.g., "download:30s".
+// Operations without a timeout wait for the author library to return.
+func newQueryTimeouts(specs []string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, spec := range specs {
+		fields := strings.Split(spec, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("query timeout %q not of form operation:timeout", spec)
+		}
+		op := fields[0]
+		if !isOperation(op) {
+			return nil, fmt.Errorf("query timeout %q has unknown operation %q", spec, op)
+		}
+		if _, in := timeouts[op]; in {
+			return nil, fmt.Errorf("query timeout %q repeats operation %q", spec, op)
+		}
+		timeout, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("query timeout %q: %s", spec, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("query timeout %q must be positive", spec)
+		}
+		timeouts[op] = timeout
+	}
+	return timeouts, nil
+}
+
+// queryContext returns the context for a query for the given operation, which times out after
+// the operation's timeout if it has one.
+func (r *Runner) queryContext(op string) (context.Context, context.CancelFunc) {
+	if timeout, in := r.timeouts[op]; in {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// timedOut returns errQueryTimeout for the error of a query whose context timed out.
+func timedOut(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return errQueryTimeout
+	}
+	return err
+}
+
+// The upload, download, and share methods below make queries through the querier with a context
+// that times out after the operation's timeout, returning errQueryTimeout if it does.
+
+func (r *Runner) upload(
+	op string, from *author.Author, content io.Reader, mediaType string,
+) (*api.Envelope, error) {
+	ctx, cancel := r.queryContext(op)
+	defer cancel()
+	env, err := r.querier.upload(ctx, from, content, mediaType)
+	if err = timedOut(ctx, err); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (r *Runner) download(op string, to *author.Author, content io.Writer, envKey id.ID) error {
+	ctx, cancel := r.queryContext(op)
+	defer cancel()
+	return timedOut(ctx, r.querier.download(ctx, to, content, envKey))
+}
+
+func (r *Runner) share(
+	from *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
+) (id.ID, error) {
+	ctx, cancel := r.queryContext(shareOp)
+	defer cancel()
+	envKey, err := r.querier.share(ctx, from, env, readerPub)
+	if err = timedOut(ctx, err); err != nil {
+		return nil, err
+	}
+	return envKey, nil
+}
+
+// abandonedCallMetrics counts the author library calls abandoned because their query's context
+// was done before they returned. Such calls may still complete on the cluster, e.g., a timed-out
+// upload may still be stored.
+type abandonedCallMetrics struct {
+	count    *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+}
+
+func newAbandonedCallMetrics(registry *prometheus.Registry) *abandonedCallMetrics {
+	count := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "abandoned_call_count",
+			Help:      "number of author library calls abandoned after their query timed out, by call",
+		},
+		[]string{callLabel},
+	)
+	inFlight := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "abandoned_calls_in_flight",
+			Help:      "number of abandoned author library calls that haven't returned yet, by call",
+		},
+		[]string{callLabel},
+	)
+	registry.MustRegister(count, inFlight)
+	return &abandonedCallMetrics{count: count, inFlight: inFlight}
+}
+
+func (m *abandonedCallMetrics) start(call string) {
+	m.count.WithLabelValues(call).Inc()
+	m.inFlight.WithLabelValues(call).Inc()
+}
+
+func (m *abandonedCallMetrics) finish(call string) {
+	m.inFlight.WithLabelValues(call).Dec()
+}
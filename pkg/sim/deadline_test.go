@@ -0,0 +1,164 @@
+package sim
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/drausin/libri/libri/common/id"
+	"github.com/drausin/libri/libri/librarian/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewQueryTimeouts_ok(t *testing.T) {
+	timeouts, err := newQueryTimeouts(DefaultQueryTimeouts)
+	assert.Nil(t, err)
+	assert.Len(t, timeouts, len(operations))
+	assert.Equal(t, 30*time.Second, timeouts[downloadOp])
+
+	timeouts, err = newQueryTimeouts(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, timeouts)
+}
+
+func TestNewQueryTimeouts_err(t *testing.T) {
+	cases := [][]string{
+		{"download"},                   // too few fields
+		{"download:1s:2s"},             // too many fields
+		{"delete:1s"},                  // unknown operation
+		{"download:1s", "download:2s"}, // repeated operation
+		{"download:soon"},              // bad timeout
+		{"download:0s"},                // zero timeout
+	}
+	for i, c := range cases {
+		timeouts, err := newQueryTimeouts(c)
+		assert.NotNil(t, err, "case %d", i)
+		assert.Nil(t, timeouts, "case %d", i)
+	}
+}
+
+func TestRunner_deadline(t *testing.T) {
+	fixed := &fixedQuerier{uploaded: make(map[string][]byte), rng: rand.New(rand.NewSource(0))}
+	stuck := &stuckQuerier{unstick: make(chan struct{})}
+	r := &Runner{
+		timeouts: map[string]time.Duration{
+			uploadOp:   10 * time.Millisecond,
+			shareOp:    10 * time.Millisecond,
+			downloadOp: 10 * time.Millisecond,
+		},
+	}
+
+	// queries that return in time aren't affected
+	r.querier = fixed
+	from := &author.Author{}
+	env, err := r.upload(uploadOp, from, io.LimitReader(rand.New(rand.NewSource(0)), 100), "")
+	assert.Nil(t, err)
+	assert.NotNil(t, env)
+	envKey, err := r.share(from, env, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, envKey)
+
+	// stuck queries time out
+	r.querier = stuck
+	start := time.Now()
+	env, err = r.upload(uploadOp, from, nil, "")
+	assert.Equal(t, errQueryTimeout, err)
+	assert.Nil(t, env)
+	envKey, err = r.share(from, nil, nil)
+	assert.Equal(t, errQueryTimeout, err)
+	assert.Nil(t, envKey)
+	err = r.download(downloadOp, from, nil, nil)
+	assert.Equal(t, errQueryTimeout, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// operations without a timeout wait for the query
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(stuck.unstick)
+	}()
+	err = r.download(rereadOp, from, nil, nil)
+	assert.Nil(t, err)
+}
+
+func TestTimedOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Nil(t, timedOut(ctx, nil))
+	err := errors.New("some upload error")
+	assert.Equal(t, err, timedOut(ctx, err))
+
+	<-ctx.Done()
+	assert.Equal(t, errQueryTimeout, timedOut(ctx, ctx.Err()))
+	assert.Nil(t, timedOut(ctx, nil))
+
+	// cancelled rather than timed-out queries keep their error
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, timedOut(ctx, ctx.Err()))
+}
+
+// stuckQuerier blocks every query until unstick is closed or its context is done.
+type stuckQuerier struct {
+	unstick chan struct{}
+}
+
+func (s *stuckQuerier) upload(
+	ctx context.Context, author *author.Author, content io.Reader, mediaType string,
+) (*api.Envelope, error) {
+	return nil, s.wait(ctx)
+}
+
+func (s *stuckQuerier) download(
+	ctx context.Context, author *author.Author, content io.Writer, envKey id.ID,
+) error {
+	return s.wait(ctx)
+}
+
+func (s *stuckQuerier) share(
+	ctx context.Context, author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
+) (id.ID, error) {
+	return nil, s.wait(ctx)
+}
+
+func (s *stuckQuerier) wait(ctx context.Context) error {
+	select {
+	case <-s.unstick:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestQuerierImpl_await(t *testing.T) {
+	q := &querierImpl{abandoned: newAbandonedCallMetrics(prometheus.NewRegistry())}
+
+	// calls that return in time aren't abandoned
+	returned := false
+	err := q.await(context.Background(), uploadOp, func() { returned = true })
+	assert.Nil(t, err)
+	assert.True(t, returned)
+
+	// stuck calls are abandoned once the context is done and counted until they return
+	unstick := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = q.await(ctx, downloadOp, func() { <-unstick })
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 1.0, testutil.ToFloat64(q.abandoned.count.WithLabelValues(downloadOp)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(q.abandoned.inFlight.WithLabelValues(downloadOp)))
+
+	close(unstick)
+	for testutil.ToFloat64(q.abandoned.inFlight.WithLabelValues(downloadOp)) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 0.0, testutil.ToFloat64(q.abandoned.count.WithLabelValues(uploadOp)))
+}
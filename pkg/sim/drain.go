@@ -0,0 +1,55 @@
+package sim
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// drain waits, once the runner has been stopped, for in-flight uploads to finish and pending
+// downloads to run, until they're all done or the drain period ends. Events still pending when
+// the drain period ends are abandoned rather than executed.
+func (r *Runner) drain(rereadsDone <-chan struct{}) {
+	start := time.Now()
+	r.logger.Info("draining", zap.Duration("drain_period", r.params.DrainPeriod))
+	deadline := time.AfterFunc(r.params.DrainPeriod, func() {
+		r.logger.Info("drain period ended")
+		r.endDrain()
+	})
+	r.uploaders.wait()
+	<-rereadsDone
+	close(r.toSchedule)
+	r.downloaders.wait()
+	deadline.Stop()
+	r.endDrain()
+	r.drainTime = time.Since(start)
+
+	fields := []zap.Field{zap.Duration("drain_time", r.drainTime)}
+	for _, op := range operations {
+		if n := r.metrics.abandonedCount(op); n > 0 {
+			fields = append(fields, zap.Uint64("abandoned_"+op, n))
+		}
+	}
+	r.logger.Info("finished draining", fields...)
+}
+
+// endDrain ends the drain period, after which pending events are abandoned.
+func (r *Runner) endDrain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.drained: // already closed
+	default:
+		close(r.drained)
+	}
+}
+
+// drainEnded returns whether the drain period has ended.
+func (r *Runner) drainEnded() bool {
+	select {
+	case <-r.drained:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,105 @@
+package sim
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/drausin/libri/libri/author/io/enc"
+	"github.com/drausin/libri/libri/author/keychain"
+	"github.com/drausin/libri/libri/librarian/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error classes. Errors from gRPC calls whose status code doesn't map to one of the specific
+// classes below are classed by their code, e.g., grpc_internal. Errors that are neither one of the
+// sentinel errors, a gRPC status, nor a network or filesystem error are classed as other.
+const (
+	timeoutError           = "timeout"
+	deadlineExceededError  = "deadline_exceeded"
+	connectionRefusedError = "connection_refused"
+	unavailableError       = "unavailable"
+	notFoundError          = "not_found"
+	quorumError            = "quorum"
+	keychainError          = "keychain"
+	encryptionError        = "encryption"
+	diskError              = "disk"
+	otherError             = "other"
+
+	grpcErrorPrefix = "grpc_"
+)
+
+// grpcCodeClasses maps the gRPC status codes with their own error class to it.
+var grpcCodeClasses = map[codes.Code]string{
+	codes.DeadlineExceeded: deadlineExceededError,
+	codes.Unavailable:      unavailableError,
+	codes.NotFound:         notFoundError,
+}
+
+// classifyError returns the class of the given query error. Queries that hit the sim's own
+// timeout are classed separately from those whose deadline was exceeded within libri. Quorum
+// errors are searches and stores that failed on too many of the librarians they queried.
+func classifyError(err error) string {
+	switch err {
+	case errQueryTimeout:
+		return timeoutError
+	case context.DeadlineExceeded:
+		return deadlineExceededError
+	case client.ErrTooManyFindErrors, client.ErrTooManyStoreErrors:
+		return quorumError
+	case keychain.ErrEmptyKeychain, keychain.ErrUnexpectedMissingKey:
+		return keychainError
+	case enc.ErrUnexpectedMAC, enc.ErrUnexpectedCiphertextLen:
+		return encryptionError
+	case syscall.ENOSPC:
+		return diskError
+	}
+	if st, ok := status.FromError(err); ok {
+		if class, in := grpcCodeClasses[st.Code()]; in {
+			return class
+		}
+		return grpcErrorPrefix + camelToSnake(st.Code().String())
+	}
+	switch e := err.(type) {
+	case *os.PathError, *os.LinkError:
+		return diskError
+	case *net.OpError:
+		if e.Timeout() {
+			return deadlineExceededError
+		}
+		if isConnRefused(e.Err) {
+			return connectionRefusedError
+		}
+	case net.Error:
+		if e.Timeout() {
+			return deadlineExceededError
+		}
+	}
+	return otherError
+}
+
+func isConnRefused(err error) bool {
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+// camelToSnake converts a CamelCase name to snake_case, e.g., ResourceExhausted to
+// resource_exhausted.
+func camelToSnake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
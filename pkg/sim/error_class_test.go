@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/drausin/libri/libri/author/io/enc"
+	"github.com/drausin/libri/libri/author/keychain"
+	"github.com/drausin/libri/libri/librarian/client"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{errQueryTimeout, timeoutError},
+		{context.DeadlineExceeded, deadlineExceededError},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), deadlineExceededError},
+		{status.Error(codes.Unavailable, "all SubConns are in TransientFailure"),
+			unavailableError},
+		{status.Error(codes.NotFound, "document not found"), notFoundError},
+		{status.Error(codes.ResourceExhausted, "too large"), "grpc_resource_exhausted"},
+		{status.Error(codes.Internal, "quorum not reached"), "grpc_internal"},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{
+			Syscall: "connect", Err: syscall.ECONNREFUSED}}, connectionRefusedError},
+		{&os.PathError{Op: "open", Path: "/data/db", Err: syscall.ENOSPC}, diskError},
+		{syscall.ENOSPC, diskError},
+		{client.ErrTooManyFindErrors, quorumError},
+		{client.ErrTooManyStoreErrors, quorumError},
+		{keychain.ErrEmptyKeychain, keychainError},
+		{keychain.ErrUnexpectedMissingKey, keychainError},
+		{enc.ErrUnexpectedMAC, encryptionError},
+		{enc.ErrUnexpectedCiphertextLen, encryptionError},
+
+		// messages alone don't determine the class
+		{errors.New("dial tcp 10.0.0.1:20100: connect: connection refused"), otherError},
+		{errors.New("deadline exceeded"), otherError},
+		{errors.New("something unexpected"), otherError},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.expected, classifyError(c.err), "case %d", i)
+	}
+}
+
+func TestCamelToSnake(t *testing.T) {
+	assert.Equal(t, "internal", camelToSnake("Internal"))
+	assert.Equal(t, "resource_exhausted", camelToSnake("ResourceExhausted"))
+	assert.Equal(t, "", camelToSnake(""))
+}
@@ -0,0 +1,193 @@
+package sim
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+const (
+	// UniformSocialGraph has no social graph; authors share with uniformly random authors.
+	UniformSocialGraph = "uniform"
+
+	// PreferentialSocialGraph grows a social graph by preferential attachment, giving a
+	// heavy-tailed degree distribution where a few authors have many more neighbors than most.
+	PreferentialSocialGraph = "preferential"
+
+	// CommunitiesSocialGraph generates a social graph from a stochastic block model, where
+	// authors are split into equal-sized communities and are mostly connected within their own.
+	CommunitiesSocialGraph = "communities"
+)
+
+// socialGraph contains the neighbors of each author.
+type socialGraph [][]int
+
+func newSocialGraph(params *Parameters, rng *rand.Rand) (socialGraph, error) {
+	n := int(params.NAuthors)
+	switch params.SocialGraph {
+	case UniformSocialGraph, "":
+		return nil, nil
+	case PreferentialSocialGraph:
+		m := int(math.Round(params.SocialGraphMeanDegree / 2))
+		if m < 1 {
+			return nil, fmt.Errorf("%s social graph requires a mean degree of at least 1",
+				PreferentialSocialGraph)
+		}
+		return newPreferentialGraph(n, m, rng), nil
+	case CommunitiesSocialGraph:
+		if params.SocialGraphCommunities == 0 {
+			return nil, fmt.Errorf("%s social graph requires at least one community",
+				CommunitiesSocialGraph)
+		}
+		if params.SocialGraphCrossFrac < 0 || params.SocialGraphCrossFrac > 1 {
+			return nil, fmt.Errorf("social graph cross-community fraction %v not in [0, 1]",
+				params.SocialGraphCrossFrac)
+		}
+		return newCommunityGraph(n, int(params.SocialGraphCommunities),
+			params.SocialGraphMeanDegree, params.SocialGraphCrossFrac, rng), nil
+	}
+	return nil, fmt.Errorf("unknown social graph %q", params.SocialGraph)
+}
+
+// newPreferentialGraph creates a Barabási–Albert graph on n nodes, where each node after an
+// initial complete graph of m+1 nodes connects to m existing nodes with probability proportional
+// to their degree. The mean degree is ~2m.
+func newPreferentialGraph(n, m int, rng *rand.Rand) socialGraph {
+	g := make(socialGraph, n)
+	// ends has each node once for each of its edges, so uniformly sampling from it samples nodes
+	// proportional to degree
+	ends := make([]int, 0, 2*n*m)
+	for i := 0; i <= m && i < n; i++ {
+		for j := 0; j < i; j++ {
+			g.connect(i, j)
+			ends = append(ends, i, j)
+		}
+	}
+	for i := m + 1; i < n; i++ {
+		targets := make(map[int]struct{}, m)
+		for len(targets) < m {
+			targets[ends[rng.Intn(len(ends))]] = struct{}{}
+		}
+		// add in ascending order so the graph doesn't depend on map iteration order
+		for j := 0; j < i; j++ {
+			if _, in := targets[j]; in {
+				g.connect(i, j)
+				ends = append(ends, i, j)
+			}
+		}
+	}
+	return g
+}
+
+// newCommunityGraph creates a stochastic block model graph on n nodes split round-robin into k
+// communities. Edge probabilities are set so each node has the given mean degree, with the given
+// fraction of its edges outside its community.
+func newCommunityGraph(n, k int, meanDegree, crossFrac float64, rng *rand.Rand) socialGraph {
+	g := make(socialGraph, n)
+	communitySize := float64(n) / float64(k)
+	pIn := edgeProb(meanDegree*(1-crossFrac), communitySize-1)
+	pOut := edgeProb(meanDegree*crossFrac, float64(n)-communitySize)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			p := pOut
+			if i%k == j%k {
+				p = pIn
+			}
+			if rng.Float64() < p {
+				g.connect(i, j)
+			}
+		}
+	}
+	return g
+}
+
+// edgeProb returns the probability of each of the possible edges existing for a node to have
+// the given expected degree.
+func edgeProb(degree, nPossible float64) float64 {
+	if nPossible <= 0 {
+		return 0
+	}
+	return math.Min(degree/nPossible, 1)
+}
+
+func (g socialGraph) connect(i, j int) {
+	g[i] = append(g[i], j)
+	g[j] = append(g[j], i)
+}
+
+// meanDegree returns the mean number of neighbors per node.
+func (g socialGraph) meanDegree() float64 {
+	if len(g) == 0 {
+		return 0
+	}
+	total := 0
+	for _, neighbors := range g {
+		total += len(neighbors)
+	}
+	return float64(total) / float64(len(g))
+}
+
+// maxDegree returns the largest number of neighbors of any node.
+func (g socialGraph) maxDegree() int {
+	max := 0
+	for _, neighbors := range g {
+		if len(neighbors) > max {
+			max = len(neighbors)
+		}
+	}
+	return max
+}
+
+// socialDirectory is a directory whose authors share mostly with their neighbors in a social
+// graph.
+type socialDirectory struct {
+	*directoryImpl
+	graph socialGraph
+
+	// neighborFrac is the fraction of shares to a neighbor rather than a random author.
+	neighborFrac float64
+
+	// neighborActivity weights each author's neighbors by their activity, or is nil for uniform
+	// activity
+	neighborActivity []*weightedIndex
+}
+
+func newSocialDirectory(
+	d *directoryImpl, graph socialGraph, neighborFrac float64, activityWeights []float64,
+) *socialDirectory {
+	sd := &socialDirectory{
+		directoryImpl: d,
+		graph:         graph,
+		neighborFrac:  neighborFrac,
+	}
+	if activityWeights != nil {
+		sd.neighborActivity = make([]*weightedIndex, len(graph))
+		for i, neighbors := range graph {
+			weights := make([]float64, len(neighbors))
+			for j, neighbor := range neighbors {
+				weights[j] = activityWeights[neighbor]
+			}
+			sd.neighborActivity[i] = newWeightedIndex(weights)
+		}
+	}
+	return sd
+}
+
+func (d *socialDirectory) sampleShare(from int) (int, *ecdsa.PublicKey) {
+	neighbors := d.graph[from]
+	d.mu.Lock()
+	var i int
+	if len(neighbors) > 0 && d.rng.Float64() < d.neighborFrac {
+		if d.neighborActivity == nil {
+			i = neighbors[d.rng.Intn(len(neighbors))]
+		} else {
+			i = neighbors[d.neighborActivity[from].sample(d.rng)]
+		}
+	} else {
+		i = d.sampleIdx()
+	}
+	d.mu.Unlock()
+	_, pubKey := d.lookup(i)
+	return i, pubKey
+}
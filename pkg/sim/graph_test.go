@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewSocialGraph(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	params := newDefaultParameters()
+	params.NAuthors = 100
+
+	params.SocialGraph = UniformSocialGraph
+	g, err := newSocialGraph(params, rng)
+	assert.Nil(t, err)
+	assert.Nil(t, g)
+
+	params.SocialGraph = PreferentialSocialGraph
+	g, err = newSocialGraph(params, rng)
+	assert.Nil(t, err)
+	assert.Len(t, g, 100)
+
+	params.SocialGraph = CommunitiesSocialGraph
+	g, err = newSocialGraph(params, rng)
+	assert.Nil(t, err)
+	assert.Len(t, g, 100)
+}
+
+func TestNewSocialGraph_err(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	cases := []func(p *Parameters){
+		func(p *Parameters) { p.SocialGraph = "unknown" },
+		func(p *Parameters) {
+			p.SocialGraph = PreferentialSocialGraph
+			p.SocialGraphMeanDegree = 0.5
+		},
+		func(p *Parameters) {
+			p.SocialGraph = CommunitiesSocialGraph
+			p.SocialGraphCommunities = 0
+		},
+		func(p *Parameters) {
+			p.SocialGraph = CommunitiesSocialGraph
+			p.SocialGraphCrossFrac = 1.5
+		},
+	}
+	for i, c := range cases {
+		params := newDefaultParameters()
+		c(params)
+		g, err := newSocialGraph(params, rng)
+		assert.NotNil(t, err, i)
+		assert.Nil(t, g, i)
+	}
+}
+
+func TestNewPreferentialGraph(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	n, m := 1000, 4
+	g := newPreferentialGraph(n, m, rng)
+	checkSimpleGraph(t, g)
+	assert.InDelta(t, 2*m, g.meanDegree(), 0.1)
+	for _, neighbors := range g {
+		assert.True(t, len(neighbors) >= m)
+	}
+
+	// heavy tail means some nodes have many more neighbors than the mean
+	assert.True(t, g.maxDegree() > 5*2*m)
+
+	// same seed gives same graph
+	assert.Equal(t, g, newPreferentialGraph(n, m, rand.New(rand.NewSource(0))))
+}
+
+func TestNewCommunityGraph(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	n, k, meanDegree, crossFrac := 1000, 10, 8.0, 0.1
+	g := newCommunityGraph(n, k, meanDegree, crossFrac, rng)
+	checkSimpleGraph(t, g)
+	assert.InDelta(t, meanDegree, g.meanDegree(), 0.5)
+
+	nCross, nTotal := 0, 0
+	for i, neighbors := range g {
+		for _, j := range neighbors {
+			if i%k != j%k {
+				nCross++
+			}
+			nTotal++
+		}
+	}
+	assert.InDelta(t, crossFrac, float64(nCross)/float64(nTotal), 0.03)
+}
+
+func TestSocialDirectory_sampleShare(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	defer os.RemoveAll(dataDir)
+	assert.Nil(t, err)
+	librarianAddrs := []*net.TCPAddr{{IP: net.ParseIP("192.168.1.1"), Port: 20100}}
+	nAuthors := uint(10)
+
+	// author 0 is only connected to author 1, and author 1 to author 0
+	graph := make(socialGraph, nAuthors)
+	graph.connect(0, 1)
+	dir, err := newDirectory(rand.New(rand.NewSource(0)), dataDir, librarianAddrs, nAuthors,
+		"info", zap.NewNop(), nil, false, false)
+	assert.Nil(t, err)
+	d := newSocialDirectory(dir, graph, 1.0, nil)
+	for c := 0; c < 10; c++ {
+		i, pubKey := d.sampleShare(0)
+		assert.Equal(t, 1, i)
+		auth, _ := d.lookup(1)
+		assert.Equal(t, auth, d.get(pubKey))
+	}
+
+	// without neighbors, shares go to random authors
+	counts := make(map[int]int)
+	for c := 0; c < 100; c++ {
+		i, _ := d.sampleShare(2)
+		counts[i]++
+	}
+	assert.True(t, len(counts) > 2)
+}
+
+func checkSimpleGraph(t *testing.T, g socialGraph) {
+	for i, neighbors := range g {
+		seen := make(map[int]struct{})
+		for _, j := range neighbors {
+			assert.NotEqual(t, i, j, "self loop")
+			_, in := seen[j]
+			assert.False(t, in, "duplicate edge")
+			seen[j] = struct{}{}
+			assert.Contains(t, g[j], i, "asymmetric edge")
+		}
+	}
+}
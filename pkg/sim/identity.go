@@ -0,0 +1,169 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/drausin/libri/libri/author/keychain"
+	"github.com/drausin/libri/libri/common/id"
+)
+
+const (
+	authorKeychainFilename     = "author.keys"
+	selfReaderKeychainFilename = "self-reader.keys"
+
+	// keychainAuth is the passphrase of persisted keychains; simulated authors' keys aren't
+	// secret, and fixing it means keychains can be loaded by later runs without extra config.
+	keychainAuth = "libri-exp"
+
+	// receivedFilename is the file in the data dir recording the docs shared with each author.
+	receivedFilename = "received.jsonl"
+)
+
+// loadOrCreateKeychains returns the author and self-reader keychains in the given keychain dir,
+// creating and saving new ones if it doesn't contain any yet. The returned bool is whether the
+// keychains were loaded. If persist is false, new keychains are always returned without saving.
+func loadOrCreateKeychains(keychainDir string, persist bool) (
+	keychain.GetterSampler, keychain.GetterSampler, bool, error) {
+
+	if !persist {
+		return keychain.New(nInitialKeys), keychain.New(nInitialKeys), false, nil
+	}
+	authorKCFilepath := filepath.Join(keychainDir, authorKeychainFilename)
+	selfReaderKCFilepath := filepath.Join(keychainDir, selfReaderKeychainFilename)
+	if _, err := os.Stat(authorKCFilepath); err == nil {
+		authorKC, err := keychain.Load(authorKCFilepath, keychainAuth)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		selfReaderKC, err := keychain.Load(selfReaderKCFilepath, keychainAuth)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return authorKC, selfReaderKC, true, nil
+	} else if !os.IsNotExist(err) {
+		return nil, nil, false, err
+	}
+
+	if err := os.MkdirAll(keychainDir, 0700); err != nil {
+		return nil, nil, false, err
+	}
+	authorKC := keychain.New(nInitialKeys)
+	selfReaderKC := keychain.New(nInitialKeys)
+	err := keychain.Save(selfReaderKCFilepath, keychainAuth, selfReaderKC,
+		keychain.LightScryptN, keychain.LightScryptP)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	// save the author keychain last, since its presence marks the keychains as saved
+	err = keychain.Save(authorKCFilepath, keychainAuth, authorKC,
+		keychain.LightScryptN, keychain.LightScryptP)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return authorKC, selfReaderKC, false, nil
+}
+
+// receivedDoc is a doc shared with an author, recorded so later runs can download it again.
+type receivedDoc struct {
+	Author int    `json:"a"`
+	EnvKey string `json:"k"`
+	Sum    string `json:"d"`
+	Size   int    `json:"s"`
+}
+
+func newReceivedDoc(to int, envKey id.ID, digest contentDigest) *receivedDoc {
+	return &receivedDoc{
+		Author: to,
+		EnvKey: hex.EncodeToString(envKey.Bytes()),
+		Sum:    hex.EncodeToString(digest.sum[:]),
+		Size:   digest.size,
+	}
+}
+
+// catalogueDoc returns the catalogue doc for the received doc with the given recipient.
+func (d *receivedDoc) catalogueDoc(authors directory) (*catalogueDoc, error) {
+	envKey, err := hex.DecodeString(d.EnvKey)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := hex.DecodeString(d.Sum)
+	if err != nil {
+		return nil, err
+	}
+	digest := contentDigest{size: d.Size}
+	copy(digest.sum[:], sum)
+	to, _ := authors.lookup(d.Author)
+	key := id.FromBytes(envKey)
+	return &catalogueDoc{ref: envKeyRef(key), to: to, envKey: key, digest: digest}, nil
+}
+
+// receivedLog appends the docs shared with each author to the received file in the data dir as
+// they are shared, so they survive the run ending abruptly.
+type receivedLog struct {
+	enc    *json.Encoder
+	closer io.Closer
+	mu     sync.Mutex
+}
+
+// newReceivedWriter returns a receivedLog that writes to the given writer, which it doesn't
+// close.
+func newReceivedWriter(w io.Writer) *receivedLog {
+	return &receivedLog{enc: json.NewEncoder(w)}
+}
+
+func newReceivedLog(dataDir string) (*receivedLog, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dataDir, receivedFilename),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &receivedLog{enc: json.NewEncoder(f), closer: f}, nil
+}
+
+func (l *receivedLog) record(doc *receivedDoc) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(doc)
+}
+
+func (l *receivedLog) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// loadReceived returns the docs recorded in the received file in the data dir by earlier runs
+// that were shared with one of the first nAuthors authors.
+func loadReceived(dataDir string, nAuthors uint) ([]*receivedDoc, error) {
+	f, err := os.Open(filepath.Join(dataDir, receivedFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	docs := make([]*receivedDoc, 0)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		doc := new(receivedDoc)
+		if err := dec.Decode(doc); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if doc.Author >= 0 && doc.Author < int(nAuthors) {
+			docs = append(docs, doc)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drausin/libri/libri/common/id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOrCreateKeychains(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	defer os.RemoveAll(dataDir)
+	assert.Nil(t, err)
+	keychainDir := filepath.Join(dataDir, "keychains")
+
+	// not persisted
+	authorKC, selfReaderKC, loaded, err := loadOrCreateKeychains(keychainDir, false)
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, nInitialKeys, authorKC.Len())
+	assert.Equal(t, nInitialKeys, selfReaderKC.Len())
+	_, err = os.Stat(keychainDir)
+	assert.True(t, os.IsNotExist(err))
+
+	// created
+	authorKC1, selfReaderKC1, loaded, err := loadOrCreateKeychains(keychainDir, true)
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+
+	// loaded
+	authorKC2, selfReaderKC2, loaded, err := loadOrCreateKeychains(keychainDir, true)
+	assert.Nil(t, err)
+	assert.True(t, loaded)
+	authorKey, err := authorKC1.Sample()
+	assert.Nil(t, err)
+	_, in := authorKC2.Get(authorKey.PublicKeyBytes())
+	assert.True(t, in)
+	selfReaderKey, err := selfReaderKC1.Sample()
+	assert.Nil(t, err)
+	_, in = selfReaderKC2.Get(selfReaderKey.PublicKeyBytes())
+	assert.True(t, in)
+}
+
+func TestReceivedLog(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	defer os.RemoveAll(dataDir)
+	assert.Nil(t, err)
+
+	// nothing received yet
+	docs, err := loadReceived(dataDir, 3)
+	assert.Nil(t, err)
+	assert.Empty(t, docs)
+
+	envKeys := []id.ID{id.NewPseudoRandom(rng), id.NewPseudoRandom(rng), id.NewPseudoRandom(rng)}
+	digest := newContentDigest([]byte("some content"))
+	for run := 0; run < 2; run++ {
+		l, err := newReceivedLog(dataDir)
+		assert.Nil(t, err)
+		for i, envKey := range envKeys {
+			assert.Nil(t, l.record(newReceivedDoc(i, envKey, digest)))
+		}
+		assert.Nil(t, l.Close())
+	}
+
+	// docs from both runs are loaded, except those received by authors beyond the first two
+	docs, err = loadReceived(dataDir, 2)
+	assert.Nil(t, err)
+	assert.Len(t, docs, 4)
+
+	d := &fixedDirectory{rng: rng}
+	catDoc, err := docs[1].catalogueDoc(d)
+	assert.Nil(t, err)
+	assert.Equal(t, envKeys[1].Bytes(), catDoc.envKey.Bytes())
+	assert.Equal(t, digest, catDoc.digest)
+}
@@ -0,0 +1,249 @@
+package sim
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// pageSizeKB is libri's max entry page size.
+	pageSizeKB = 2 * 1024
+
+	// contentChunkSize is the size of each chunk of streamed content.
+	contentChunkSize = 1024 * 1024
+)
+
+// largeDocSampler decides which uploads are large, multi-page documents and samples their sizes
+// log-uniformly between a min and max.
+type largeDocSampler struct {
+	frac     float64
+	logMinMB float64
+	logMaxMB float64
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+func newLargeDocSampler(params *Parameters, rng *rand.Rand) (*largeDocSampler, error) {
+	if params.LargeDocFrac <= 0 {
+		return nil, nil
+	}
+	if params.LargeDocFrac > 1 {
+		return nil, fmt.Errorf("large doc fraction %v greater than 1", params.LargeDocFrac)
+	}
+	if params.LargeDocSizeMBMin <= 0 || params.LargeDocSizeMBMax < params.LargeDocSizeMBMin {
+		return nil, fmt.Errorf("large doc sizes must satisfy 0 < min <= max, found [%v, %v]",
+			params.LargeDocSizeMBMin, params.LargeDocSizeMBMax)
+	}
+	return &largeDocSampler{
+		frac:     params.LargeDocFrac,
+		logMinMB: math.Log(params.LargeDocSizeMBMin),
+		logMaxMB: math.Log(params.LargeDocSizeMBMax),
+		rng:      rng,
+	}, nil
+}
+
+// sample returns whether the next upload is large and, if so, its size.
+func (s *largeDocSampler) sample() (bool, int) {
+	if s == nil {
+		return false, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rng.Float64() >= s.frac {
+		return false, 0
+	}
+	sizeMB := math.Exp(s.logMinMB + s.rng.Float64()*(s.logMaxMB-s.logMinMB))
+	return true, int(sizeMB * 1024 * 1024)
+}
+
+// contentReader streams generated content in chunks, so large content never has to fit in
+// memory. Each chunk is generated from its own seed derived from the content seed.
+type contentReader struct {
+	size      int
+	read      int
+	seed      int64
+	generator string
+	chunk     []byte
+	chunkIdx  int64
+}
+
+func newContentReader(size int, seed int64, generator string) io.Reader {
+	return &contentReader{
+		size:      size,
+		seed:      seed,
+		generator: generator,
+	}
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	if len(r.chunk) == 0 {
+		remaining := r.size - r.read
+		if remaining <= 0 {
+			return 0, io.EOF
+		}
+		if remaining > contentChunkSize {
+			remaining = contentChunkSize
+		}
+		r.chunk = newContent(remaining, r.seed+r.chunkIdx, r.generator).Bytes()
+		r.chunkIdx++
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	r.read += n
+	return n, nil
+}
+
+// contentObserver observes content as it streams through a query, computing its digest and, if
+// the author compresses it, its compressed size with gzip, which is what the author compresses
+// content with.
+type contentObserver struct {
+	hash       hash.Hash
+	size       int
+	gz         *gzip.Writer
+	compressed *countingWriter
+}
+
+func newContentObserver(compress bool) *contentObserver {
+	o := &contentObserver{hash: sha256.New()}
+	if compress {
+		o.compressed = &countingWriter{}
+		o.gz = gzip.NewWriter(o.compressed)
+	}
+	return o
+}
+
+func (o *contentObserver) Write(p []byte) (int, error) {
+	o.hash.Write(p)
+	o.size += len(p)
+	if o.gz != nil {
+		if _, err := o.gz.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// digest returns the digest of the observed content.
+func (o *contentObserver) digest() contentDigest {
+	d := contentDigest{size: o.size}
+	copy(d.sum[:], o.hash.Sum(nil))
+	return d
+}
+
+// compressedSize returns the size of the observed content after compression, or its size if it
+// wasn't compressed. No more content should be observed after calling it.
+func (o *contentObserver) compressedSize() int {
+	if o.gz == nil {
+		return o.size
+	}
+	maybePanic(o.gz.Close()) // should never happen
+	return o.compressed.n
+}
+
+// estimatePages returns the estimated number of pages of an entry with the given compressed
+// content size. The estimate uses the size of the content compressed with gzip, which is close to
+// but not exactly the size libri pages.
+func estimatePages(size int) int {
+	pages := (size + pageSizeKB*1024 - 1) / (pageSizeKB * 1024)
+	if pages < 1 {
+		return 1
+	}
+	return pages
+}
+
+// largeDocMetrics records the page counts and per-page latencies of large document queries.
+type largeDocMetrics struct {
+	pages       *prometheus.HistogramVec
+	pageLatency *prometheus.HistogramVec
+
+	pageSamples    map[string]*Reservoir
+	latencySamples map[string]*Reservoir
+	mu             sync.Mutex
+}
+
+func newLargeDocMetrics(registry *prometheus.Registry) *largeDocMetrics {
+	pages := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "document_estimated_pages",
+			Help:      "estimated number of pages of successfully queried large documents",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{operationLabel},
+	)
+	pageLatency := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "page_duration_seconds",
+			Help:      "mean latency per estimated page of successfully queried large documents",
+			Buckets:   latencyBuckets,
+		},
+		[]string{operationLabel},
+	)
+	registry.MustRegister(pages, pageLatency)
+	return &largeDocMetrics{
+		pages:          pages,
+		pageLatency:    pageLatency,
+		pageSamples:    make(map[string]*Reservoir),
+		latencySamples: make(map[string]*Reservoir),
+	}
+}
+
+// observe records the estimated page count and the mean per-page latency of a successful large
+// document query with the given end-to-end latency.
+func (m *largeDocMetrics) observe(op string, pages int, latency time.Duration) {
+	pageLatency := latency / time.Duration(pages)
+	m.pages.WithLabelValues(op).Observe(float64(pages))
+	m.pageLatency.WithLabelValues(op).Observe(pageLatency.Seconds())
+	m.mu.Lock()
+	addSample(m.pageSamples, op, int64(pages))
+	addSample(m.latencySamples, op, int64(pageLatency))
+	m.mu.Unlock()
+}
+
+func (m *largeDocMetrics) summary() map[string]*LargeDocSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	summaries := make(map[string]*LargeDocSummary)
+	for _, op := range []string{largeUploadOp, largeDownloadOp} {
+		summaries[op] = &LargeDocSummary{
+			EstimatedPages: countPercentiles(m.pageSamples[op].ints()),
+			PageLatencyMS:  latencyPercentilesMS(m.latencySamples[op].durations()),
+		}
+	}
+	return summaries
+}
+
+// samples returns copies of the samples of page counts and mean per-page latencies by
+// operation.
+func (m *largeDocMetrics) samples() (map[string]*Reservoir, map[string]*Reservoir) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyReservoirs(m.pageSamples), copyReservoirs(m.latencySamples)
+}
+
+// countPercentiles returns the nearest-rank summary quantiles of the given counts.
+func countPercentiles(counts []int) map[string]float64 {
+	percentiles := make(map[string]float64)
+	if len(counts) == 0 {
+		return percentiles
+	}
+	sorted := append([]int(nil), counts...)
+	sort.Ints(sorted)
+	for _, sq := range summaryQuantiles {
+		percentiles[sq.name] = float64(sorted[nearestRank(sq.q, len(sorted))])
+	}
+	return percentiles
+}
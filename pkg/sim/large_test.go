@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLargeDocSampler(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+
+	// no large docs
+	s, err := newLargeDocSampler(&Parameters{LargeDocFrac: 0}, rng)
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	large, size := s.sample()
+	assert.False(t, large)
+	assert.Zero(t, size)
+
+	// bad params
+	cases := []*Parameters{
+		{LargeDocFrac: 1.5, LargeDocSizeMBMin: 1, LargeDocSizeMBMax: 2},
+		{LargeDocFrac: 0.5, LargeDocSizeMBMin: 0, LargeDocSizeMBMax: 2},
+		{LargeDocFrac: 0.5, LargeDocSizeMBMin: 2, LargeDocSizeMBMax: 1},
+	}
+	for i, c := range cases {
+		s, err = newLargeDocSampler(c, rng)
+		assert.NotNil(t, err, "case %d", i)
+		assert.Nil(t, s, "case %d", i)
+	}
+}
+
+func TestLargeDocSampler_sample(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	params := &Parameters{LargeDocFrac: 0.25, LargeDocSizeMBMin: 10, LargeDocSizeMBMax: 100}
+	s, err := newLargeDocSampler(params, rng)
+	assert.Nil(t, err)
+
+	n, nLarge := 10000, 0
+	for c := 0; c < n; c++ {
+		large, size := s.sample()
+		if !large {
+			continue
+		}
+		nLarge++
+		assert.True(t, size >= 10*1024*1024)
+		assert.True(t, size <= 100*1024*1024)
+	}
+	assert.InDelta(t, params.LargeDocFrac, float64(nLarge)/float64(n), 0.02)
+}
+
+func TestContentReader(t *testing.T) {
+	size := 2*contentChunkSize + 1234
+	for _, generator := range []string{RandomContent, TextContent, RepetitiveContent} {
+		content1, err := ioutil.ReadAll(newContentReader(size, 1, generator))
+		assert.Nil(t, err)
+		content2, err := ioutil.ReadAll(newContentReader(size, 1, generator))
+		assert.Nil(t, err)
+		content3, err := ioutil.ReadAll(newContentReader(size, 2, generator))
+		assert.Nil(t, err)
+
+		assert.Len(t, content1, size, generator)
+		assert.Equal(t, content1, content2, generator)
+		assert.NotEqual(t, content1, content3, generator)
+
+		// each chunk is generated from its own seed
+		chunk1 := newContent(contentChunkSize, 2, generator).Bytes()
+		assert.Equal(t, chunk1, content1[contentChunkSize:2*contentChunkSize], generator)
+	}
+}
+
+func TestContentObserver(t *testing.T) {
+	content := newContent(64*1024, 0, TextContent).Bytes()
+
+	o := newContentObserver(false)
+	_, err := o.Write(content[:1000])
+	assert.Nil(t, err)
+	_, err = o.Write(content[1000:])
+	assert.Nil(t, err)
+	assert.Equal(t, newContentDigest(content), o.digest())
+	assert.Equal(t, len(content), o.compressedSize())
+
+	o = newContentObserver(true)
+	_, err = o.Write(content)
+	assert.Nil(t, err)
+	assert.Equal(t, newContentDigest(content), o.digest())
+	assert.True(t, o.compressedSize() < len(content))
+}
+
+func TestEstimatePages(t *testing.T) {
+	pageSize := pageSizeKB * 1024
+	assert.Equal(t, 1, estimatePages(0))
+	assert.Equal(t, 1, estimatePages(1))
+	assert.Equal(t, 1, estimatePages(pageSize))
+	assert.Equal(t, 2, estimatePages(pageSize+1))
+	assert.Equal(t, 10, estimatePages(10*pageSize))
+}
+
+func TestLargeDocMetrics(t *testing.T) {
+	m := newLargeDocMetrics(prometheus.NewRegistry())
+	m.observe(largeUploadOp, 2, 2*time.Second)
+	m.observe(largeUploadOp, 4, 2*time.Second)
+	m.observe(largeUploadOp, 8, 2*time.Second)
+
+	summary := m.summary()
+	assert.Equal(t, 4.0, summary[largeUploadOp].EstimatedPages["p50"])
+	assert.Equal(t, 8.0, summary[largeUploadOp].EstimatedPages["p99"])
+	assert.Equal(t, 500.0, summary[largeUploadOp].PageLatencyMS["p50"])
+	assert.Empty(t, summary[largeDownloadOp].EstimatedPages)
+}
+
+func TestCountPercentiles(t *testing.T) {
+	assert.Empty(t, countPercentiles(nil))
+
+	counts := make([]int, 100)
+	for i := range counts {
+		counts[i] = 100 - i
+	}
+	ps := countPercentiles(counts)
+	assert.Equal(t, 50.0, ps["p50"])
+	assert.Equal(t, 99.0, ps["p99"])
+}
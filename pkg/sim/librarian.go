@@ -0,0 +1,186 @@
+package sim
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	librarianLabel = "librarian"
+)
+
+var errNoPinLibrarians = errors.New("can't pin authors to librarians without librarian addresses")
+
+// pinLibrarians restricts each author config to a single librarian, assigning librarians to
+// authors round-robin, and returns the address of each author's librarian. Authors otherwise
+// spread their RPCs over all librarians in a way the sim can't observe, so pinning is how queries
+// are attributed to a librarian. That librarian is the one an author sends its queries to, which
+// may in turn query others to find or store a document, so the attribution is by the pinned
+// librarian rather than those the document is stored on.
+func pinLibrarians(configs []*author.Config, librarianAddrs []*net.TCPAddr) ([]string, error) {
+	if len(librarianAddrs) == 0 {
+		return nil, errNoPinLibrarians
+	}
+	librarians := make([]string, len(configs))
+	for i, config := range configs {
+		addr := librarianAddrs[i%len(librarianAddrs)]
+		config.WithLibrarianAddrs([]*net.TCPAddr{addr})
+		librarians[i] = addr.String()
+	}
+	return librarians, nil
+}
+
+// librarianMetrics records the counts and latencies of queries by the pinned librarian they were
+// sent to.
+type librarianMetrics struct {
+	counts    *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	latencies *prometheus.HistogramVec
+
+	// librarian of each author, or nil if authors aren't pinned to librarians
+	librarians map[*author.Author]string
+
+	summaries map[string]*librarianStats
+	mu        sync.Mutex
+}
+
+// librarianStats accumulates a single librarian's queries for its summary.
+type librarianStats struct {
+	attempted map[string]uint64
+	succeeded map[string]uint64
+	errors    map[string]uint64
+	latencies *Reservoir
+}
+
+func newLibrarianMetrics(
+	registry *prometheus.Registry, librarians map[*author.Author]string,
+) *librarianMetrics {
+	counts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "librarian_query_count",
+			Help:      "number of queries made by the sim, by librarian, operation, and outcome",
+		},
+		[]string{librarianLabel, operationLabel, outcomeLabel},
+	)
+	errors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "librarian_query_error_count",
+			Help:      "number of failed queries made by the sim, by librarian and error class",
+		},
+		[]string{librarianLabel, errorClassLabel},
+	)
+	latencies := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "librarian_query_duration_seconds",
+			Help:      "client-side latency of successful queries, by librarian and operation",
+			Buckets:   latencyBuckets,
+		},
+		[]string{librarianLabel, operationLabel},
+	)
+	registry.MustRegister(counts, errors, latencies)
+	return &librarianMetrics{
+		counts:     counts,
+		errors:     errors,
+		latencies:  latencies,
+		librarians: librarians,
+		summaries:  make(map[string]*librarianStats),
+	}
+}
+
+// observe records a query by the given author with the given outcome and, for errors, error
+// class. It does nothing if authors aren't pinned to librarians.
+func (m *librarianMetrics) observe(
+	from *author.Author, op, outcome, errClass string, latency time.Duration,
+) {
+	librarian, in := m.librarians[from]
+	if !in {
+		return
+	}
+	m.counts.WithLabelValues(librarian, op, outcome).Inc()
+	if outcome == successOutcome {
+		m.latencies.WithLabelValues(librarian, op).Observe(latency.Seconds())
+	}
+	if errClass != "" {
+		m.errors.WithLabelValues(librarian, errClass).Inc()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, in := m.summaries[librarian]
+	if !in {
+		stats = &librarianStats{
+			attempted: make(map[string]uint64),
+			succeeded: make(map[string]uint64),
+			errors:    make(map[string]uint64),
+			latencies: newReservoir(),
+		}
+		m.summaries[librarian] = stats
+	}
+	stats.attempted[op]++
+	if outcome == successOutcome {
+		stats.succeeded[op]++
+		stats.latencies.add(int64(latency))
+	}
+	if errClass != "" {
+		stats.errors[errClass]++
+	}
+}
+
+func (m *librarianMetrics) summary() map[string]*LibrarianSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.summaries) == 0 {
+		return nil
+	}
+	total := uint64(0)
+	for _, stats := range m.summaries {
+		for _, n := range stats.attempted {
+			total += n
+		}
+	}
+	summaries := make(map[string]*LibrarianSummary)
+	for librarian, stats := range m.summaries {
+		s := &LibrarianSummary{
+			Attempted: copyCounts(stats.attempted),
+			Succeeded: copyCounts(stats.succeeded),
+			Errors:    copyCounts(stats.errors),
+			LatencyMS: latencyPercentilesMS(stats.latencies.durations()),
+		}
+		for _, n := range stats.attempted {
+			s.QueryFrac += float64(n)
+		}
+		s.QueryFrac /= float64(total)
+		summaries[librarian] = s
+	}
+	return summaries
+}
+
+// samples returns copies of the samples of successful query latencies by librarian.
+func (m *librarianMetrics) samples() map[string]*Reservoir {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latencies := make(map[string]*Reservoir)
+	for librarian, stats := range m.summaries {
+		latencies[librarian] = stats.latencies.copy()
+	}
+	return latencies
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64)
+	for k, n := range counts {
+		copied[k] = n
+	}
+	return copied
+}
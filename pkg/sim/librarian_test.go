@@ -0,0 +1,73 @@
+package sim
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinLibrarians(t *testing.T) {
+	librarianAddrs := []*net.TCPAddr{
+		{IP: net.ParseIP("192.168.1.1"), Port: 20100},
+		{IP: net.ParseIP("192.168.1.2"), Port: 20100},
+	}
+	configs := newAuthorConfigs("", librarianAddrs, 5, "info")
+	librarians, err := pinLibrarians(configs, librarianAddrs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"192.168.1.1:20100",
+		"192.168.1.2:20100",
+		"192.168.1.1:20100",
+		"192.168.1.2:20100",
+		"192.168.1.1:20100",
+	}, librarians)
+	for i, config := range configs {
+		assert.Len(t, config.LibrarianAddrs, 1)
+		assert.Equal(t, librarians[i], config.LibrarianAddrs[0].String())
+	}
+
+	librarians, err = pinLibrarians(configs, []*net.TCPAddr{})
+	assert.Equal(t, errNoPinLibrarians, err)
+	assert.Nil(t, librarians)
+}
+
+func TestLibrarianMetrics(t *testing.T) {
+	a1, a2 := &author.Author{}, &author.Author{}
+	m := newLibrarianMetrics(prometheus.NewRegistry(), map[*author.Author]string{
+		a1: "librarian-1",
+		a2: "librarian-2",
+	})
+	m.observe(a1, uploadOp, successOutcome, "", 10*time.Millisecond)
+	m.observe(a1, uploadOp, successOutcome, "", 30*time.Millisecond)
+	m.observe(a1, downloadOp, errorOutcome, deadlineExceededError, time.Second)
+	m.observe(a2, downloadOp, successOutcome, "", 20*time.Millisecond)
+
+	// unknown authors are ignored
+	m.observe(&author.Author{}, uploadOp, successOutcome, "", 10*time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(
+		m.counts.WithLabelValues("librarian-1", uploadOp, successOutcome)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		m.errors.WithLabelValues("librarian-1", deadlineExceededError)))
+
+	s := m.summary()
+	assert.Len(t, s, 2)
+	s1 := s["librarian-1"]
+	assert.Equal(t, map[string]uint64{uploadOp: 2, downloadOp: 1}, s1.Attempted)
+	assert.Equal(t, map[string]uint64{uploadOp: 2}, s1.Succeeded)
+	assert.Equal(t, map[string]uint64{deadlineExceededError: 1}, s1.Errors)
+	assert.Equal(t, 0.75, s1.QueryFrac)
+	assert.Equal(t, 10.0, s1.LatencyMS["p50"])
+	assert.Equal(t, 0.25, s["librarian-2"].QueryFrac)
+}
+
+func TestLibrarianMetrics_unpinned(t *testing.T) {
+	m := newLibrarianMetrics(prometheus.NewRegistry(), nil)
+	m.observe(&author.Author{}, uploadOp, successOutcome, "", 10*time.Millisecond)
+	assert.Nil(t, m.summary())
+}
@@ -0,0 +1,139 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// ConstantLoadProfile keeps the upload rate at its base level.
+	ConstantLoadProfile = "constant"
+
+	// RampLoadProfile linearly scales the upload rate from a start to an end multiple.
+	RampLoadProfile = "ramp"
+
+	// StepLoadProfile scales the upload rate by a staircase of levels of equal duration.
+	StepLoadProfile = "step"
+
+	// SineLoadProfile sinusoidally scales the upload rate around its base level, e.g., to mimic
+	// diurnal load.
+	SineLoadProfile = "sine"
+
+	// SpikeLoadProfile periodically multiplies the upload rate for a short burst at the end of
+	// each interval.
+	SpikeLoadProfile = "spike"
+
+	// minLoadScale bounds how small a load profile scale can be, so wait times stay finite.
+	minLoadScale = 1e-3
+)
+
+// loadProfile scales the base upload rate over the course of an experiment.
+type loadProfile interface {
+	// scale returns the multiple of the base upload rate at the given time since the start.
+	scale(elapsed time.Duration) float64
+}
+
+func newLoadProfile(params *Parameters) (loadProfile, error) {
+	switch params.LoadProfile {
+	case ConstantLoadProfile, "":
+		return constantLoadProfile{}, nil
+	case RampLoadProfile:
+		return &rampLoadProfile{
+			start:    params.LoadRampStart,
+			end:      params.LoadRampEnd,
+			duration: params.LoadRampDuration,
+		}, nil
+	case StepLoadProfile:
+		if len(params.LoadStepLevels) == 0 {
+			return nil, fmt.Errorf("%s load profile requires at least one level", StepLoadProfile)
+		}
+		return &stepLoadProfile{
+			duration: params.LoadStepDuration,
+			levels:   params.LoadStepLevels,
+		}, nil
+	case SineLoadProfile:
+		return &sineLoadProfile{
+			period:    params.LoadSinePeriod,
+			amplitude: params.LoadSineAmplitude,
+		}, nil
+	case SpikeLoadProfile:
+		return &spikeLoadProfile{
+			interval: params.LoadSpikeInterval,
+			duration: params.LoadSpikeDuration,
+			multiple: params.LoadSpikeScale,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown load profile %q", params.LoadProfile)
+}
+
+// scaleWait scales a wait time sampled at the base upload rate by the load profile.
+func scaleWait(p loadProfile, wait time.Duration, elapsed time.Duration) time.Duration {
+	return time.Duration(float64(wait) / math.Max(p.scale(elapsed), minLoadScale))
+}
+
+type constantLoadProfile struct{}
+
+func (constantLoadProfile) scale(elapsed time.Duration) float64 {
+	return 1
+}
+
+// rampLoadProfile linearly moves from the start to the end scale over its duration and then holds
+// the end scale.
+type rampLoadProfile struct {
+	start    float64
+	end      float64
+	duration time.Duration
+}
+
+func (p *rampLoadProfile) scale(elapsed time.Duration) float64 {
+	if elapsed >= p.duration {
+		return p.end
+	}
+	return p.start + (p.end-p.start)*float64(elapsed)/float64(p.duration)
+}
+
+// stepLoadProfile holds each level for its duration and then holds the last level.
+type stepLoadProfile struct {
+	duration time.Duration
+	levels   []float64
+}
+
+func (p *stepLoadProfile) scale(elapsed time.Duration) float64 {
+	if p.duration <= 0 {
+		return p.levels[len(p.levels)-1]
+	}
+	i := int(elapsed / p.duration)
+	if i >= len(p.levels) {
+		i = len(p.levels) - 1
+	}
+	return p.levels[i]
+}
+
+// sineLoadProfile oscillates around a scale of 1 with the given amplitude and period.
+type sineLoadProfile struct {
+	period    time.Duration
+	amplitude float64
+}
+
+func (p *sineLoadProfile) scale(elapsed time.Duration) float64 {
+	if p.period <= 0 {
+		return 1
+	}
+	return 1 + p.amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(p.period))
+}
+
+// spikeLoadProfile applies its multiple for the last duration of every interval and a scale of 1
+// otherwise.
+type spikeLoadProfile struct {
+	interval time.Duration
+	duration time.Duration
+	multiple float64
+}
+
+func (p *spikeLoadProfile) scale(elapsed time.Duration) float64 {
+	if p.interval <= 0 || elapsed%p.interval >= p.interval-p.duration {
+		return p.multiple
+	}
+	return 1
+}
@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoadProfile_ok(t *testing.T) {
+	params := newDefaultParameters()
+	cases := map[string]loadProfile{
+		ConstantLoadProfile: constantLoadProfile{},
+		RampLoadProfile:     &rampLoadProfile{},
+		StepLoadProfile:     &stepLoadProfile{},
+		SineLoadProfile:     &sineLoadProfile{},
+		SpikeLoadProfile:    &spikeLoadProfile{},
+	}
+	for name, expected := range cases {
+		params.LoadProfile = name
+		p, err := newLoadProfile(params)
+		assert.Nil(t, err)
+		assert.IsType(t, expected, p)
+	}
+}
+
+func TestNewLoadProfile_err(t *testing.T) {
+	params := newDefaultParameters()
+	params.LoadProfile = "bad"
+	p, err := newLoadProfile(params)
+	assert.NotNil(t, err)
+	assert.Nil(t, p)
+
+	params.LoadProfile = StepLoadProfile
+	params.LoadStepLevels = nil
+	p, err = newLoadProfile(params)
+	assert.NotNil(t, err)
+	assert.Nil(t, p)
+}
+
+func TestDefaultLoadProfile(t *testing.T) {
+	// default profile should give the 30s warm-up
+	p, err := newLoadProfile(newDefaultParameters())
+	assert.Nil(t, err)
+	assert.Equal(t, 0.25, p.scale(0))
+	assert.Equal(t, 0.5, p.scale(20*time.Second))
+	assert.Equal(t, 1.0, p.scale(30*time.Second))
+	assert.Equal(t, 1.0, p.scale(time.Hour))
+}
+
+func TestRampLoadProfile_Scale(t *testing.T) {
+	p := &rampLoadProfile{start: 1, end: 3, duration: 10 * time.Second}
+	assert.Equal(t, 1.0, p.scale(0))
+	assert.Equal(t, 2.0, p.scale(5*time.Second))
+	assert.Equal(t, 3.0, p.scale(10*time.Second))
+	assert.Equal(t, 3.0, p.scale(time.Hour))
+}
+
+func TestStepLoadProfile_Scale(t *testing.T) {
+	p := &stepLoadProfile{duration: time.Minute, levels: []float64{1, 2, 4, 8}}
+	assert.Equal(t, 1.0, p.scale(0))
+	assert.Equal(t, 2.0, p.scale(90*time.Second))
+	assert.Equal(t, 8.0, p.scale(3*time.Minute))
+	assert.Equal(t, 8.0, p.scale(time.Hour))
+}
+
+func TestSineLoadProfile_Scale(t *testing.T) {
+	p := &sineLoadProfile{period: 4 * time.Hour, amplitude: 0.5}
+	assert.InDelta(t, 1.0, p.scale(0), 1e-9)
+	assert.InDelta(t, 1.5, p.scale(time.Hour), 1e-9)
+	assert.InDelta(t, 0.5, p.scale(3*time.Hour), 1e-9)
+}
+
+func TestSpikeLoadProfile_Scale(t *testing.T) {
+	p := &spikeLoadProfile{interval: 10 * time.Minute, duration: time.Minute, multiple: 4}
+	assert.Equal(t, 1.0, p.scale(0))
+	assert.Equal(t, 4.0, p.scale(9*time.Minute+30*time.Second))
+	assert.Equal(t, 1.0, p.scale(10*time.Minute))
+	assert.Equal(t, 4.0, p.scale(19*time.Minute))
+}
+
+func TestScaleWait(t *testing.T) {
+	assert.Equal(t, 4*time.Second, scaleWait(constantLoadProfile{}, 4*time.Second, 0))
+
+	p := &stepLoadProfile{duration: time.Minute, levels: []float64{0.5, 2, 0}}
+	assert.Equal(t, 8*time.Second, scaleWait(p, 4*time.Second, 0))
+	assert.Equal(t, 2*time.Second, scaleWait(p, 4*time.Second, time.Minute))
+	zeroScaleWait := scaleWait(p, 4*time.Second, 2*time.Minute)
+	assert.Equal(t, time.Duration(4*float64(time.Second)/minLoadScale), zeroScaleWait)
+}
@@ -0,0 +1,197 @@
+package sim
+
+// mergeSummaries merges the results of a coordinated experiment's workers, ordered by worker,
+// into a single summary of the experiment with the given parameters. Counts are summed, and
+// percentiles are computed from the workers' merged samples.
+func mergeSummaries(params *Parameters, results []*WorkerResult) *Summary {
+	merged := &Summary{
+		Parameters: params,
+		StartTime:  results[0].Summary.StartTime,
+		EndTime:    results[0].Summary.EndTime,
+		Operations: make(map[string]*OperationSummary),
+		Pools:      make(map[string]*PoolSummary),
+		Authors:    &AuthorsSummary{},
+		Content:    &ContentSummary{},
+		LargeDocs:  make(map[string]*LargeDocSummary),
+		Workers:    make([]*Summary, len(results)),
+	}
+	for i, result := range results {
+		s := result.Summary
+		merged.Workers[i] = s
+		if s.StartTime.Before(merged.StartTime) {
+			merged.StartTime = s.StartTime
+		}
+		if s.EndTime.After(merged.EndTime) {
+			merged.EndTime = s.EndTime
+		}
+		if s.DrainSeconds > merged.DrainSeconds {
+			merged.DrainSeconds = s.DrainSeconds
+		}
+		for op, opSummary := range s.Operations {
+			mergeOperation(merged.Operations, op, opSummary)
+		}
+		for name, pool := range s.Pools {
+			mergePool(merged.Pools, name, pool)
+		}
+		if s.Authors != nil {
+			merged.Authors.Uploads = append(merged.Authors.Uploads, s.Authors.Uploads...)
+			merged.Authors.SharesReceived = append(merged.Authors.SharesReceived,
+				s.Authors.SharesReceived...)
+		}
+		if s.Content != nil {
+			merged.Content.UploadedBytes += s.Content.UploadedBytes
+			merged.Content.CompressedBytes += s.Content.CompressedBytes
+		}
+		for librarian, libSummary := range s.Librarians {
+			if merged.Librarians == nil {
+				merged.Librarians = make(map[string]*LibrarianSummary)
+			}
+			mergeLibrarian(merged.Librarians, librarian, libSummary)
+		}
+		if s.Prefill != nil {
+			if merged.Prefill == nil {
+				merged.Prefill = &PrefillSummary{}
+			}
+			mergePrefill(merged.Prefill, s.Prefill)
+		}
+	}
+
+	elapsed := merged.EndTime.Sub(merged.StartTime).Seconds()
+	for op, opSummary := range merged.Operations {
+		if opSummary.Attempted > 0 {
+			attempted := float64(opSummary.Attempted)
+			opSummary.FirstTrySuccessRate = float64(opSummary.FirstTrySucceeded) / attempted
+			opSummary.SuccessRate = float64(opSummary.Succeeded) / attempted
+		}
+		if elapsed > 0 {
+			opSummary.Throughput = float64(opSummary.Succeeded) / elapsed
+		}
+		var latencies, lags []*Reservoir
+		for _, result := range results {
+			if result.Samples != nil {
+				latencies = append(latencies, result.Samples.Latencies[op])
+				lags = append(lags, result.Samples.ScheduleLags[op])
+			}
+		}
+		opSummary.LatencyMS = latencyPercentilesMS(mergeReservoirs(latencies).durations())
+		opSummary.ScheduleLagMS = lagPercentilesMS(mergeReservoirs(lags))
+	}
+	merged.Authors.TopUploadsFrac = topFrac(merged.Authors.Uploads, topActivityFrac)
+	merged.Authors.TopSharesReceivedFrac = topFrac(merged.Authors.SharesReceived,
+		topActivityFrac)
+	if merged.Content.CompressedBytes > 0 {
+		merged.Content.CompressionRatio = float64(merged.Content.UploadedBytes) /
+			float64(merged.Content.CompressedBytes)
+	}
+	for _, op := range []string{largeUploadOp, largeDownloadOp} {
+		var pages, pageLatencies []*Reservoir
+		for _, result := range results {
+			if result.Samples != nil {
+				pages = append(pages, result.Samples.EstimatedPages[op])
+				pageLatencies = append(pageLatencies, result.Samples.PageLatencies[op])
+			}
+		}
+		merged.LargeDocs[op] = &LargeDocSummary{
+			EstimatedPages: countPercentiles(mergeReservoirs(pages).ints()),
+			PageLatencyMS:  latencyPercentilesMS(mergeReservoirs(pageLatencies).durations()),
+		}
+	}
+	mergeLibrarianFracs(merged.Librarians, results)
+	return merged
+}
+
+func mergeOperation(merged map[string]*OperationSummary, op string, s *OperationSummary) {
+	m, in := merged[op]
+	if !in {
+		m = &OperationSummary{
+			TimeoutMS: s.TimeoutMS,
+			Errors:    make(map[string]uint64),
+		}
+		merged[op] = m
+	}
+	m.Attempted += s.Attempted
+	m.Succeeded += s.Succeeded
+	m.Failed += s.Failed
+	m.Corrupt += s.Corrupt
+	m.Truncated += s.Truncated
+	m.Missing += s.Missing
+	m.TimedOut += s.TimedOut
+	m.Abandoned += s.Abandoned
+	m.FirstTrySucceeded += s.FirstTrySucceeded
+	m.Retries += s.Retries
+	addCounts(m.Errors, s.Errors)
+}
+
+// mergePool sums the workers and seconds of the same pool over workers, so the merged seconds
+// are total worker-seconds rather than wall time.
+func mergePool(merged map[string]*PoolSummary, name string, s *PoolSummary) {
+	m, in := merged[name]
+	if !in {
+		m = &PoolSummary{}
+		merged[name] = m
+	}
+	m.InitialWorkers += s.InitialWorkers
+	m.MaxWorkers += s.MaxWorkers
+	m.FinalWorkers += s.FinalWorkers
+	m.SaturatedSeconds += s.SaturatedSeconds
+	m.BlockedSeconds += s.BlockedSeconds
+}
+
+func mergeLibrarian(merged map[string]*LibrarianSummary, librarian string, s *LibrarianSummary) {
+	m, in := merged[librarian]
+	if !in {
+		m = &LibrarianSummary{
+			Attempted: make(map[string]uint64),
+			Succeeded: make(map[string]uint64),
+			Errors:    make(map[string]uint64),
+		}
+		merged[librarian] = m
+	}
+	addCounts(m.Attempted, s.Attempted)
+	addCounts(m.Succeeded, s.Succeeded)
+	addCounts(m.Errors, s.Errors)
+}
+
+// mergeLibrarianFracs sets the query fractions and latency percentiles of the merged librarian
+// summaries.
+func mergeLibrarianFracs(merged map[string]*LibrarianSummary, results []*WorkerResult) {
+	total := uint64(0)
+	for _, m := range merged {
+		for _, n := range m.Attempted {
+			total += n
+		}
+	}
+	for librarian, m := range merged {
+		for _, n := range m.Attempted {
+			m.QueryFrac += float64(n)
+		}
+		if total > 0 {
+			m.QueryFrac /= float64(total)
+		}
+		var latencies []*Reservoir
+		for _, result := range results {
+			if result.Samples != nil {
+				latencies = append(latencies, result.Samples.LibrarianLatencies[librarian])
+			}
+		}
+		m.LatencyMS = latencyPercentilesMS(mergeReservoirs(latencies).durations())
+	}
+}
+
+// mergePrefill sums the prefilled docs over workers; since the workers prefill concurrently, the
+// merged prefill takes as long as the slowest worker's.
+func mergePrefill(merged *PrefillSummary, s *PrefillSummary) {
+	merged.Docs += s.Docs
+	merged.Shares += s.Shares
+	merged.Bytes += s.Bytes
+	merged.Failed += s.Failed
+	if s.Seconds > merged.Seconds {
+		merged.Seconds = s.Seconds
+	}
+}
+
+func addCounts(to, from map[string]uint64) {
+	for k, n := range from {
+		to[k] += n
+	}
+}
@@ -0,0 +1,120 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeSummaries(t *testing.T) {
+	start := time.Now()
+	params := newDefaultParameters()
+	results := []*WorkerResult{
+		{
+			Worker: 0,
+			Summary: &Summary{
+				StartTime: start,
+				EndTime:   start.Add(10 * time.Second),
+				Operations: map[string]*OperationSummary{
+					uploadOp: {
+						Attempted:         4,
+						Succeeded:         3,
+						Failed:            1,
+						FirstTrySucceeded: 2,
+						Retries:           2,
+						TimeoutMS:         30000,
+						Errors:            map[string]uint64{deadlineExceededError: 1},
+					},
+				},
+				Pools:   map[string]*PoolSummary{uploadersPool: {InitialWorkers: 3, MaxWorkers: 12}},
+				Authors: &AuthorsSummary{Uploads: []uint64{3, 0}, SharesReceived: []uint64{1, 1}},
+				Content: &ContentSummary{UploadedBytes: 300, CompressedBytes: 100},
+				Librarians: map[string]*LibrarianSummary{
+					"lib-0": {Attempted: map[string]uint64{uploadOp: 4}},
+				},
+				Prefill:      &PrefillSummary{Docs: 5, Seconds: 2},
+				DrainSeconds: 1,
+			},
+			Samples: &Samples{
+				Latencies: map[string]*Reservoir{
+					uploadOp: durationReservoir(time.Millisecond, 2*time.Millisecond,
+						3*time.Millisecond),
+				},
+				ScheduleLags: map[string]*Reservoir{uploadOp: durationReservoir(5 * time.Millisecond)},
+			},
+		},
+		{
+			Worker: 1,
+			Summary: &Summary{
+				StartTime: start.Add(time.Second),
+				EndTime:   start.Add(20 * time.Second),
+				Operations: map[string]*OperationSummary{
+					uploadOp: {
+						Attempted:         1,
+						Succeeded:         1,
+						FirstTrySucceeded: 1,
+						TimeoutMS:         30000,
+						Errors:            map[string]uint64{},
+					},
+				},
+				Pools:   map[string]*PoolSummary{uploadersPool: {InitialWorkers: 3, MaxWorkers: 12}},
+				Authors: &AuthorsSummary{Uploads: []uint64{1}, SharesReceived: []uint64{0}},
+				Content: &ContentSummary{UploadedBytes: 100, CompressedBytes: 100},
+				Librarians: map[string]*LibrarianSummary{
+					"lib-0": {Attempted: map[string]uint64{uploadOp: 1}},
+					"lib-1": {Attempted: map[string]uint64{uploadOp: 3}},
+				},
+				Prefill:      &PrefillSummary{Docs: 5, Seconds: 3},
+				DrainSeconds: 2,
+			},
+			Samples: &Samples{
+				Latencies: map[string]*Reservoir{
+					uploadOp: durationReservoir(100 * time.Millisecond),
+				},
+				ScheduleLags: map[string]*Reservoir{
+					uploadOp: durationReservoir(50 * time.Millisecond),
+				},
+				LibrarianLatencies: map[string]*Reservoir{
+					"lib-1": durationReservoir(10 * time.Millisecond),
+				},
+			},
+		},
+	}
+
+	merged := mergeSummaries(params, results)
+	assert.Equal(t, params, merged.Parameters)
+	assert.Equal(t, start, merged.StartTime)
+	assert.Equal(t, start.Add(20*time.Second), merged.EndTime)
+	assert.Equal(t, 2.0, merged.DrainSeconds)
+	assert.Len(t, merged.Workers, 2)
+
+	upload := merged.Operations[uploadOp]
+	assert.Equal(t, uint64(5), upload.Attempted)
+	assert.Equal(t, uint64(4), upload.Succeeded)
+	assert.Equal(t, uint64(1), upload.Failed)
+	assert.Equal(t, uint64(3), upload.FirstTrySucceeded)
+	assert.Equal(t, uint64(2), upload.Retries)
+	assert.Equal(t, 30000.0, upload.TimeoutMS)
+	assert.Equal(t, map[string]uint64{deadlineExceededError: 1}, upload.Errors)
+	assert.Equal(t, 0.8, upload.SuccessRate)
+	assert.Equal(t, 0.6, upload.FirstTrySuccessRate)
+	assert.Equal(t, 0.2, upload.Throughput)
+	assert.Equal(t, 2.0, upload.LatencyMS["p50"])
+	assert.Equal(t, 100.0, upload.LatencyMS["p99"])
+	assert.Equal(t, 50.0, upload.ScheduleLagMS["max"])
+
+	assert.Equal(t, &PoolSummary{InitialWorkers: 6, MaxWorkers: 24}, merged.Pools[uploadersPool])
+	assert.Equal(t, []uint64{3, 0, 1}, merged.Authors.Uploads)
+	assert.Equal(t, []uint64{1, 1, 0}, merged.Authors.SharesReceived)
+	assert.Equal(t, 0.75, merged.Authors.TopUploadsFrac)
+	assert.Equal(t, uint64(400), merged.Content.UploadedBytes)
+	assert.Equal(t, 2.0, merged.Content.CompressionRatio)
+	assert.Equal(t, &PrefillSummary{Docs: 10, Seconds: 3}, merged.Prefill)
+
+	assert.Equal(t, uint64(5), merged.Librarians["lib-0"].Attempted[uploadOp])
+	assert.Equal(t, 5.0/8, merged.Librarians["lib-0"].QueryFrac)
+	assert.Equal(t, 3.0/8, merged.Librarians["lib-1"].QueryFrac)
+	assert.Equal(t, 10.0, merged.Librarians["lib-1"].LatencyMS["p50"])
+	assert.Empty(t, merged.LargeDocs[largeUploadOp].EstimatedPages)
+}
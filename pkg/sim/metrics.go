@@ -0,0 +1,238 @@
+package sim
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	metricsNamespace = "libri_exp"
+	metricsSubsystem = "client"
+
+	operationLabel  = "operation"
+	outcomeLabel    = "outcome"
+	errorClassLabel = "class"
+
+	uploadOp   = "upload"
+	shareOp    = "share"
+	downloadOp = "download"
+	rereadOp   = "reread"
+
+	largeUploadOp   = "large_upload"
+	largeDownloadOp = "large_download"
+
+	successOutcome   = "success"
+	errorOutcome     = "error"
+	corruptOutcome   = "corrupt"
+	truncatedOutcome = "truncated"
+	missingOutcome   = "missing"
+	timeoutOutcome   = "timeout"
+)
+
+var (
+	operations = []string{uploadOp, shareOp, downloadOp, rereadOp, largeUploadOp,
+		largeDownloadOp}
+
+	// 1ms to ~33s
+	latencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 16)
+)
+
+// queryMetrics records client-side counts and latencies of the queries the runner makes.
+type queryMetrics struct {
+	registry  *prometheus.Registry
+	counts    *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	latencies *prometheus.HistogramVec
+	lags      *prometheus.HistogramVec
+	abandoned *prometheus.CounterVec
+	retries   *retryMetrics
+
+	// per-operation samples of successful query latencies and schedule lags for the summary
+	// percentiles, and of the latencies since the dashboard last took them
+	samples    map[string]*Reservoir
+	lagSamples map[string]*Reservoir
+	recent     map[string]*Reservoir
+
+	// per-operation error counts by class
+	errorClasses map[string]map[string]uint64
+	mu           sync.Mutex
+}
+
+func newQueryMetrics() *queryMetrics {
+	counts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_count",
+			Help:      "number of queries made by the sim, by operation and outcome",
+		},
+		[]string{operationLabel, outcomeLabel},
+	)
+	errors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_error_count",
+			Help:      "number of failed queries made by the sim, by operation and error class",
+		},
+		[]string{operationLabel, errorClassLabel},
+	)
+	latencies := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_duration_seconds",
+			Help:      "client-side latency of queries made by the sim, by operation and outcome",
+			Buckets:   latencyBuckets,
+		},
+		[]string{operationLabel, outcomeLabel},
+	)
+	lags := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "schedule_lag_seconds",
+			Help:      "time between when queries were scheduled to start and when they started",
+			Buckets:   latencyBuckets,
+		},
+		[]string{operationLabel},
+	)
+	abandoned := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_abandoned_count",
+			Help:      "number of generated queries never made because the sim stopped, by operation",
+		},
+		[]string{operationLabel},
+	)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counts, errors, latencies, lags, abandoned)
+	return &queryMetrics{
+		registry:     registry,
+		counts:       counts,
+		errors:       errors,
+		latencies:    latencies,
+		lags:         lags,
+		abandoned:    abandoned,
+		retries:      newRetryMetrics(registry),
+		samples:      make(map[string]*Reservoir),
+		lagSamples:   make(map[string]*Reservoir),
+		recent:       make(map[string]*Reservoir),
+		errorClasses: make(map[string]map[string]uint64),
+	}
+}
+
+// errOutcome returns the outcome of a query with the given error.
+func errOutcome(err error) string {
+	switch err {
+	case nil:
+		return successOutcome
+	case errQueryTimeout:
+		return timeoutOutcome
+	}
+	return errorOutcome
+}
+
+// observe records a query with the given latency and error, returning the class of the error if
+// there was one.
+func (m *queryMetrics) observe(op string, latency time.Duration, err error) string {
+	if err == nil {
+		m.observeOutcome(op, successOutcome, latency)
+		return ""
+	}
+	class := classifyError(err)
+	m.errors.WithLabelValues(op, class).Inc()
+	m.mu.Lock()
+	if _, in := m.errorClasses[op]; !in {
+		m.errorClasses[op] = make(map[string]uint64)
+	}
+	m.errorClasses[op][class]++
+	m.mu.Unlock()
+	m.observeOutcome(op, errOutcome(err), latency)
+	return class
+}
+
+func (m *queryMetrics) observeOutcome(op string, outcome string, latency time.Duration) {
+	m.counts.WithLabelValues(op, outcome).Inc()
+	m.latencies.WithLabelValues(op, outcome).Observe(latency.Seconds())
+	if outcome == successOutcome {
+		m.mu.Lock()
+		addSample(m.samples, op, int64(latency))
+		addSample(m.recent, op, int64(latency))
+		m.mu.Unlock()
+	}
+}
+
+// observeLag records how far behind schedule a query started.
+func (m *queryMetrics) observeLag(op string, lag time.Duration) {
+	if lag < 0 {
+		lag = 0
+	}
+	m.lags.WithLabelValues(op).Observe(lag.Seconds())
+	m.mu.Lock()
+	addSample(m.lagSamples, op, int64(lag))
+	m.mu.Unlock()
+}
+
+// observeAbandoned records a generated query that was never made because the runner stopped.
+func (m *queryMetrics) observeAbandoned(op string) {
+	m.abandoned.WithLabelValues(op).Inc()
+}
+
+// abandonedCount returns the number of abandoned queries for the given operation.
+func (m *queryMetrics) abandonedCount(op string) uint64 {
+	metric := &dto.Metric{}
+	err := m.abandoned.WithLabelValues(op).Write(metric)
+	maybePanic(err) // should never happen
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// count returns the number of queries for the given operation and outcome.
+func (m *queryMetrics) count(op, outcome string) uint64 {
+	metric := &dto.Metric{}
+	err := m.counts.WithLabelValues(op, outcome).Write(metric)
+	maybePanic(err) // should never happen
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// errorCounts returns a copy of the error counts by class for the given operation.
+func (m *queryMetrics) errorCounts(op string) map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyCounts(m.errorClasses[op])
+}
+
+// latencySamples returns a copy of the sample of successful query latencies for the given
+// operation.
+func (m *queryMetrics) latencySamples(op string) *Reservoir {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.samples[op].copy()
+}
+
+// takeRecentLatencies returns a sample of the successful query latencies for the given
+// operation since it was last called.
+func (m *queryMetrics) takeRecentLatencies(op string) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	recent := m.recent[op]
+	delete(m.recent, op)
+	return recent.durations()
+}
+
+// scheduleLagSamples returns a copy of the sample of schedule lags for the given operation.
+func (m *queryMetrics) scheduleLagSamples(op string) *Reservoir {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lagSamples[op].copy()
+}
+
+func (m *queryMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
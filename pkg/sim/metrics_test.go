@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryMetrics_Observe(t *testing.T) {
+	m := newQueryMetrics()
+	m.observe(uploadOp, 10*time.Millisecond, nil)
+	m.observe(uploadOp, 20*time.Millisecond, nil)
+	m.observe(downloadOp, 30*time.Millisecond, errors.New("some download error"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.counts.WithLabelValues(uploadOp, successOutcome)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.counts.WithLabelValues(uploadOp, errorOutcome)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.counts.WithLabelValues(downloadOp, errorOutcome)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.counts.WithLabelValues(shareOp, successOutcome)))
+}
+
+func TestQueryMetrics_ObserveError(t *testing.T) {
+	m := newQueryMetrics()
+	assert.Equal(t, "", m.observe(uploadOp, 10*time.Millisecond, nil))
+	class := m.observe(uploadOp, 10*time.Millisecond, context.DeadlineExceeded)
+	assert.Equal(t, deadlineExceededError, class)
+	m.observe(uploadOp, 10*time.Millisecond, context.DeadlineExceeded)
+	m.observe(downloadOp, 10*time.Millisecond, errors.New("some download error"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(
+		m.errors.WithLabelValues(uploadOp, deadlineExceededError)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues(downloadOp, otherError)))
+	assert.Equal(t, map[string]uint64{deadlineExceededError: 2}, m.errorCounts(uploadOp))
+	assert.Equal(t, map[string]uint64{otherError: 1}, m.errorCounts(downloadOp))
+	assert.Empty(t, m.errorCounts(shareOp))
+
+	// timeouts are their own outcome
+	assert.Equal(t, timeoutError, m.observe(shareOp, 10*time.Millisecond, errQueryTimeout))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.counts.WithLabelValues(shareOp, timeoutOutcome)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.counts.WithLabelValues(shareOp, errorOutcome)))
+}
+
+func TestQueryMetrics_Handler(t *testing.T) {
+	m := newQueryMetrics()
+	m.observe(shareOp, 10*time.Millisecond, nil)
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "libri_exp_client_query_count"))
+	assert.True(t, strings.Contains(body, "libri_exp_client_query_duration_seconds_bucket"))
+}
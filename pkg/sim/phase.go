@@ -0,0 +1,164 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultPhaseName = "default"
+	phaseLabel       = "phase"
+)
+
+// Phase is a period of an experiment with its own upload rate, content size distribution, and
+// number of shares per upload. Zero-valued fields fall back to the experiment's Parameters, so a
+// phase can change just the parameters of the experiment's content size distribution or switch
+// to another distribution altogether.
+type Phase struct {
+	Name                        string
+	Duration                    time.Duration
+	DocsPerDay                  uint
+	ContentSizeDist             string
+	ContentSizeKBGammaShape     float64
+	ContentSizeKBGammaRate      float64
+	ContentSizeKBLogNormalMu    float64
+	ContentSizeKBLogNormalSigma float64
+	ContentSizeKBParetoMin      float64
+	ContentSizeKBParetoAlpha    float64
+	ContentSizeKBFixed          float64
+	ContentSizeMixture          []string
+	ContentSizeHistogram        string
+	SharesPerUpload             uint
+}
+
+// getPhases returns the experiment's phases with zero-valued fields filled in from the
+// parameters. Without any phases in the parameters, the experiment has a single phase with the
+// parameters' duration.
+func getPhases(params *Parameters) ([]*Phase, error) {
+	if len(params.Phases) == 0 {
+		phase := &Phase{Name: defaultPhaseName, Duration: params.Duration}
+		fillPhase(phase, params)
+		return []*Phase{phase}, nil
+	}
+	phases := make([]*Phase, len(params.Phases))
+	names := make(map[string]struct{})
+	for i, p := range params.Phases {
+		if p.Duration <= 0 {
+			return nil, fmt.Errorf("phase %d has non-positive duration %v", i, p.Duration)
+		}
+		phase := *p
+		if phase.Name == "" {
+			phase.Name = fmt.Sprintf("phase-%d", i)
+		}
+		if _, in := names[phase.Name]; in {
+			return nil, fmt.Errorf("duplicate phase name %q", phase.Name)
+		}
+		names[phase.Name] = struct{}{}
+		fillPhase(&phase, params)
+		phases[i] = &phase
+	}
+	return phases, nil
+}
+
+// fillPhase fills in the phase's zero-valued fields from the parameters.
+func fillPhase(phase *Phase, params *Parameters) {
+	if phase.DocsPerDay == 0 {
+		phase.DocsPerDay = params.DocsPerDay
+	}
+	if phase.ContentSizeDist == "" {
+		phase.ContentSizeDist = params.ContentSizeDist
+	}
+	fillFloat(&phase.ContentSizeKBGammaShape, params.ContentSizeKBGammaShape)
+	fillFloat(&phase.ContentSizeKBGammaRate, params.ContentSizeKBGammaRate)
+	fillFloat(&phase.ContentSizeKBLogNormalMu, params.ContentSizeKBLogNormalMu)
+	fillFloat(&phase.ContentSizeKBLogNormalSigma, params.ContentSizeKBLogNormalSigma)
+	fillFloat(&phase.ContentSizeKBParetoMin, params.ContentSizeKBParetoMin)
+	fillFloat(&phase.ContentSizeKBParetoAlpha, params.ContentSizeKBParetoAlpha)
+	fillFloat(&phase.ContentSizeKBFixed, params.ContentSizeKBFixed)
+	if len(phase.ContentSizeMixture) == 0 {
+		phase.ContentSizeMixture = params.ContentSizeMixture
+	}
+	if phase.ContentSizeHistogram == "" {
+		phase.ContentSizeHistogram = params.ContentSizeHistogram
+	}
+	if phase.SharesPerUpload == 0 {
+		phase.SharesPerUpload = params.SharesPerUpload
+	}
+}
+
+func fillFloat(value *float64, fallback float64) {
+	if *value == 0 {
+		*value = fallback
+	}
+}
+
+// newPhaseContentSizeDists returns the content size distribution of each phase.
+func newPhaseContentSizeDists(phases []*Phase) ([]contentSizeDist, error) {
+	dists := make([]contentSizeDist, len(phases))
+	for i, phase := range phases {
+		dist, err := newContentSizeDist(phase)
+		if err != nil {
+			return nil, fmt.Errorf("phase %q: %v", phase.Name, err)
+		}
+		dists[i] = dist
+	}
+	return dists, nil
+}
+
+// totalDuration returns the sum of the phases' durations.
+func totalDuration(phases []*Phase) time.Duration {
+	total := time.Duration(0)
+	for _, p := range phases {
+		total += p.Duration
+	}
+	return total
+}
+
+// eventWaitMS returns the mean wait in milliseconds between events (e.g., uploads) for the given
+// number of authors each generating the given number of events per day.
+func eventWaitMS(nAuthors, eventsPerDay uint) float64 {
+	eventsPerSecond := float64(nAuthors) * float64(eventsPerDay) / (24 * 3600)
+	return 1000 / eventsPerSecond
+}
+
+// phaseSamplers creates the upload wait and upload event samplers for a phase with the given
+// content size distribution.
+func phaseSamplers(
+	phase *Phase,
+	nAuthors uint,
+	authors directory,
+	downloadWait durationSampler,
+	contentSizes contentSizeDist,
+	contentTypes *contentTypeSampler,
+	largeDocs *largeDocSampler,
+	uploadWaitRNG, contentRNG *rand.Rand,
+) (durationSampler, uploadEventSampler) {
+	nextUploadWait := newExponentialDurationSampler(uploadWaitRNG,
+		eventWaitMS(nAuthors, phase.DocsPerDay))
+	upDocs := &uploadEventSamplerImpl{
+		authors:          authors,
+		nSharesPerUpload: phase.SharesPerUpload,
+		downloadWait:     downloadWait,
+		content:          newSizeContentSampler(contentSizes, contentRNG),
+		contentTypes:     contentTypes,
+		largeDocs:        largeDocs,
+	}
+	return nextUploadWait, upDocs
+}
+
+func newActivePhaseGauge(registry *prometheus.Registry) *prometheus.GaugeVec {
+	activePhase := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "active_phase",
+			Help:      "1 for the currently active experiment phase, 0 for the others",
+		},
+		[]string{phaseLabel},
+	)
+	registry.MustRegister(activePhase)
+	return activePhase
+}
@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPhases_default(t *testing.T) {
+	params := newDefaultParameters()
+	phases, err := getPhases(params)
+	assert.Nil(t, err)
+	assert.Len(t, phases, 1)
+	assert.Equal(t, defaultPhaseName, phases[0].Name)
+	assert.Equal(t, params.Duration, phases[0].Duration)
+	assert.Equal(t, params.DocsPerDay, phases[0].DocsPerDay)
+	assert.Equal(t, params.SharesPerUpload, phases[0].SharesPerUpload)
+	assert.Equal(t, params.Duration, totalDuration(phases))
+}
+
+func TestGetPhases_fallback(t *testing.T) {
+	params := newDefaultParameters()
+	params.Phases = []*Phase{
+		{Name: "warm", Duration: time.Minute, DocsPerDay: 10},
+		{Duration: 2 * time.Minute, SharesPerUpload: 4},
+	}
+	phases, err := getPhases(params)
+	assert.Nil(t, err)
+	assert.Len(t, phases, 2)
+
+	assert.Equal(t, "warm", phases[0].Name)
+	assert.Equal(t, uint(10), phases[0].DocsPerDay)
+	assert.Equal(t, params.SharesPerUpload, phases[0].SharesPerUpload)
+	assert.Equal(t, params.ContentSizeKBGammaShape, phases[0].ContentSizeKBGammaShape)
+
+	assert.Equal(t, "phase-1", phases[1].Name)
+	assert.Equal(t, params.DocsPerDay, phases[1].DocsPerDay)
+	assert.Equal(t, uint(4), phases[1].SharesPerUpload)
+	assert.Equal(t, params.ContentSizeKBGammaRate, phases[1].ContentSizeKBGammaRate)
+
+	assert.Equal(t, 3*time.Minute, totalDuration(phases))
+
+	// original phases are left unchanged
+	assert.Zero(t, params.Phases[0].SharesPerUpload)
+}
+
+func TestGetPhases_err(t *testing.T) {
+	params := newDefaultParameters()
+	params.Phases = []*Phase{{Name: "zero"}}
+	phases, err := getPhases(params)
+	assert.NotNil(t, err)
+	assert.Nil(t, phases)
+
+	params.Phases = []*Phase{
+		{Name: "same", Duration: time.Minute},
+		{Name: "same", Duration: time.Minute},
+	}
+	phases, err = getPhases(params)
+	assert.NotNil(t, err)
+	assert.Nil(t, phases)
+
+	params.Phases = []*Phase{{Name: "busy", Duration: time.Minute, DocsPerDay: 10}}
+	phases, err = getPhases(params)
+	assert.Nil(t, err)
+	assert.Len(t, phases, 1)
+}
+
+func TestNewPhaseContentSizeDists(t *testing.T) {
+	params := newDefaultParameters()
+	params.Phases = []*Phase{
+		{Name: "small", Duration: time.Minute, ContentSizeDist: FixedContentSize,
+			ContentSizeKBFixed: 1},
+		{Name: "large", Duration: time.Minute, ContentSizeDist: FixedContentSize,
+			ContentSizeKBFixed: 100},
+		{Name: "default", Duration: time.Minute},
+	}
+	phases, err := getPhases(params)
+	assert.Nil(t, err)
+	assert.Equal(t, params.ContentSizeDist, phases[2].ContentSizeDist)
+	assert.Equal(t, params.ContentSizeKBGammaShape, phases[2].ContentSizeKBGammaShape)
+	assert.Equal(t, params.ContentSizeMixture, phases[2].ContentSizeMixture)
+
+	// each phase samples content sizes from its own distribution
+	dists, err := newPhaseContentSizeDists(phases)
+	assert.Nil(t, err)
+	assert.Len(t, dists, 3)
+	rng := rand.New(rand.NewSource(0))
+	size, _ := newSizeContentSampler(dists[0], rng).sample()
+	assert.Equal(t, 1024, size)
+	size, _ = newSizeContentSampler(dists[1], rng).sample()
+	assert.Equal(t, 100*1024, size)
+
+	params.Phases[1].ContentSizeDist = ParetoContentSize
+	params.Phases[1].ContentSizeKBParetoAlpha = -1
+	phases, err = getPhases(params)
+	assert.Nil(t, err)
+	dists, err = newPhaseContentSizeDists(phases)
+	assert.NotNil(t, err)
+	assert.Nil(t, dists)
+}
+
+func TestUploadWaitMS(t *testing.T) {
+	assert.Equal(t, 1000.0, eventWaitMS(24, 3600))
+}
@@ -0,0 +1,232 @@
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	uploadersPool   = "uploaders"
+	downloadersPool = "downloaders"
+
+	poolLabel = "pool"
+
+	// poolCheckInterval is how often each pool checks its queue backlog.
+	poolCheckInterval = 1 * time.Second
+
+	// poolGrowChecks is the number of consecutive backlogged checks before a pool adds a worker.
+	poolGrowChecks = 5
+)
+
+// workerPool runs a set of identical workers consuming from a queue and adds workers, up to a
+// max, while the queue stays backlogged. When the pool is at its max and the queue is still
+// backlogged, the sim itself (rather than the cluster) is the bottleneck, and the pool records
+// that time as saturated.
+type workerPool struct {
+	name     string
+	work     func()
+	queueLen func() int
+	queueCap int
+	initial  uint
+	max      uint
+	metrics  *poolMetrics
+	logger   *zap.Logger
+
+	n          uint
+	backlogged uint
+	saturated  time.Duration
+	blocked    time.Duration
+	isSat      bool
+	stopped    bool
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+func newWorkerPool(
+	name string,
+	work func(),
+	queueLen func() int,
+	queueCap int,
+	initial, max uint,
+	metrics *poolMetrics,
+	logger *zap.Logger,
+) *workerPool {
+	if max < initial {
+		max = initial
+	}
+	return &workerPool{
+		name:     name,
+		work:     work,
+		queueLen: queueLen,
+		queueCap: queueCap,
+		initial:  initial,
+		max:      max,
+		metrics:  metrics,
+		logger:   logger,
+	}
+}
+
+// start starts the initial workers and monitors the queue backlog until done is closed.
+func (p *workerPool) start(done <-chan struct{}) {
+	p.mu.Lock()
+	for c := uint(0); c < p.initial; c++ {
+		p.startWorker()
+	}
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(poolCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.check()
+			}
+		}
+	}()
+}
+
+// wait stops the pool from adding any more workers and waits for the existing ones to finish.
+func (p *workerPool) wait() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// addBlocked records time a producer spent blocked sending to the pool's full queue.
+func (p *workerPool) addBlocked(d time.Duration) {
+	p.mu.Lock()
+	p.blocked += d
+	p.mu.Unlock()
+	p.metrics.blockedSeconds.WithLabelValues(p.name).Add(d.Seconds())
+}
+
+func (p *workerPool) startWorker() {
+	if p.stopped {
+		return
+	}
+	p.n++
+	p.metrics.workers.WithLabelValues(p.name).Set(float64(p.n))
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.work()
+	}()
+}
+
+func (p *workerPool) check() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queueLen := p.queueLen()
+	p.metrics.queueLength.WithLabelValues(p.name).Set(float64(queueLen))
+	if queueLen < p.queueCap/2 {
+		p.backlogged = 0
+		if p.isSat {
+			p.isSat = false
+			p.logger.Info("worker pool no longer saturated", zap.String("pool", p.name))
+		}
+		return
+	}
+	p.backlogged++
+	if p.n >= p.max {
+		p.saturated += poolCheckInterval
+		p.metrics.saturatedSeconds.WithLabelValues(p.name).Add(poolCheckInterval.Seconds())
+		if !p.isSat {
+			p.isSat = true
+			p.logger.Warn("worker pool saturated; sim is the bottleneck",
+				zap.String("pool", p.name),
+				zap.Uint("n_workers", p.n),
+				zap.Int("queue_length", queueLen),
+			)
+		}
+		return
+	}
+	if p.backlogged >= poolGrowChecks {
+		p.backlogged = 0
+		p.startWorker()
+		p.logger.Info("added worker to backlogged pool",
+			zap.String("pool", p.name),
+			zap.Uint("n_workers", p.n),
+			zap.Int("queue_length", queueLen),
+		)
+	}
+}
+
+// workers returns the current number of workers.
+func (p *workerPool) workers() uint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.n
+}
+
+func (p *workerPool) summary() *PoolSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &PoolSummary{
+		InitialWorkers:   p.initial,
+		MaxWorkers:       p.max,
+		FinalWorkers:     p.n,
+		SaturatedSeconds: p.saturated.Seconds(),
+		BlockedSeconds:   p.blocked.Seconds(),
+	}
+}
+
+// poolMetrics records the size and backlog of the runner's worker pools.
+type poolMetrics struct {
+	workers          *prometheus.GaugeVec
+	queueLength      *prometheus.GaugeVec
+	saturatedSeconds *prometheus.CounterVec
+	blockedSeconds   *prometheus.CounterVec
+}
+
+func newPoolMetrics(registry *prometheus.Registry) *poolMetrics {
+	workers := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pool_workers",
+			Help:      "number of workers in each pool",
+		},
+		[]string{poolLabel},
+	)
+	queueLength := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pool_queue_length",
+			Help:      "number of events waiting in each pool's queue",
+		},
+		[]string{poolLabel},
+	)
+	saturatedSeconds := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pool_saturated_seconds",
+			Help:      "time each pool spent backlogged at its max number of workers",
+		},
+		[]string{poolLabel},
+	)
+	blockedSeconds := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pool_enqueue_blocked_seconds",
+			Help:      "time producers spent blocked on each pool's full queue",
+		},
+		[]string{poolLabel},
+	)
+	registry.MustRegister(workers, queueLength, saturatedSeconds, blockedSeconds)
+	return &poolMetrics{
+		workers:          workers,
+		queueLength:      queueLength,
+		saturatedSeconds: saturatedSeconds,
+		blockedSeconds:   blockedSeconds,
+	}
+}
@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWorkerPool_StartWait(t *testing.T) {
+	queue := make(chan struct{}, 4)
+	work := func() {
+		for range queue {
+		}
+	}
+	p := newTestWorkerPool(work, queue, 2, 4)
+	done := make(chan struct{})
+	p.start(done)
+	assert.Equal(t, uint(2), p.n)
+
+	close(done)
+	close(queue)
+	p.wait()
+
+	// stopped pools don't add any more workers
+	p.startWorker()
+	assert.Equal(t, uint(2), p.n)
+}
+
+func TestWorkerPool_Check(t *testing.T) {
+	queue := make(chan struct{}, 4)
+	block := make(chan struct{})
+	work := func() { <-block }
+	p := newTestWorkerPool(work, queue, 1, 2)
+	p.startWorker() // start without monitoring so only the explicit checks below happen
+	defer func() {
+		close(block)
+		p.wait()
+	}()
+
+	// no backlog, so no growth
+	for c := 0; c < 2*poolGrowChecks; c++ {
+		p.check()
+	}
+	assert.Equal(t, uint(1), p.n)
+
+	// persistent backlog adds a worker
+	queue <- struct{}{}
+	queue <- struct{}{}
+	for c := 0; c < poolGrowChecks; c++ {
+		p.check()
+	}
+	assert.Equal(t, uint(2), p.n)
+	assert.Zero(t, p.saturated)
+
+	// backlog at max workers counts as saturated
+	p.check()
+	p.check()
+	assert.Equal(t, uint(2), p.n)
+	assert.Equal(t, 2*poolCheckInterval, p.saturated)
+	assert.True(t, p.isSat)
+
+	// draining the backlog ends saturation
+	<-queue
+	<-queue
+	p.check()
+	assert.False(t, p.isSat)
+
+	p.addBlocked(time.Second)
+	s := p.summary()
+	assert.Equal(t, uint(1), s.InitialWorkers)
+	assert.Equal(t, uint(2), s.MaxWorkers)
+	assert.Equal(t, uint(2), s.FinalWorkers)
+	assert.Equal(t, 2.0, s.SaturatedSeconds)
+	assert.Equal(t, 1.0, s.BlockedSeconds)
+}
+
+func TestNewWorkerPool_MaxBelowInitial(t *testing.T) {
+	p := newTestWorkerPool(func() {}, make(chan struct{}), 4, 2)
+	assert.Equal(t, uint(4), p.max)
+}
+
+func newTestWorkerPool(work func(), queue chan struct{}, initial, max uint) *workerPool {
+	return newWorkerPool("test", work, func() int { return len(queue) }, cap(queue),
+		initial, max, newPoolMetrics(prometheus.NewRegistry()), zap.NewNop())
+}
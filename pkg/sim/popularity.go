@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/drausin/libri/libri/common/id"
+)
+
+const (
+	// ZipfPopularity gives each shared document a random, fixed popularity rank, with re-reads
+	// of the document with rank k proportional to 1/k^s for exponent s.
+	ZipfPopularity = "zipf"
+
+	// RecencyPopularity ranks shared documents by how recently they were shared, with re-reads
+	// of the kth most recent document proportional to 1/k^s for exponent s.
+	RecencyPopularity = "recency"
+)
+
+// catalogueDoc is a shared document that its recipient can re-read.
+type catalogueDoc struct {
+	ref    catalogueRef
+	to     *author.Author
+	envKey id.ID
+	digest contentDigest
+}
+
+// catalogueRef identifies a catalogue doc in a trace, so a replay re-reads the same docs as the
+// recording. Docs shared during the experiment are identified by the index of their upload among
+// the experiment's uploads and of their share among the upload's, since their envelope keys
+// differ between runs, and docs shared before the experiment by their envelope key.
+type catalogueRef struct {
+	Upload int    `json:"u,omitempty"`
+	Share  int    `json:"sh,omitempty"`
+	EnvKey string `json:"ek,omitempty"`
+}
+
+// envKeyRef returns the ref of a doc shared before the experiment with the given envelope key.
+func envKeyRef(envKey id.ID) catalogueRef {
+	return catalogueRef{EnvKey: hex.EncodeToString(envKey.Bytes())}
+}
+
+// catalogue contains the documents shared during the experiment, ordered by popularity.
+type catalogue struct {
+	docs     []*catalogueDoc
+	byRef    map[catalogueRef]*catalogueDoc
+	added    chan struct{}
+	recency  bool
+	exponent float64
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+func newCatalogue(params *Parameters, rng *rand.Rand) (*catalogue, error) {
+	if params.PopularityZipfExponent <= 1 {
+		return nil, fmt.Errorf("popularity exponent %v must be greater than 1",
+			params.PopularityZipfExponent)
+	}
+	c := &catalogue{
+		byRef:    make(map[catalogueRef]*catalogueDoc),
+		added:    make(chan struct{}),
+		exponent: params.PopularityZipfExponent,
+		rng:      rng,
+	}
+	switch params.Popularity {
+	case ZipfPopularity, "":
+	case RecencyPopularity:
+		c.recency = true
+	default:
+		return nil, fmt.Errorf("unknown popularity %q", params.Popularity)
+	}
+	return c, nil
+}
+
+// add adds a newly shared document to the catalogue.
+func (c *catalogue) add(doc *catalogueDoc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byRef[doc.ref] = doc
+	close(c.added)
+	c.added = make(chan struct{})
+	if c.recency {
+		// most recent is last
+		c.docs = append(c.docs, doc)
+		return
+	}
+	// insert at a random rank
+	i := c.rng.Intn(len(c.docs) + 1)
+	c.docs = append(c.docs, nil)
+	copy(c.docs[i+1:], c.docs[i:])
+	c.docs[i] = doc
+}
+
+// sample returns a document to re-read according to its popularity, or nil if the catalogue is
+// empty.
+func (c *catalogue) sample() *catalogueDoc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.docs)
+	if n == 0 {
+		return nil
+	}
+	k := int(rand.NewZipf(c.rng, c.exponent, 1, uint64(n-1)).Uint64())
+	if c.recency {
+		return c.docs[n-1-k]
+	}
+	return c.docs[k]
+}
+
+// await returns the document with the given ref, waiting for it to be added until the giveUp
+// channel is closed, after which it returns nil if the document still isn't in the catalogue.
+func (c *catalogue) await(ref catalogueRef, giveUp <-chan struct{}) *catalogueDoc {
+	for {
+		c.mu.Lock()
+		doc, added := c.byRef[ref], c.added
+		c.mu.Unlock()
+		if doc != nil {
+			return doc
+		}
+		select {
+		case <-added:
+		case <-giveUp:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.byRef[ref]
+		}
+	}
+}
+
+// len returns the number of documents in the catalogue.
+func (c *catalogue) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.docs)
+}
@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCatalogue_err(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	params := newDefaultParameters()
+	params.Popularity = "unknown"
+	c, err := newCatalogue(params, rng)
+	assert.NotNil(t, err)
+	assert.Nil(t, c)
+
+	params = newDefaultParameters()
+	params.PopularityZipfExponent = 1.0
+	c, err = newCatalogue(params, rng)
+	assert.NotNil(t, err)
+	assert.Nil(t, c)
+}
+
+func TestCatalogue_sample(t *testing.T) {
+	for _, popularity := range []string{ZipfPopularity, RecencyPopularity} {
+		params := newDefaultParameters()
+		params.Popularity = popularity
+		c, err := newCatalogue(params, rand.New(rand.NewSource(0)))
+		assert.Nil(t, err)
+		assert.Nil(t, c.sample())
+
+		nDocs := 100
+		docs := make([]*catalogueDoc, nDocs)
+		for i := range docs {
+			docs[i] = &catalogueDoc{digest: contentDigest{size: i}}
+			c.add(docs[i])
+		}
+		assert.Equal(t, nDocs, c.len())
+
+		counts := make(map[*catalogueDoc]int)
+		nSamples := 10000
+		for i := 0; i < nSamples; i++ {
+			counts[c.sample()]++
+		}
+
+		// most popular doc gets a large fraction of re-reads
+		maxCount := 0
+		var mostPopular *catalogueDoc
+		for doc, count := range counts {
+			if count > maxCount {
+				maxCount, mostPopular = count, doc
+			}
+		}
+		assert.True(t, float64(maxCount)/float64(nSamples) > 0.2, popularity)
+		if popularity == RecencyPopularity {
+			assert.Equal(t, docs[nDocs-1], mostPopular)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package sim
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// prefillLogInterval is how often prefill progress is logged.
+	prefillLogInterval = 10 * time.Second
+)
+
+// PrefillSummary summarizes the documents uploaded before the experiment was measured.
+type PrefillSummary struct {
+	// Docs and Shares are the numbers of documents uploaded and shared.
+	Docs   uint64
+	Shares uint64
+
+	// Bytes is the total size of uploaded content.
+	Bytes uint64
+
+	// Failed is the number of failed uploads and shares.
+	Failed uint64
+
+	// Seconds is how long the prefill took.
+	Seconds float64
+}
+
+// prefillTarget is the number of documents or size of content a prefill generates.
+type prefillTarget struct {
+	docs  uint64
+	bytes uint64
+}
+
+func newPrefillTarget(params *Parameters) prefillTarget {
+	return prefillTarget{
+		docs:  uint64(params.PrefillDocs),
+		bytes: uint64(params.PrefillGB * 1024 * 1024 * 1024),
+	}
+}
+
+// reached returns whether the given numbers of generated documents and bytes reach either the
+// target doc count or size. A zero target is always reached.
+func (t prefillTarget) reached(docs, bytes uint64) bool {
+	return (t.docs == 0 && t.bytes == 0) ||
+		(t.docs > 0 && docs >= t.docs) ||
+		(t.bytes > 0 && bytes >= t.bytes)
+}
+
+// prefillSize records the documents uploaded and shared during a prefill.
+type prefillSize struct {
+	summary PrefillSummary
+	mu      sync.Mutex
+}
+
+func (p *prefillSize) addUpload(size int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.summary.Failed++
+		return
+	}
+	p.summary.Docs++
+	p.summary.Bytes += uint64(size)
+}
+
+func (p *prefillSize) addShare(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.summary.Failed++
+		return
+	}
+	p.summary.Shares++
+}
+
+func (p *prefillSize) snapshot() PrefillSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.summary
+}
+
+// WritePrefillManifest sets the writer to record the envelope key and recipient of every
+// document shared during Prefill to.
+func (r *Runner) WritePrefillManifest(manifest io.Writer) {
+	r.prefillManifest = newReceivedWriter(manifest)
+}
+
+// Prefill seeds the cluster with documents by uploading and sharing them as fast as the upload
+// workers allow until PrefillDocs documents or PrefillGB of content have been uploaded. Prefill
+// queries aren't measured as experiment traffic, but the shared documents join the catalogue the
+// experiment re-reads from.
+func (r *Runner) Prefill() {
+	r.watchStopSignals()
+	start := time.Now()
+	target := newPrefillTarget(r.params)
+	size := new(prefillSize)
+	toPrefill := make(chan *uploadEvent, toUploadSlack)
+	wg := new(sync.WaitGroup)
+	for c := uint(0); c < r.params.MaxUploaders; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range toPrefill {
+				r.prefillOne(event, size)
+			}
+		}()
+	}
+
+	r.logger.Info("starting prefill",
+		zap.Uint("docs", r.params.PrefillDocs),
+		zap.Float64("gb", r.params.PrefillGB),
+	)
+	lastLog := start
+	var nDocs, nBytes uint64
+	for !target.reached(nDocs, nBytes) && !r.stopped() {
+		event := r.upDocs.sample()
+		nDocs, nBytes = nDocs+1, nBytes+uint64(event.size)
+		toPrefill <- event
+		if time.Since(lastLog) > prefillLogInterval {
+			progress := size.snapshot()
+			r.logger.Info("prefilling",
+				zap.Uint64("docs", progress.Docs),
+				zap.Uint64("bytes", progress.Bytes),
+			)
+			lastLog = time.Now()
+		}
+	}
+	close(toPrefill)
+	wg.Wait()
+
+	summary := size.snapshot()
+	summary.Seconds = time.Since(start).Seconds()
+	r.prefill = &summary
+	r.logger.Info("finished prefill",
+		zap.Uint64("docs", summary.Docs),
+		zap.Uint64("shares", summary.Shares),
+		zap.Uint64("bytes", summary.Bytes),
+		zap.Uint64("failed", summary.Failed),
+	)
+}
+
+// prefillOne uploads and shares the document of the given event.
+func (r *Runner) prefillOne(event *uploadEvent, size *prefillSize) {
+	content := event.reader()
+	var observed *contentObserver
+	if event.large {
+		observed = newContentObserver(false)
+		content = io.TeeReader(content, observed)
+	}
+	env, err := r.upload(event.op(), event.from, content, event.contentType.mediaType)
+	if err != nil {
+		r.logger.Info("prefill upload errored", zap.Error(err))
+		size.addUpload(0, err)
+		return
+	}
+	size.addUpload(event.size, nil)
+	if event.large {
+		event.digest = observed.digest()
+	}
+	for i, withPub := range event.shareWith {
+		shareEnvKey, err := r.share(event.from, env, withPub)
+		size.addShare(err)
+		if err != nil {
+			r.logger.Info("prefill share errored", zap.Error(err))
+			continue
+		}
+		if r.catalogue != nil {
+			r.catalogue.add(&catalogueDoc{
+				ref:    envKeyRef(shareEnvKey),
+				to:     r.authors.get(withPub),
+				envKey: shareEnvKey,
+				digest: event.digest,
+			})
+		}
+		doc := newReceivedDoc(event.shareWithIdxs[i], shareEnvKey, event.digest)
+		if r.received != nil {
+			if err := r.received.record(doc); err != nil {
+				r.logger.Error("error recording received doc", zap.Error(err))
+			}
+		}
+		if r.prefillManifest != nil {
+			if err := r.prefillManifest.record(doc); err != nil {
+				r.logger.Error("error writing prefill manifest record", zap.Error(err))
+			}
+		}
+	}
+}
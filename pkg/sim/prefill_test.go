@@ -0,0 +1,79 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefillTarget_reached(t *testing.T) {
+	assert.True(t, prefillTarget{}.reached(0, 0))
+
+	docs := prefillTarget{docs: 10}
+	assert.False(t, docs.reached(9, 1<<40))
+	assert.True(t, docs.reached(10, 0))
+
+	size := newPrefillTarget(&Parameters{PrefillGB: 1})
+	assert.False(t, size.reached(1<<20, 1<<30-1))
+	assert.True(t, size.reached(1, 1<<30))
+
+	both := prefillTarget{docs: 10, bytes: 100}
+	assert.True(t, both.reached(10, 0))
+	assert.True(t, both.reached(0, 100))
+}
+
+func TestRunner_Prefill(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	params.PrefillDocs = 20
+	params.ReReadsPerDay = 1000
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	manifest := new(bytes.Buffer)
+	r.WritePrefillManifest(manifest)
+	r.Prefill()
+
+	nShares := int(params.PrefillDocs * params.SharesPerUpload)
+	assert.Equal(t, uint64(params.PrefillDocs), r.prefill.Docs)
+	assert.Equal(t, uint64(nShares), r.prefill.Shares)
+	assert.Zero(t, r.prefill.Failed)
+	assert.True(t, r.prefill.Bytes > 0)
+	assert.Equal(t, nShares, r.catalogue.len())
+
+	// prefill isn't measured as experiment traffic
+	assert.Zero(t, r.metrics.count(uploadOp, successOutcome))
+	assert.Zero(t, r.metrics.count(shareOp, successOutcome))
+
+	// manifest has a record per share
+	dec := json.NewDecoder(manifest)
+	nRecords := 0
+	for dec.More() {
+		doc := new(receivedDoc)
+		assert.Nil(t, dec.Decode(doc))
+		assert.True(t, doc.Author >= 0 && doc.Author < int(params.NAuthors))
+		assert.NotEmpty(t, doc.EnvKey)
+		nRecords++
+	}
+	assert.Equal(t, nShares, nRecords)
+}
+
+func TestRunner_RunPrefill(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 200 * time.Millisecond
+	params.NAuthors = 5
+	params.DocsPerDay = 100000
+	params.PrefillDocs = 10
+	params.LoadProfile = ConstantLoadProfile
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.Run()
+
+	summary := r.Summary()
+	assert.NotNil(t, summary.Prefill)
+	assert.Equal(t, uint64(params.PrefillDocs), summary.Prefill.Docs)
+}
@@ -0,0 +1,136 @@
+package sim
+
+import (
+	"math/rand"
+	"time"
+)
+
+// maxSamples is the most values a Reservoir keeps, enough for the summary percentiles up to p99
+// to be close to exact however long the experiment runs.
+const maxSamples = 10000
+
+// Reservoir is a uniform random sample of at most maxSamples of the values added to it (Vitter's
+// Algorithm R), so the distributions behind the summary percentiles take bounded memory.
+type Reservoir struct {
+	// Values are the sampled values.
+	Values []int64
+
+	// N is the number of values added, of which Values is a sample.
+	N uint64
+
+	// Max is the largest value added.
+	Max int64
+
+	rng *rand.Rand
+}
+
+func newReservoir() *Reservoir {
+	return &Reservoir{rng: rand.New(rand.NewSource(0))}
+}
+
+// add adds a value, keeping it in place of a random sampled value with probability
+// maxSamples / N once the reservoir is full.
+func (r *Reservoir) add(value int64) {
+	if r.N == 0 || value > r.Max {
+		r.Max = value
+	}
+	r.N++
+	if len(r.Values) < maxSamples {
+		r.Values = append(r.Values, value)
+		return
+	}
+	if i := r.rng.Int63n(int64(r.N)); i < maxSamples {
+		r.Values[i] = value
+	}
+}
+
+// copy returns a copy of the reservoir's sample, which doesn't take further values.
+func (r *Reservoir) copy() *Reservoir {
+	if r == nil {
+		return nil
+	}
+	return &Reservoir{
+		Values: append([]int64(nil), r.Values...),
+		N:      r.N,
+		Max:    r.Max,
+	}
+}
+
+// durations returns a copy of the sampled values as durations.
+func (r *Reservoir) durations() []time.Duration {
+	if r == nil {
+		return nil
+	}
+	durations := make([]time.Duration, len(r.Values))
+	for i, v := range r.Values {
+		durations[i] = time.Duration(v)
+	}
+	return durations
+}
+
+// ints returns a copy of the sampled values as ints.
+func (r *Reservoir) ints() []int {
+	if r == nil {
+		return nil
+	}
+	ints := make([]int, len(r.Values))
+	for i, v := range r.Values {
+		ints[i] = int(v)
+	}
+	return ints
+}
+
+// mergeReservoirs merges the given reservoirs, any of which may be nil, into a sample of all
+// their values. When their samples together exceed maxSamples, each contributes a random subset
+// in proportion to the number of values it was sampled from.
+func mergeReservoirs(reservoirs []*Reservoir) *Reservoir {
+	merged := &Reservoir{}
+	nSampled := 0
+	for _, r := range reservoirs {
+		if r == nil || r.N == 0 {
+			continue
+		}
+		if merged.N == 0 || r.Max > merged.Max {
+			merged.Max = r.Max
+		}
+		merged.N += r.N
+		nSampled += len(r.Values)
+	}
+	rng := rand.New(rand.NewSource(0))
+	for _, r := range reservoirs {
+		if r == nil || r.N == 0 {
+			continue
+		}
+		if nSampled <= maxSamples {
+			merged.Values = append(merged.Values, r.Values...)
+			continue
+		}
+		n := int(float64(maxSamples) * float64(r.N) / float64(merged.N))
+		if n > len(r.Values) {
+			n = len(r.Values)
+		}
+		for _, i := range rng.Perm(len(r.Values))[:n] {
+			merged.Values = append(merged.Values, r.Values[i])
+		}
+	}
+	return merged
+}
+
+// addSample adds the value to the reservoir with the given key, creating it if needed.
+func addSample(reservoirs map[string]*Reservoir, key string, value int64) {
+	r, in := reservoirs[key]
+	if !in {
+		r = newReservoir()
+		reservoirs[key] = r
+	}
+	r.add(value)
+}
+
+// copyReservoirs returns copies of the given reservoirs.
+func copyReservoirs(reservoirs map[string]*Reservoir) map[string]*Reservoir {
+	copied := make(map[string]*Reservoir)
+	for k, r := range reservoirs {
+		copied[k] = r.copy()
+	}
+	return copied
+}
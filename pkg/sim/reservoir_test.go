@@ -0,0 +1,92 @@
+package sim
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservoir_add(t *testing.T) {
+	r := newReservoir()
+	n := 5 * maxSamples
+	for i := 0; i < n; i++ {
+		r.add(int64(i))
+	}
+	assert.Len(t, r.Values, maxSamples)
+	assert.Equal(t, uint64(n), r.N)
+	assert.Equal(t, int64(n-1), r.Max)
+
+	// sample is roughly uniform over all the added values rather than just the first
+	late := 0
+	for _, v := range r.Values {
+		if v >= int64(n/2) {
+			late++
+		}
+	}
+	assert.InDelta(t, maxSamples/2, late, maxSamples/20)
+}
+
+func TestReservoir_copy(t *testing.T) {
+	var nilReservoir *Reservoir
+	assert.Nil(t, nilReservoir.copy())
+	assert.Nil(t, nilReservoir.durations())
+	assert.Nil(t, nilReservoir.ints())
+
+	r := durationReservoir(2*time.Millisecond, time.Millisecond)
+	copied := r.copy()
+	r.add(int64(3 * time.Millisecond))
+	assert.Equal(t, []time.Duration{2 * time.Millisecond, time.Millisecond}, copied.durations())
+	assert.Equal(t, uint64(2), copied.N)
+	assert.Equal(t, int64(2*time.Millisecond), copied.Max)
+
+	// copies are sent to the coordinator
+	encoded, err := json.Marshal(copied)
+	assert.Nil(t, err)
+	decoded := &Reservoir{}
+	assert.Nil(t, json.Unmarshal(encoded, decoded))
+	assert.Equal(t, copied, decoded)
+}
+
+func TestMergeReservoirs(t *testing.T) {
+	// small samples are concatenated
+	merged := mergeReservoirs([]*Reservoir{
+		durationReservoir(time.Millisecond),
+		nil,
+		durationReservoir(3*time.Millisecond, 2*time.Millisecond),
+	})
+	assert.Equal(t, []time.Duration{time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond},
+		merged.durations())
+	assert.Equal(t, uint64(3), merged.N)
+	assert.Equal(t, int64(3*time.Millisecond), merged.Max)
+
+	// full samples contribute in proportion to the number of values they sampled
+	small, large := newReservoir(), newReservoir()
+	for i := 0; i < maxSamples; i++ {
+		small.add(0)
+	}
+	for i := 0; i < 3*maxSamples; i++ {
+		large.add(1)
+	}
+	merged = mergeReservoirs([]*Reservoir{small, large})
+	assert.Len(t, merged.Values, maxSamples)
+	assert.Equal(t, uint64(4*maxSamples), merged.N)
+	assert.Equal(t, int64(1), merged.Max)
+	nLarge := 0
+	for _, v := range merged.Values {
+		nLarge += int(v)
+	}
+	assert.Equal(t, 3*maxSamples/4, nLarge)
+
+	assert.Empty(t, mergeReservoirs(nil).Values)
+}
+
+// durationReservoir returns a reservoir with the given durations added.
+func durationReservoir(durations ...time.Duration) *Reservoir {
+	r := newReservoir()
+	for _, d := range durations {
+		r.add(int64(d))
+	}
+	return r
+}
@@ -0,0 +1,235 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// retryBackoffMultiplier is how much the backoff grows with each retry.
+	retryBackoffMultiplier = 2.0
+)
+
+// DefaultRetryableErrors are the default error classes retried by a retry policy that doesn't
+// list its own. They're the transient failures a real client would expect to clear up. Upload
+// and share policies without their own classes don't retry the sim's own timeouts, since the
+// abandoned attempt may still complete and a retry would store the document twice.
+var DefaultRetryableErrors = []string{
+	timeoutError,
+	deadlineExceededError,
+	connectionRefusedError,
+	unavailableError,
+	grpcErrorPrefix + "resource_exhausted",
+	grpcErrorPrefix + "aborted",
+}
+
+// retryPolicy determines whether and when a failed query is retried.
+type retryPolicy struct {
+	maxAttempts uint
+	initial     time.Duration
+	max         time.Duration
+	jitter      float64
+	retryable   map[string]struct{}
+	rng         *rand.Rand
+	mu          sync.Mutex
+}
+
+// newRetryPolicies parses retry policies of the form
+// "operation:maxAttempts:initialBackoff:maxBackoff[:class/class/...]", e.g.,
+// "download:5:100ms:10s:deadline_exceeded/not_found" for downloads to be retried up to 4 times
+// on deadline exceeded or not found errors, with backoff growing from 100ms to at most 10s.
+// Without classes, a policy retries the default retryable error classes. Operations without a
+// policy aren't retried.
+func newRetryPolicies(
+	specs []string, defaultRetryable []string, jitter float64, rng *rand.Rand,
+) (map[string]*retryPolicy, error) {
+	if jitter < 0 || jitter > 1 {
+		return nil, fmt.Errorf("retry jitter %v not in [0, 1]", jitter)
+	}
+	if len(defaultRetryable) == 0 {
+		defaultRetryable = DefaultRetryableErrors
+	}
+	policies := make(map[string]*retryPolicy)
+	for _, spec := range specs {
+		fields := strings.Split(spec, ":")
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("retry policy %q not of form "+
+				"operation:maxAttempts:initialBackoff:maxBackoff[:class/class/...]", spec)
+		}
+		op := fields[0]
+		if !isOperation(op) {
+			return nil, fmt.Errorf("retry policy %q has unknown operation %q", spec, op)
+		}
+		if _, in := policies[op]; in {
+			return nil, fmt.Errorf("retry policy %q repeats operation %q", spec, op)
+		}
+		maxAttempts, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil || maxAttempts == 0 {
+			return nil, fmt.Errorf("retry policy %q has invalid max attempts %q", spec,
+				fields[1])
+		}
+		initial, err := time.ParseDuration(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("retry policy %q: %s", spec, err)
+		}
+		max, err := time.ParseDuration(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("retry policy %q: %s", spec, err)
+		}
+		if initial <= 0 || max < initial {
+			return nil, fmt.Errorf("retry policy %q backoffs must satisfy 0 < initial <= max",
+				spec)
+		}
+		classes := defaultRetryable
+		if len(fields) == 5 {
+			classes = strings.Split(fields[4], "/")
+		} else if storesDocs(op) {
+			classes = withoutClass(classes, timeoutError)
+		}
+		retryable := make(map[string]struct{})
+		for _, class := range classes {
+			if class == "" {
+				return nil, fmt.Errorf("retry policy %q has empty error class", spec)
+			}
+			retryable[class] = struct{}{}
+		}
+		policies[op] = &retryPolicy{
+			maxAttempts: uint(maxAttempts),
+			initial:     initial,
+			max:         max,
+			jitter:      jitter,
+			retryable:   retryable,
+			rng:         rng,
+		}
+	}
+	return policies, nil
+}
+
+// storesDocs returns whether queries for the given operation store documents in the cluster.
+func storesDocs(op string) bool {
+	return op == uploadOp || op == largeUploadOp || op == shareOp
+}
+
+func withoutClass(classes []string, without string) []string {
+	kept := make([]string, 0, len(classes))
+	for _, class := range classes {
+		if class != without {
+			kept = append(kept, class)
+		}
+	}
+	return kept
+}
+
+func isOperation(op string) bool {
+	for _, known := range operations {
+		if op == known {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the given retry (starting at 1), which grows exponentially
+// from the initial backoff up to the max, less a random fraction (up to the jitter) of itself.
+func (p *retryPolicy) backoff(retry uint) time.Duration {
+	backoff := float64(p.initial) * math.Pow(retryBackoffMultiplier, float64(retry-1))
+	if backoff > float64(p.max) {
+		backoff = float64(p.max)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Duration(backoff * (1 - p.jitter*p.rng.Float64()))
+}
+
+// retry makes the given query, retrying it with backoff according to the policy while it fails
+// with retryable errors, until done is closed. It returns the number of attempts made and the
+// error from the last one. A nil policy makes a single attempt.
+func (p *retryPolicy) retry(done <-chan struct{}, query func() error) (uint, error) {
+	err := query()
+	attempts := uint(1)
+	if p == nil {
+		return attempts, err
+	}
+	for ; err != nil && attempts < p.maxAttempts; attempts++ {
+		if _, in := p.retryable[classifyError(err)]; !in {
+			break
+		}
+		select {
+		case <-done:
+			return attempts, err
+		case <-time.After(p.backoff(attempts)):
+		}
+		err = query()
+	}
+	return attempts, err
+}
+
+// retryMetrics records how many attempts queries took.
+type retryMetrics struct {
+	retries  *prometheus.CounterVec
+	firstTry *prometheus.CounterVec
+
+	firstTrySucceeded map[string]uint64
+	retryCounts       map[string]uint64
+	mu                sync.Mutex
+}
+
+func newRetryMetrics(registry *prometheus.Registry) *retryMetrics {
+	retries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_retry_count",
+			Help:      "number of query retries made by the sim, by operation",
+		},
+		[]string{operationLabel},
+	)
+	firstTry := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "query_first_try_success_count",
+			Help:      "number of queries that succeeded on their first attempt, by operation",
+		},
+		[]string{operationLabel},
+	)
+	registry.MustRegister(retries, firstTry)
+	return &retryMetrics{
+		retries:           retries,
+		firstTry:          firstTry,
+		firstTrySucceeded: make(map[string]uint64),
+		retryCounts:       make(map[string]uint64),
+	}
+}
+
+// observe records a query that took the given number of attempts and whether it succeeded.
+func (m *retryMetrics) observe(op string, attempts uint, succeeded bool) {
+	firstTry := attempts == 1 && succeeded
+	if firstTry {
+		m.firstTry.WithLabelValues(op).Inc()
+	}
+	if attempts > 1 {
+		m.retries.WithLabelValues(op).Add(float64(attempts - 1))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if firstTry {
+		m.firstTrySucceeded[op]++
+	}
+	m.retryCounts[op] += uint64(attempts - 1)
+}
+
+// counts returns the number of queries for the given operation that succeeded on their first
+// attempt and the number of retries.
+func (m *retryMetrics) counts(op string) (uint64, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.firstTrySucceeded[op], m.retryCounts[op]
+}
@@ -0,0 +1,154 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRetryPolicies_ok(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	policies, err := newRetryPolicies([]string{
+		"download:5:100ms:10s",
+		"upload:3:1s:2s:not_found/grpc_internal",
+		"share:3:1s:2s",
+	}, nil, 0.5, rng)
+	assert.Nil(t, err)
+	assert.Len(t, policies, 3)
+
+	down := policies[downloadOp]
+	assert.Equal(t, uint(5), down.maxAttempts)
+	assert.Equal(t, 100*time.Millisecond, down.initial)
+	assert.Equal(t, 10*time.Second, down.max)
+	assert.Len(t, down.retryable, len(DefaultRetryableErrors))
+
+	up := policies[uploadOp]
+	assert.Equal(t, map[string]struct{}{notFoundError: {}, "grpc_internal": {}}, up.retryable)
+
+	// uploads and shares don't retry the sim's own timeouts by default
+	share := policies[shareOp]
+	assert.Len(t, share.retryable, len(DefaultRetryableErrors)-1)
+	_, in := share.retryable[timeoutError]
+	assert.False(t, in)
+
+	_, in = policies[largeDownloadOp]
+	assert.False(t, in)
+}
+
+func TestNewRetryPolicies_err(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	cases := []struct {
+		specs  []string
+		jitter float64
+	}{
+		{[]string{"download:5:100ms:10s"}, -0.1},                    // negative jitter
+		{[]string{"download:5:100ms:10s"}, 1.1},                     // jitter > 1
+		{[]string{"download:5:100ms"}, 0.5},                         // too few fields
+		{[]string{"download:5:100ms:10s:quorum:other"}, 0.5},        // too many fields
+		{[]string{"delete:5:100ms:10s"}, 0.5},                       // unknown operation
+		{[]string{"download:5:100ms:10s", "download:2:1s:1s"}, 0.5}, // repeated operation
+		{[]string{"download:0:100ms:10s"}, 0.5},                     // zero attempts
+		{[]string{"download:five:100ms:10s"}, 0.5},                  // bad attempts
+		{[]string{"download:5:soon:10s"}, 0.5},                      // bad initial
+		{[]string{"download:5:100ms:later"}, 0.5},                   // bad max
+		{[]string{"download:5:0s:10s"}, 0.5},                        // zero initial
+		{[]string{"download:5:10s:1s"}, 0.5},                        // max < initial
+		{[]string{"download:5:100ms:10s:quorum/"}, 0.5},             // empty class
+	}
+	for i, c := range cases {
+		policies, err := newRetryPolicies(c.specs, nil, c.jitter, rng)
+		assert.NotNil(t, err, "case %d", i)
+		assert.Nil(t, policies, "case %d", i)
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := &retryPolicy{
+		initial: 100 * time.Millisecond,
+		max:     time.Second,
+		rng:     rand.New(rand.NewSource(0)),
+	}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+	assert.Equal(t, time.Second, p.backoff(5))
+
+	p.jitter = 0.5
+	for c := 0; c < 100; c++ {
+		backoff := p.backoff(2)
+		assert.True(t, backoff > 100*time.Millisecond)
+		assert.True(t, backoff <= 200*time.Millisecond)
+	}
+}
+
+func TestRetryPolicy_retry(t *testing.T) {
+	p := &retryPolicy{
+		maxAttempts: 3,
+		initial:     time.Millisecond,
+		max:         time.Millisecond,
+		retryable:   map[string]struct{}{deadlineExceededError: {}},
+		rng:         rand.New(rand.NewSource(0)),
+	}
+	done := make(chan struct{})
+	retryableErr := context.DeadlineExceeded
+	otherErr := errors.New("something else")
+
+	// succeeds after retries
+	n := 0
+	attempts, err := p.retry(done, func() error {
+		n++
+		if n < 2 {
+			return retryableErr
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), attempts)
+
+	// gives up after max attempts
+	attempts, err = p.retry(done, func() error { return retryableErr })
+	assert.Equal(t, retryableErr, err)
+	assert.Equal(t, uint(3), attempts)
+
+	// doesn't retry non-retryable errors
+	attempts, err = p.retry(done, func() error { return otherErr })
+	assert.Equal(t, otherErr, err)
+	assert.Equal(t, uint(1), attempts)
+
+	// doesn't retry once done
+	close(done)
+	attempts, err = p.retry(done, func() error { return retryableErr })
+	assert.Equal(t, retryableErr, err)
+	assert.Equal(t, uint(1), attempts)
+
+	// nil policy makes single attempt
+	var nilPolicy *retryPolicy
+	attempts, err = nilPolicy.retry(make(chan struct{}), func() error { return retryableErr })
+	assert.Equal(t, retryableErr, err)
+	assert.Equal(t, uint(1), attempts)
+}
+
+func TestRetryMetrics(t *testing.T) {
+	m := newRetryMetrics(prometheus.NewRegistry())
+	m.observe(downloadOp, 1, true)
+	m.observe(downloadOp, 1, false)
+	m.observe(downloadOp, 3, true)
+	m.observe(downloadOp, 2, false)
+	m.observe(uploadOp, 1, true)
+
+	firstTry, retries := m.counts(downloadOp)
+	assert.Equal(t, uint64(1), firstTry)
+	assert.Equal(t, uint64(3), retries)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.retries.WithLabelValues(downloadOp)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.firstTry.WithLabelValues(uploadOp)))
+
+	firstTry, retries = m.counts(shareOp)
+	assert.Equal(t, uint64(0), firstTry)
+	assert.Equal(t, uint64(0), retries)
+}
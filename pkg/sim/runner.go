@@ -2,13 +2,14 @@ package sim
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
-	_ "net/http/pprof" // pprof doc calls for blank import
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -20,14 +21,14 @@ import (
 	"github.com/drausin/libri/libri/common/id"
 	"github.com/drausin/libri/libri/common/logging"
 	"github.com/drausin/libri/libri/librarian/api"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	toUploadSlack    = 16
-	toDownloadSlack  = 16
-	contentMediaType = "application/x-gzip" // so we don't try to compress
+	toUploadSlack   = 16
+	toDownloadSlack = 16
 
 	// DefaultDuration is the default time for the experiment to run
 	DefaultDuration = 1 * time.Hour
@@ -45,6 +46,36 @@ const (
 	// DefaultContentSizeKBGammaRate is the gamma distribution rate parameter.
 	DefaultContentSizeKBGammaRate = 1.0 / float64(170) // 1 / scale
 
+	// DefaultContentSizeDist is the default content size distribution.
+	DefaultContentSizeDist = GammaContentSize
+
+	// DefaultContentSizeKBLogNormalMu is the default log-normal distribution mu (mean of the log)
+	// parameter for the content size (in KB); with the default sigma, the median is ~128 KB.
+	DefaultContentSizeKBLogNormalMu = 4.85
+
+	// DefaultContentSizeKBLogNormalSigma is the default log-normal distribution sigma (standard
+	// deviation of the log) parameter.
+	DefaultContentSizeKBLogNormalSigma = 1.0
+
+	// DefaultContentSizeKBParetoMin is the default Pareto distribution min content size (in KB).
+	DefaultContentSizeKBParetoMin = 32.0
+
+	// DefaultContentSizeKBParetoAlpha is the default Pareto distribution shape parameter.
+	DefaultContentSizeKBParetoAlpha = 1.5
+
+	// DefaultContentSizeKBFixed is the default fixed content size (in KB).
+	DefaultContentSizeKBFixed = 256.0
+
+	// DefaultLargeDocFrac is the default fraction of uploads that are large, multi-page
+	// documents.
+	DefaultLargeDocFrac = 0.0
+
+	// DefaultLargeDocSizeMBMin is the default min size (in MB) of large documents.
+	DefaultLargeDocSizeMBMin = 10.0
+
+	// DefaultLargeDocSizeMBMax is the default max size (in MB) of large documents.
+	DefaultLargeDocSizeMBMax = 1024.0
+
 	// DefaultSharesPerUpload is the default number of times each uploaded document is shared.
 	DefaultSharesPerUpload = uint(2)
 
@@ -62,143 +93,606 @@ const (
 	// DefaultNDownloaders is the default number of downloader workers to use.
 	DefaultNDownloaders = DefaultNUploaders * DefaultSharesPerUpload
 
+	// DefaultMaxUploaders is the default max number of uploader workers the pool can grow to.
+	DefaultMaxUploaders = 4 * DefaultNUploaders
+
+	// DefaultMaxDownloaders is the default max number of downloader workers the pool can grow to.
+	DefaultMaxDownloaders = 4 * DefaultNDownloaders
+
+	// DefaultOpenLoop is the default setting for whether to schedule events open-loop, i.e.,
+	// independently of how quickly earlier events complete, and measure latency from each event's
+	// intended start time.
+	DefaultOpenLoop = true
+
 	// DefaultProfile is the default setting for whether to enable the profiling endpoint.
 	DefaultProfile = false
 
+	// DefaultSeed is the default seed from which all the experiment's random streams are derived.
+	DefaultSeed = int64(0)
+
 	// DefaultLogLevel is the default log level.
 	DefaultLogLevel = "INFO"
 
-	localProfilerPort = 20300
+	// DefaultLoadProfile is the default load profile, which with the default step duration and
+	// levels gives a 30s warm-up before reaching the full upload rate.
+	DefaultLoadProfile = StepLoadProfile
+
+	// DefaultLoadRampStart is the default multiple of the upload rate a ramp starts from.
+	DefaultLoadRampStart = 0.1
+
+	// DefaultLoadRampEnd is the default multiple of the upload rate a ramp ends at.
+	DefaultLoadRampEnd = 1.0
+
+	// DefaultLoadRampDuration is the default time taken to ramp from the start to the end.
+	DefaultLoadRampDuration = 10 * time.Minute
+
+	// DefaultLoadStepDuration is the default duration of each step level.
+	DefaultLoadStepDuration = 15 * time.Second
+
+	// DefaultLoadSinePeriod is the default period of the sine load profile.
+	DefaultLoadSinePeriod = 24 * time.Hour
+
+	// DefaultLoadSineAmplitude is the default amplitude (as a multiple of the upload rate) of the
+	// sine load profile.
+	DefaultLoadSineAmplitude = 0.5
+
+	// DefaultLoadSpikeInterval is the default time between the starts of successive spikes.
+	DefaultLoadSpikeInterval = 10 * time.Minute
+
+	// DefaultLoadSpikeDuration is the default duration of each spike.
+	DefaultLoadSpikeDuration = 1 * time.Minute
+
+	// DefaultLoadSpikeScale is the default multiple of the upload rate during a spike.
+	DefaultLoadSpikeScale = 4.0
+
+	// DefaultSocialGraph is the default social graph, which shares with uniformly random authors.
+	DefaultSocialGraph = UniformSocialGraph
+
+	// DefaultSocialGraphMeanDegree is the default mean number of neighbors of each author.
+	DefaultSocialGraphMeanDegree = 8.0
+
+	// DefaultSocialGraphCommunities is the default number of communities in the communities
+	// social graph.
+	DefaultSocialGraphCommunities = uint(10)
+
+	// DefaultSocialGraphCrossFrac is the default fraction of each author's neighbors outside its
+	// community in the communities social graph.
+	DefaultSocialGraphCrossFrac = 0.1
+
+	// DefaultShareNeighborFrac is the default fraction of shares to one of the author's neighbors
+	// in the social graph rather than to a uniformly random author.
+	DefaultShareNeighborFrac = 0.9
+
+	// DefaultActivity is the default distribution of activity over authors.
+	DefaultActivity = UniformActivity
+
+	// DefaultActivityZipfExponent is the default exponent of the Zipf activity distribution.
+	DefaultActivityZipfExponent = 1.0
+
+	// DefaultActivityGammaShape is the default shape of the gamma activity distribution.
+	DefaultActivityGammaShape = 0.5
+
+	// DefaultReReadsPerDay is the default number of times per day each author re-reads a
+	// previously shared document; by default, shared documents are only read once.
+	DefaultReReadsPerDay = uint(0)
 
-	warmUpTime = 30 * time.Second
+	// DefaultPopularity is the default popularity model for choosing documents to re-read.
+	DefaultPopularity = ZipfPopularity
+
+	// DefaultPopularityZipfExponent is the default exponent of the document popularity
+	// distribution.
+	DefaultPopularityZipfExponent = 1.2
+
+	// DefaultPrefillDocs is the default number of documents to upload before the experiment is
+	// measured; by default, the experiment starts against an empty cluster.
+	DefaultPrefillDocs = uint(0)
+
+	// DefaultPrefillGB is the default size of content to upload before the experiment is measured.
+	DefaultPrefillGB = 0.0
+
+	// DefaultRetryJitter is the default max fraction of each retry backoff that is randomly
+	// removed, so retries of queries that failed together don't all happen together.
+	DefaultRetryJitter = 0.5
+
+	// DefaultDrainPeriod is the default max time after the runner is stopped for in-flight
+	// uploads to finish and pending downloads to run.
+	DefaultDrainPeriod = 30 * time.Second
+
+	// DefaultPinLibrarians is the default setting for whether to pin each author to a single
+	// librarian so queries can be attributed to the librarian they're sent to.
+	DefaultPinLibrarians = false
+
+	// DefaultPersistAuthors is the default setting for whether to save authors' keychains and
+	// received docs to the data dir and load them from earlier runs.
+	DefaultPersistAuthors = false
+
+	// DefaultAdminPort is the default port of the /metrics and /control admin endpoints.
+	DefaultAdminPort = 20300
 )
 
-// Parameters contains the parameters that define the experiment.
+var (
+	// DefaultLoadStepLevels are the default multiples of the upload rate for each step.
+	DefaultLoadStepLevels = []float64{0.25, 0.5, 1}
+
+	// DefaultContentSizeMixture is the default content size mixture, which is bimodal with most
+	// documents ~20 KB and the rest ~3 MB.
+	DefaultContentSizeMixture = []string{"0.8:lognormal:3:0.75", "0.2:lognormal:8:0.5"}
+)
+
+// Parameters contains the parameters that define the experiment. When Phases are given, the
+// experiment runs through each in turn, and Duration is ignored.
 type Parameters struct {
-	Duration                time.Duration
-	NAuthors                uint
-	DocsPerDay              uint
-	ContentSizeKBGammaShape float64
-	ContentSizeKBGammaRate  float64
-	SharesPerUpload         uint
-	DownloadWaitMin         time.Duration
-	DownloadWaitMax         time.Duration
-	NUploaders              uint
-	NDownloaders            uint
-	Profile                 bool
-	LogLevel                string
+	Duration                    time.Duration
+	NAuthors                    uint
+	DocsPerDay                  uint
+	ContentSizeKBGammaShape     float64
+	ContentSizeKBGammaRate      float64
+	ContentSizeDist             string
+	ContentSizeKBLogNormalMu    float64
+	ContentSizeKBLogNormalSigma float64
+	ContentSizeKBParetoMin      float64
+	ContentSizeKBParetoAlpha    float64
+	ContentSizeKBFixed          float64
+	ContentSizeMixture          []string
+	ContentSizeHistogram        string
+	ContentTypes                []string
+	LargeDocFrac                float64
+	LargeDocSizeMBMin           float64
+	LargeDocSizeMBMax           float64
+	SharesPerUpload             uint
+	DownloadWaitMin             time.Duration
+	DownloadWaitMax             time.Duration
+	NUploaders                  uint
+	NDownloaders                uint
+	MaxUploaders                uint
+	MaxDownloaders              uint
+	OpenLoop                    bool
+	LoadProfile                 string
+	LoadRampStart               float64
+	LoadRampEnd                 float64
+	LoadRampDuration            time.Duration
+	LoadStepDuration            time.Duration
+	LoadStepLevels              []float64
+	LoadSinePeriod              time.Duration
+	LoadSineAmplitude           float64
+	LoadSpikeInterval           time.Duration
+	LoadSpikeDuration           time.Duration
+	LoadSpikeScale              float64
+	SocialGraph                 string
+	SocialGraphMeanDegree       float64
+	SocialGraphCommunities      uint
+	SocialGraphCrossFrac        float64
+	ShareNeighborFrac           float64
+	Activity                    string
+	ActivityZipfExponent        float64
+	ActivityGammaShape          float64
+	ReReadsPerDay               uint
+	Popularity                  string
+	PopularityZipfExponent      float64
+	PrefillDocs                 uint
+	PrefillGB                   float64
+	QueryTimeouts               []string
+	DrainPeriod                 time.Duration
+	RetryPolicies               []string
+	RetryableErrors             []string
+	RetryJitter                 float64
+	PinLibrarians               bool
+	PersistAuthors              bool
+	Phases                      []*Phase
+	Seed                        int64
+	Profile                     bool
+	LogLevel                    string
 }
 
 type uploadEvent struct {
-	content   *bytes.Buffer
-	from      *author.Author
-	shareWith []*ecdsa.PublicKey
+	// seq is the index of the upload among the experiment's uploads, which identifies the docs
+	// it shares in a trace
+	seq int
+
+	scheduled   time.Time
+	size        int
+	contentSeed int64
+	contentType *contentType
+
+	// large documents are streamed during upload, so they have no content buffer, and their
+	// digest is only known after upload
+	large   bool
+	content *bytes.Buffer
+	digest  contentDigest
+
+	fromIdx       int
+	from          *author.Author
+	shareWithIdxs []int
+	shareWith     []*ecdsa.PublicKey
+	downloadWaits []time.Duration
+}
+
+// op returns the operation of the event's upload.
+func (e *uploadEvent) op() string {
+	if e.large {
+		return largeUploadOp
+	}
+	return uploadOp
+}
+
+// reader returns a new reader of the event's content, so each upload attempt reads all of it.
+func (e *uploadEvent) reader() io.Reader {
+	if e.large {
+		return newContentReader(e.size, e.contentSeed, e.contentType.generator)
+	}
+	return bytes.NewReader(e.content.Bytes())
 }
 
 type downloadEvent struct {
-	to     *author.Author
-	envKey id.ID
+	op        string
+	scheduled time.Time
+	to        *author.Author
+	envKey    id.ID
+	digest    contentDigest
+
+	// pages is the estimated number of pages of large documents, and zero otherwise
+	pages int
 }
 
 // Runner runs experiments.
 type Runner struct {
-	params         *Parameters
-	authors        directory
-	nextUploadWait durationSampler
-	downloadWait   durationSampler
-	upDocs         uploadEventSampler
-	querier        querier
-	toUpload       chan *uploadEvent
-	toDownload     chan *downloadEvent
-	done           chan struct{}
-	mu             sync.Mutex
-	logger         *zap.Logger
-}
-
-// NewRunner creates a new experiment Runner.
-func NewRunner(params *Parameters, dataDir string, librarianAddrs []*net.TCPAddr) *Runner {
+	params          *Parameters
+	authors         directory
+	phases          []*Phase
+	uploadWaitRNG   *rand.Rand
+	contentRNG      *rand.Rand
+	contentSizes    []contentSizeDist
+	contentTypes    *contentTypeSampler
+	contentMetrics  *contentMetrics
+	largeDocs       *largeDocSampler
+	largeDocMetrics *largeDocMetrics
+	librarians      *librarianMetrics
+	retries         map[string]*retryPolicy
+	timeouts        map[string]time.Duration
+	downloadWait    durationSampler
+	nextUploadWait  durationSampler
+	load            loadProfile
+	upDocs          uploadEventSampler
+	querier         querier
+	metrics         *queryMetrics
+	activePhase     *prometheus.GaugeVec
+	control         *controller
+	dashboard       *dashboard
+	adminAddr       string
+	trace           *TraceWriter
+	replaying       bool
+	activity        *authorActivity
+	catalogue       *catalogue
+	received        *receivedLog
+	prefillManifest *receivedLog
+	prefill         *PrefillSummary
+	nextReReadWait  durationSampler
+	toUpload        chan *uploadEvent
+	toSchedule      chan *downloadEvent
+	toDownload      chan *downloadEvent
+	uploaders       *workerPool
+	downloaders     *workerPool
+	done            chan struct{}
+	drained         chan struct{}
+	drainTime       time.Duration
+	startTime       time.Time
+	duration        time.Duration
+	endTime         time.Time
+	stopSignals     sync.Once
+	mu              sync.Mutex
+	logger          *zap.Logger
+}
+
+// NewRunner creates a new experiment Runner that logs to stderr.
+func NewRunner(
+	params *Parameters, dataDir string, librarianAddrs []*net.TCPAddr,
+) (*Runner, error) {
+	return NewRunnerWithLog(params, dataDir, librarianAddrs, os.Stderr)
+}
+
+// NewRunnerWithLog creates a new experiment Runner whose logs, including its authors', are
+// written to the given writer.
+func NewRunnerWithLog(
+	params *Parameters, dataDir string, librarianAddrs []*net.TCPAddr, logOut io.Writer,
+) (*Runner, error) {
+	logger := newDevLogger(getLogLevel(params.LogLevel), logOut)
+	load, err := newLoadProfile(params)
+	if err != nil {
+		return nil, err
+	}
+	phases, err := getPhases(params)
+	if err != nil {
+		return nil, err
+	}
 	downloadWait := &uniformDurationSampler{
 		min: params.DownloadWaitMin,
 		max: params.DownloadWaitMax,
-		rng: rand.New(rand.NewSource(0)),
-	}
-	authors := newDirectory(rand.New(rand.NewSource(0)), dataDir, librarianAddrs, params.NAuthors,
-		params.LogLevel)
-	docSizeSampler := newGammaContentSampler(
-		rand.New(rand.NewSource(0)),
-		params.ContentSizeKBGammaShape,
-		params.ContentSizeKBGammaRate,
-	)
-	upDocs := &uploadEventSamplerImpl{
-		authors:          authors,
-		nSharesPerUpload: params.SharesPerUpload,
-		content:          docSizeSampler,
+		rng: newStreamRNG(params.Seed, downloadWaitStream),
+	}
+	graph, err := newSocialGraph(params, newStreamRNG(params.Seed, socialGraphStream))
+	if err != nil {
+		return nil, err
+	}
+	activityWeights, err := newActivityWeights(params, newStreamRNG(params.Seed, activityStream))
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDirectory(newStreamRNG(params.Seed, directoryStream), dataDir, librarianAddrs,
+		params.NAuthors, params.LogLevel, logger, activityWeights, params.PersistAuthors,
+		params.PinLibrarians)
+	if err != nil {
+		return nil, err
+	}
+	var authors directory = d
+	if graph != nil {
+		authors = newSocialDirectory(d, graph, params.ShareNeighborFrac, activityWeights)
+	}
+	contentSizes, err := newPhaseContentSizeDists(phases)
+	if err != nil {
+		return nil, err
+	}
+	contentTypes, err := newContentTypeSampler(params.ContentTypes,
+		newStreamRNG(params.Seed, contentTypeStream))
+	if err != nil {
+		return nil, err
+	}
+	largeDocs, err := newLargeDocSampler(params, newStreamRNG(params.Seed, largeDocStream))
+	if err != nil {
+		return nil, err
+	}
+	retries, err := newRetryPolicies(params.RetryPolicies, params.RetryableErrors,
+		params.RetryJitter, newStreamRNG(params.Seed, retryStream))
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := newQueryTimeouts(params.QueryTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	uploadWaitRNG := newStreamRNG(params.Seed, uploadWaitStream)
+	contentRNG := newStreamRNG(params.Seed, contentStream)
+	nextUploadWait, upDocs := phaseSamplers(phases[0], params.NAuthors, authors, downloadWait,
+		contentSizes[0], contentTypes, largeDocs, uploadWaitRNG, contentRNG)
+
+	var docs *catalogue
+	var nextReReadWait durationSampler
+	if params.ReReadsPerDay > 0 {
+		rereadRNG := newStreamRNG(params.Seed, rereadStream)
+		if docs, err = newCatalogue(params, rereadRNG); err != nil {
+			return nil, err
+		}
+		nextReReadWait = newExponentialDurationSampler(rereadRNG,
+			eventWaitMS(params.NAuthors, params.ReReadsPerDay))
 	}
-	uploadsPerSecond := float64(params.NAuthors) * float64(params.DocsPerDay) / (24 * 3600)
-	uploadWaitMS := 1000 / uploadsPerSecond
 
-	return &Runner{
-		params:         params,
-		authors:        authors,
-		nextUploadWait: newExponentialDurationSampler(rand.New(rand.NewSource(0)), uploadWaitMS),
-		downloadWait:   downloadWait,
-		upDocs:         upDocs,
-		querier:        &querierImpl{},
-		toUpload:       make(chan *uploadEvent, toUploadSlack),
-		toDownload:     make(chan *downloadEvent, toDownloadSlack),
-		done:           make(chan struct{}),
-		logger:         newDevLogger(getLogLevel(params.LogLevel)),
+	var received *receivedLog
+	var prevReceived []*receivedDoc
+	if params.PersistAuthors {
+		if prevReceived, err = loadReceived(dataDir, params.NAuthors); err != nil {
+			return nil, err
+		}
+		if docs != nil {
+			for _, doc := range prevReceived {
+				catDoc, err := doc.catalogueDoc(authors)
+				if err != nil {
+					return nil, err
+				}
+				docs.add(catDoc)
+			}
+		}
+		if received, err = newReceivedLog(dataDir); err != nil {
+			return nil, err
+		}
+	}
+
+	metrics := newQueryMetrics()
+	if params.PersistAuthors {
+		logger.Info("loaded persisted authors",
+			zap.Int("n_loaded_authors", d.nLoaded),
+			zap.Int("n_received_docs", len(prevReceived)),
+		)
+	}
+	if graph != nil {
+		logger.Info("generated social graph",
+			zap.String("type", params.SocialGraph),
+			zap.Float64("mean_degree", graph.meanDegree()),
+			zap.Int("max_degree", graph.maxDegree()),
+		)
+	}
+	r := &Runner{
+		params:          params,
+		authors:         authors,
+		phases:          phases,
+		uploadWaitRNG:   uploadWaitRNG,
+		contentRNG:      contentRNG,
+		contentSizes:    contentSizes,
+		contentTypes:    contentTypes,
+		contentMetrics:  newContentMetrics(metrics.registry),
+		largeDocs:       largeDocs,
+		largeDocMetrics: newLargeDocMetrics(metrics.registry),
+		librarians:      newLibrarianMetrics(metrics.registry, authorLibrarians(d)),
+		retries:         retries,
+		timeouts:        timeouts,
+		downloadWait:    downloadWait,
+		nextUploadWait:  nextUploadWait,
+		load:            load,
+		upDocs:          upDocs,
+		querier:         &querierImpl{abandoned: newAbandonedCallMetrics(metrics.registry)},
+		metrics:         metrics,
+		activePhase:     newActivePhaseGauge(metrics.registry),
+		control:         newController(phases[0]),
+		activity:        newAuthorActivity(params.NAuthors),
+		catalogue:       docs,
+		received:        received,
+		nextReReadWait:  nextReReadWait,
+		toUpload:        make(chan *uploadEvent, toUploadSlack),
+		toSchedule:      make(chan *downloadEvent, toDownloadSlack),
+		toDownload:      make(chan *downloadEvent, toDownloadSlack),
+		done:            make(chan struct{}),
+		drained:         make(chan struct{}),
+		adminAddr:       fmt.Sprintf(":%d", DefaultAdminPort),
+		logger:          logger,
 	}
+	poolMetrics := newPoolMetrics(metrics.registry)
+	r.uploaders = newWorkerPool(uploadersPool, r.doUploads,
+		func() int { return len(r.toUpload) }, toUploadSlack,
+		params.NUploaders, params.MaxUploaders, poolMetrics, logger)
+	r.downloaders = newWorkerPool(downloadersPool, r.doDownloads,
+		func() int { return len(r.toDownload) }, toDownloadSlack,
+		params.NDownloaders, params.MaxDownloaders, poolMetrics, logger)
+	return r, nil
 }
 
-// Run begins the experiment.
+// RecordTrace sets the writer to record every generated upload event to during Run.
+func (r *Runner) RecordTrace(trace *TraceWriter) {
+	r.trace = trace
+}
+
+// SetAdminAddr sets the address (host:port) to serve the admin endpoints on, so several runners
+// can share a host. Port 0 picks any free port.
+func (r *Runner) SetAdminAddr(addr string) {
+	r.adminAddr = addr
+}
+
+// Run begins the experiment, first prefilling the cluster if PrefillDocs or PrefillGB are set.
 func (r *Runner) Run() {
-	stopSignals := make(chan os.Signal, 3)
-	signal.Notify(stopSignals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	if r.params.PrefillDocs > 0 || r.params.PrefillGB > 0 {
+		r.Prefill()
+	}
+	r.run(r.generateUploads, r.generateReReads, totalDuration(r.phases))
+}
 
-	if r.params.Profile {
-		go func() {
-			profilerAddr := fmt.Sprintf(":%d", localProfilerPort)
-			if err := http.ListenAndServe(profilerAddr, nil); err != nil {
-				r.logger.Error("error serving profiler", zap.Error(err))
-				r.stop()
-			}
+// Replay re-drives the upload, re-read, and phase events in the given trace, ending once they
+// have all been sent.
+func (r *Runner) Replay(trace *TraceReader) {
+	rereads := make(chan *traceRecord, toDownloadSlack)
+	uploadsDone := make(chan struct{})
+	r.replaying = true
+	r.run(
+		func() { r.replayUploads(trace, rereads, uploadsDone) },
+		func() { r.replayReReads(rereads, uploadsDone) },
+		0,
+	)
+}
+
+// run runs the experiment with the given upload and re-read event sources for the given
+// duration, or until the upload source stops the runner if the duration is zero.
+func (r *Runner) run(generateUploads, generateReReads func(), duration time.Duration) {
+	r.startTime = time.Now()
+	r.duration = duration
+	r.watchStopSignals()
+	if r.dashboard != nil {
+		stopDashboard, dashboardFinished := make(chan struct{}), make(chan struct{})
+		go r.dashboard.start(stopDashboard, dashboardFinished)
+		defer func() {
+			close(stopDashboard)
+			<-dashboardFinished
 		}()
 	}
 
+	adminServer := r.newAdminServer()
 	go func() {
-		<-stopSignals
-		r.logger.Info("received external stop signal")
-		r.stop()
+		if err := adminServer.ListenAndServe(); err != http.ErrServerClosed {
+			r.logger.Error("error serving admin endpoints", zap.Error(err))
+			r.stop()
+		}
 	}()
+
+	if duration > 0 {
+		go func() {
+			time.Sleep(duration)
+			r.logger.Info("finished experiment duration")
+			r.stop()
+		}()
+	}
+
+	// execute upload events & generate download events
+	r.uploaders.start(r.done)
+
+	// execute download events once they're due
+	go r.scheduleDownloads()
+	r.downloaders.start(r.done)
+
+	// generate upload events
+	go generateUploads()
+
+	// generate re-read events
+	rereadsDone := make(chan struct{})
 	go func() {
-		time.Sleep(r.params.Duration)
-		r.logger.Info("finished experiment duration")
-		r.stop()
+		defer close(rereadsDone)
+		generateReReads()
 	}()
 
-	// generate upload events
-	go r.generateUploads()
+	// exit cleanly
+	<-r.done
+	r.drain(rereadsDone)
+	r.endTime = time.Now()
+	if r.received != nil {
+		if err := r.received.Close(); err != nil {
+			r.logger.Error("error closing received docs file", zap.Error(err))
+		}
+	}
+	if err := adminServer.Close(); err != nil {
+		r.logger.Error("error closing admin server", zap.Error(err))
+	}
+}
 
-	// execute upload events & generate download events
-	upWG := new(sync.WaitGroup)
-	for c := uint(0); c < r.params.NUploaders; c++ {
-		upWG.Add(1)
-		go r.doUploads(upWG)
+// Summary returns a summary of the completed run.
+func (r *Runner) Summary() *Summary {
+	s := newSummary(r.params, r.startTime, r.endTime, r.metrics, r.uploaders, r.downloaders)
+	s.Authors = r.activity.summary()
+	s.Content = r.contentMetrics.summary()
+	s.LargeDocs = r.largeDocMetrics.summary()
+	s.Librarians = r.librarians.summary()
+	s.Prefill = r.prefill
+	s.DrainSeconds = r.drainTime.Seconds()
+	for op, timeout := range r.timeouts {
+		s.Operations[op].TimeoutMS = timeout.Seconds() * 1e3
 	}
+	return s
+}
 
-	// execute download events
-	downWG := new(sync.WaitGroup)
-	for c := uint(0); c < r.params.NUploaders; c++ {
-		downWG.Add(1)
-		go r.doDownloads(downWG)
+// newAdminServer creates the server for the /metrics and /control endpoints and, if profiling is
+// enabled, the /debug/pprof endpoints.
+func (r *Runner) newAdminServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.metrics.handler())
+	mux.HandleFunc(controlPath, r.controlHandler)
+	mux.HandleFunc(controlPath+"/", r.controlHandler)
+	if r.params.Profile {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return &http.Server{
+		Addr:    r.adminAddr,
+		Handler: mux,
 	}
+}
 
-	// exit cleanly
-	<-r.done
-	upWG.Wait()
-	close(r.toDownload)
-	downWG.Wait()
+// watchStopSignals stops the runner on receiving an external stop signal.
+func (r *Runner) watchStopSignals() {
+	r.stopSignals.Do(func() {
+		stopSignals := make(chan os.Signal, 3)
+		signal.Notify(stopSignals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+		go func() {
+			<-stopSignals
+			r.logger.Info("received external stop signal")
+			r.stop()
+		}()
+	})
+}
+
+// stopped returns whether the runner has been stopped.
+func (r *Runner) stopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (r *Runner) stop() {
@@ -214,99 +708,473 @@ func (r *Runner) stop() {
 func (r *Runner) generateUploads() {
 	done := false
 	start := time.Now()
+	next := start
+	phaseIdx, phaseEnd := 0, start.Add(r.phases[0].Duration)
+	controlVersion := uint64(0)
+	seq := 0
+	r.startPhase(0)
 	for !done {
 		select {
 		case <-r.done:
 			done = true
 		default:
-			wait := r.nextUploadWait.sample()
-			if time.Now().Before(start.Add(warmUpTime / 2)) {
-				wait *= 4
-			} else if time.Now().Before(start.Add(warmUpTime)) {
-				wait *= 2
+			if r.control.waitWhilePaused(r.done) {
+				// don't make up for uploads that would have happened while paused
+				next = time.Now()
+				continue
+			}
+			for !next.Before(phaseEnd) && phaseIdx < len(r.phases)-1 {
+				phaseIdx++
+				if r.trace != nil {
+					if err := r.trace.writePhase(r.startTime, phaseEnd, phaseIdx); err != nil {
+						r.logger.Error("error writing trace record", zap.Error(err))
+					}
+				}
+				phaseEnd = phaseEnd.Add(r.phases[phaseIdx].Duration)
+				r.startPhase(phaseIdx)
 			}
+			changed := r.control.changes()
+			if v := r.control.currentVersion(); v != controlVersion {
+				controlVersion = v
+				r.resample(phaseIdx)
+			}
+			wait := scaleWait(r.load, r.nextUploadWait.sample(), next.Sub(start))
 			r.logger.Debug("waiting for next upload", zap.Duration("wait_time", wait))
-			time.Sleep(wait)
-			r.toUpload <- r.upDocs.sample()
+			var intended time.Time
+			if r.params.OpenLoop {
+				// schedule off the previous intended time rather than now, so time spent blocked
+				// below doesn't slow the arrival process
+				intended = next.Add(wait)
+			} else {
+				intended = time.Now().Add(wait)
+			}
+			if !r.sleepUntil(intended, changed) {
+				// stopped or changed through the control endpoint, so re-sample the wait
+				next = time.Now()
+				continue
+			}
+			next = intended
+			if !r.params.OpenLoop {
+				next = time.Now()
+			}
+			event := r.upDocs.sample()
+			event.seq, event.scheduled = seq, next
+			seq++
+			if r.trace != nil {
+				if err := r.trace.writeUpload(r.startTime, event); err != nil {
+					r.logger.Error("error writing trace record", zap.Error(err))
+				}
+			}
+			r.sendUpload(event)
 		}
 	}
 	close(r.toUpload)
 }
 
-func (r *Runner) doUploads(wg *sync.WaitGroup) {
-	defer wg.Done()
+// replayUploads replays the trace's upload and phase events, sending its re-read events to the
+// given channel, and, once it reaches the end of the trace, stops the runner after the queued
+// uploads finish. It closes uploadsDone once no more uploads will finish.
+func (r *Runner) replayUploads(
+	trace *TraceReader, rereads chan<- *traceRecord, uploadsDone chan<- struct{},
+) {
+	finished := r.sendReplayedEvents(trace, rereads)
+	close(rereads)
+	close(r.toUpload)
+
+	// let the queued uploads finish rather than abandoning them
+	r.uploaders.wait()
+	close(uploadsDone)
+	if finished {
+		r.logger.Info("finished replaying trace")
+		r.stop()
+	}
+}
+
+// sendReplayedEvents sends the trace's events at their recorded times, returning whether it
+// reached the end of the trace.
+func (r *Runner) sendReplayedEvents(trace *TraceReader, rereads chan<- *traceRecord) bool {
+	r.startPhase(0)
+	seq := 0
+	start := r.startTime
+	for {
+		rec, err := trace.next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			r.logger.Error("error reading trace record", zap.Error(err))
+			r.stop()
+			return false
+		}
+		var ok bool
+		if start, ok = r.sleepUntilReplayed(start, rec.Offset); !ok {
+			return false
+		}
+		switch rec.Kind {
+		case phaseRecord:
+			r.startPhase(rec.Phase)
+		case reReadRecord:
+			rereads <- rec
+		default:
+			r.sendUpload(rec.uploadEvent(start, seq, r.authors))
+			seq++
+		}
+	}
+}
+
+// sleepUntilReplayed sleeps until the given offset from the given start, returning the start
+// shifted by any time spent paused so the rest of the trace keeps its recorded spacing, and
+// false if the runner is stopped first.
+func (r *Runner) sleepUntilReplayed(start time.Time, offset time.Duration) (time.Time, bool) {
+	for {
+		changed := r.control.changes()
+		pausedAt := time.Now()
+		if r.control.waitWhilePaused(r.done) {
+			start = start.Add(time.Since(pausedAt))
+		}
+		if r.sleepUntil(start.Add(offset), changed) {
+			return start, true
+		}
+		if r.stopped() {
+			return start, false
+		}
+	}
+}
+
+// replayReReads sends the downloads of the re-read events from the given channel, waiting for
+// each document to be shared, which may take longer than when recorded. Re-reads of documents
+// that are never shared, e.g., because the share failed, are abandoned.
+func (r *Runner) replayReReads(rereads <-chan *traceRecord, uploadsDone <-chan struct{}) {
+	for rec := range rereads {
+		var doc *catalogueDoc
+		if r.catalogue != nil {
+			doc = r.catalogue.await(*rec.Doc, uploadsDone)
+		}
+		if doc == nil {
+			r.logger.Info("abandoning re-read of document not shared in replay")
+			r.metrics.observeAbandoned(rereadOp)
+			continue
+		}
+		select {
+		case <-r.drained:
+			r.metrics.observeAbandoned(rereadOp)
+		case r.toSchedule <- &downloadEvent{
+			op:        rereadOp,
+			scheduled: r.startTime.Add(rec.Offset),
+			to:        doc.to,
+			envKey:    doc.envKey,
+			digest:    doc.digest,
+		}:
+		}
+	}
+}
+
+// generateReReads generates downloads of previously shared documents, chosen by popularity, until
+// the runner is stopped.
+func (r *Runner) generateReReads() {
+	if r.catalogue == nil {
+		return
+	}
+	start := time.Now()
+	next := start
+	for {
+		if r.control.waitWhilePaused(r.done) {
+			next = time.Now()
+		}
+		wait := scaleWait(r.load, r.nextReReadWait.sample(), next.Sub(start))
+		if r.params.OpenLoop {
+			next = next.Add(wait)
+		} else {
+			next = time.Now().Add(wait)
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		doc := r.catalogue.sample()
+		if doc == nil {
+			// nothing shared yet
+			continue
+		}
+		select {
+		case <-r.done:
+			r.metrics.observeAbandoned(rereadOp)
+			return
+		case r.toSchedule <- &downloadEvent{
+			op:        rereadOp,
+			scheduled: next,
+			to:        doc.to,
+			envKey:    doc.envKey,
+			digest:    doc.digest,
+		}:
+		}
+		if r.trace != nil {
+			if err := r.trace.writeReRead(r.startTime, next, doc.ref); err != nil {
+				r.logger.Error("error writing trace record", zap.Error(err))
+			}
+		}
+	}
+}
+
+// sendUpload sends the event to the uploaders, abandoning it if the runner is stopped before
+// there's room in the queue.
+func (r *Runner) sendUpload(event *uploadEvent) {
+	if r.stopped() {
+		r.metrics.observeAbandoned(event.op())
+		return
+	}
+	sendStart := time.Now()
+	select {
+	case r.toUpload <- event:
+	case <-r.done:
+		r.metrics.observeAbandoned(event.op())
+	}
+	r.uploaders.addBlocked(time.Since(sendStart))
+}
+
+// startPhase switches the upload samplers to those of the phase with the given index.
+func (r *Runner) startPhase(i int) {
+	phase := r.phases[i]
+	if i > 0 {
+		r.activePhase.WithLabelValues(r.phases[i-1].Name).Set(0)
+		r.resample(i)
+	}
+	r.control.startPhase(phase)
+	r.activePhase.WithLabelValues(phase.Name).Set(1)
+	r.logger.Info("starting phase",
+		zap.String("phase", phase.Name),
+		zap.Duration("duration", phase.Duration),
+		zap.Uint("docs_per_day", phase.DocsPerDay),
+		zap.Uint("shares_per_upload", phase.SharesPerUpload),
+	)
+}
+
+// doUploads executes upload events and sends the download events of their shares until the
+// uploads queue is closed. Once the runner is stopped, queued uploads are abandoned.
+func (r *Runner) doUploads() {
 	for uploadEvent := range r.toUpload {
-		env, err := r.querier.upload(uploadEvent.from, uploadEvent.content)
+		op, downOp := uploadEvent.op(), downloadOp
+		if uploadEvent.large {
+			downOp = largeDownloadOp
+		}
+		if r.stopped() {
+			r.metrics.observeAbandoned(op)
+			continue
+		}
+		r.activity.record(uploadEvent)
+		start := time.Now()
+		r.metrics.observeLag(op, start.Sub(uploadEvent.scheduled))
+		var env *api.Envelope
+		var observed *contentObserver
+		attempts, err := r.retries[op].retry(r.drained, func() error {
+			// each attempt observes its content as it's uploaded with its own observer, since
+			// an abandoned attempt may still be reading its content
+			var err error
+			mediaType := uploadEvent.contentType.mediaType
+			observed = newContentObserver(compressible(mediaType))
+			content := io.TeeReader(uploadEvent.reader(), observed)
+			env, err = r.upload(op, uploadEvent.from, content, mediaType)
+			return err
+		})
+		latency := r.latency(uploadEvent.scheduled, start)
+		errClass := r.metrics.observe(op, latency, err)
+		r.metrics.retries.observe(op, attempts, err == nil)
+		r.librarians.observe(uploadEvent.from, op, errOutcome(err), errClass, latency)
 		if err != nil {
-			r.logger.Info("upload errored", zap.Error(err))
+			r.logger.Info("upload errored", zap.String("class", errClass), zap.Error(err))
 			continue
 		}
-		for _, withPub := range uploadEvent.shareWith {
-			shareEnvKey, err := r.querier.share(uploadEvent.from, env, withPub)
+		compressed := observed.compressedSize()
+		r.contentMetrics.observe(observed.size, compressed)
+		pages := 0
+		if uploadEvent.large {
+			uploadEvent.digest = observed.digest()
+			pages = estimatePages(compressed)
+			r.largeDocMetrics.observe(op, pages, latency)
+		}
+		for i, withPub := range uploadEvent.shareWith {
+			start := time.Now()
+			var shareEnvKey id.ID
+			attempts, err := r.retries[shareOp].retry(r.drained, func() error {
+				var err error
+				shareEnvKey, err = r.share(uploadEvent.from, env, withPub)
+				return err
+			})
+			shareLatency := time.Since(start)
+			errClass := r.metrics.observe(shareOp, shareLatency, err)
+			r.metrics.retries.observe(shareOp, attempts, err == nil)
+			r.librarians.observe(uploadEvent.from, shareOp, errOutcome(err), errClass, shareLatency)
 			if err != nil {
-				r.logger.Info("share errored", zap.Error(err))
+				r.logger.Info("share errored", zap.String("class", errClass), zap.Error(err))
 				continue
 			}
-			r.toDownload <- &downloadEvent{
-				to:     r.authors.get(withPub),
-				envKey: shareEnvKey,
+			to := r.authors.get(withPub)
+			if r.catalogue != nil {
+				r.catalogue.add(&catalogueDoc{
+					ref:    catalogueRef{Upload: uploadEvent.seq, Share: i},
+					to:     to,
+					envKey: shareEnvKey,
+					digest: uploadEvent.digest,
+				})
+			}
+			if r.received != nil {
+				doc := newReceivedDoc(uploadEvent.shareWithIdxs[i], shareEnvKey,
+					uploadEvent.digest)
+				if err := r.received.record(doc); err != nil {
+					r.logger.Error("error recording received doc", zap.Error(err))
+				}
+			}
+			select {
+			case r.toSchedule <- &downloadEvent{
+				op:        downOp,
+				scheduled: time.Now().Add(uploadEvent.downloadWaits[i]),
+				to:        to,
+				envKey:    shareEnvKey,
+				digest:    uploadEvent.digest,
+				pages:     pages,
+			}:
+			case <-r.drained:
+				r.metrics.observeAbandoned(downOp)
 			}
-		}
-		select {
-		case <-r.done:
-			return
-		default:
 		}
 	}
 }
 
-func (r *Runner) doDownloads(wg *sync.WaitGroup) {
-	defer wg.Done()
+// doDownloads executes due download events from the downloads queue until it's closed. Once the
+// drain period ends, queued downloads are abandoned.
+func (r *Runner) doDownloads() {
 	for downEvent := range r.toDownload {
-		wait := r.downloadWait.sample()
-		r.logger.Debug("waiting to download", zap.Duration("wait_time", wait))
-		time.Sleep(wait)
-		downloaded := new(bytes.Buffer)
+		if r.drainEnded() {
+			r.metrics.observeAbandoned(downEvent.op)
+			continue
+		}
 		r.logger.Debug("downloading",
+			zap.String("operation", downEvent.op),
 			zap.String("author_id", downEvent.to.ClientID.ID().String()),
 		)
-		if err := r.querier.download(downEvent.to, downloaded, downEvent.envKey); err != nil {
-			r.logger.Info("download errored", zap.Error(err))
+		start := time.Now()
+		r.metrics.observeLag(downEvent.op, start.Sub(downEvent.scheduled))
+		var downloaded *contentObserver
+		attempts, err := r.retries[downEvent.op].retry(r.drained, func() error {
+			downloaded = newContentObserver(false)
+			return r.download(downEvent.op, downEvent.to, downloaded, downEvent.envKey)
+		})
+		latency := r.latency(downEvent.scheduled, start)
+		if err != nil {
+			errClass := r.metrics.observe(downEvent.op, latency, err)
+			r.metrics.retries.observe(downEvent.op, attempts, false)
+			r.librarians.observe(downEvent.to, downEvent.op, errOutcome(err), errClass, latency)
+			r.logger.Info("download errored", zap.String("class", errClass), zap.Error(err))
 			continue
 		}
-		select {
-		case <-r.done:
-			return
-		default:
+		outcome := downEvent.digest.check(downloaded.digest())
+		r.metrics.observeOutcome(downEvent.op, outcome, latency)
+		r.metrics.retries.observe(downEvent.op, attempts, outcome == successOutcome)
+		r.librarians.observe(downEvent.to, downEvent.op, outcome, "", latency)
+		if outcome == successOutcome && downEvent.pages > 0 {
+			r.largeDocMetrics.observe(downEvent.op, downEvent.pages, latency)
 		}
+		if outcome != successOutcome {
+			r.logger.Info("downloaded content does not match upload",
+				zap.String("outcome", outcome),
+				zap.String("env_key", downEvent.envKey.String()),
+				zap.Int("expected_size", downEvent.digest.size),
+				zap.Int("downloaded_size", downloaded.size),
+			)
+		}
+	}
+}
+
+// latency returns the latency of a query that was scheduled to start at the given time but
+// actually started at start. When running open-loop, latency is measured from the scheduled
+// time so that delays from the sim falling behind schedule aren't omitted.
+func (r *Runner) latency(scheduled, start time.Time) time.Duration {
+	if r.params.OpenLoop {
+		return time.Since(scheduled)
 	}
+	return time.Since(start)
 }
 
-// thin wrapper around author functions so they're easy to mock
+// thin wrapper around author functions so they're easy to mock; each query returns the context's
+// error once it's done
 type querier interface {
-	upload(author *author.Author, content io.Reader) (*api.Envelope, error)
-	download(author *author.Author, content io.Writer, envKey id.ID) error
-	share(author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey) (id.ID, error)
+	upload(
+		ctx context.Context, author *author.Author, content io.Reader, mediaType string,
+	) (*api.Envelope, error)
+	download(ctx context.Context, author *author.Author, content io.Writer, envKey id.ID) error
+	share(
+		ctx context.Context, author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
+	) (id.ID, error)
 }
 
-type querierImpl struct{}
+// querierImpl makes queries with the author library, whose methods don't take a context. Each
+// call runs in its own goroutine so a query returns as soon as its context is done, freeing the
+// worker making it, while the abandoned call keeps running until the library returns.
+type querierImpl struct {
+	abandoned *abandonedCallMetrics
+}
 
-func (q *querierImpl) upload(author *author.Author, content io.Reader) (*api.Envelope, error) {
-	envDoc, _, err := author.Upload(content, contentMediaType)
+func (q *querierImpl) upload(
+	ctx context.Context, author *author.Author, content io.Reader, mediaType string,
+) (*api.Envelope, error) {
+	var envDoc *api.Document
+	var err error
+	if ctxErr := q.await(ctx, uploadOp, func() {
+		envDoc, _, err = author.Upload(content, mediaType)
+	}); ctxErr != nil {
+		return nil, ctxErr
+	}
 	return envDoc.GetEnvelope(), err
 }
 
-func (q *querierImpl) download(author *author.Author, content io.Writer, envKey id.ID) error {
-	return author.Download(content, envKey)
+func (q *querierImpl) download(
+	ctx context.Context, author *author.Author, content io.Writer, envKey id.ID,
+) error {
+	var err error
+	if ctxErr := q.await(ctx, downloadOp, func() {
+		err = author.Download(content, envKey)
+	}); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
 
 func (q *querierImpl) share(
-	author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
+	ctx context.Context, author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
 ) (id.ID, error) {
-	_, shareEnvKey, err := author.ShareEnvelope(env, readerPub)
+	var shareEnvKey id.ID
+	var err error
+	if ctxErr := q.await(ctx, shareOp, func() {
+		_, shareEnvKey, err = author.ShareEnvelope(env, readerPub)
+	}); ctxErr != nil {
+		return nil, ctxErr
+	}
 	return shareEnvKey, err
 }
 
+// await runs the given author library call in its own goroutine and waits for it to return or
+// the context to be done, returning the context's error in the latter case. Abandoned calls are
+// counted until they return.
+func (q *querierImpl) await(ctx context.Context, call string, f func()) error {
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		f()
+	}()
+	select {
+	case <-returned:
+		return nil
+	case <-ctx.Done():
+		q.abandoned.start(call)
+		go func() {
+			<-returned
+			q.abandoned.finish(call)
+		}()
+		return ctx.Err()
+	}
+}
+
 func pubKeyHex(pubKey *ecdsa.PublicKey) string {
 	return id.Hex(pubKey.X.Bytes())
 }
@@ -318,14 +1186,12 @@ func getLogLevel(logLevelStr string) zapcore.Level {
 	return logLevel
 }
 
-func newDevLogger(logLevel zapcore.Level) *zap.Logger {
-	config := zap.NewDevelopmentConfig()
-	config.DisableCaller = true
-	config.Level.SetLevel(logLevel)
-
-	logger, err := config.Build()
-	maybePanic(err)
-	return logger
+// newDevLogger returns a development logger, without callers, that writes to the given writer.
+func newDevLogger(logLevel zapcore.Level, out io.Writer) *zap.Logger {
+	sink := zapcore.Lock(zapcore.AddSync(out))
+	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, sink, logLevel), zap.Development(),
+		zap.AddStacktrace(zapcore.WarnLevel), zap.ErrorOutput(sink))
 }
 
 func maybePanic(err error) {
@@ -334,6 +1200,19 @@ func maybePanic(err error) {
 	}
 }
 
+// authorLibrarians returns the librarian each of the directory's authors is pinned to, or nil if
+// they aren't.
+func authorLibrarians(d *directoryImpl) map[*author.Author]string {
+	if d.librarians == nil {
+		return nil
+	}
+	librarians := make(map[*author.Author]string)
+	for i, a := range d.authors {
+		librarians[a] = d.librarians[i]
+	}
+	return librarians
+}
+
 func newAuthorConfigs(
 	dataDir string, librarianAddrs []*net.TCPAddr, nAuthors uint, logLevelStr string,
 ) []*author.Config {
@@ -1,6 +1,8 @@
 package sim
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"fmt"
 	"io"
@@ -16,63 +18,85 @@ import (
 	"github.com/drausin/libri/libri/author"
 	"github.com/drausin/libri/libri/common/id"
 	"github.com/drausin/libri/libri/librarian/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRunner_RunStop(t *testing.T) {
-	rng := rand.New(rand.NewSource(0))
 	params := newDefaultParameters()
 	params.Duration = 500 * time.Millisecond
 	params.NAuthors = 5
 	params.DocsPerDay = 100000 // has to be ridiculously large to get any queries in 1s
 	params.Profile = true
 
-	dataDir, err := ioutil.TempDir("", "sim-data-dir")
-	assert.Nil(t, err)
-	defer os.RemoveAll(dataDir)
-	librarianAddrs := []*net.TCPAddr{{IP: net.ParseIP("192.168.1.1"), Port: 20100}}
-	r := NewRunner(params, dataDir, librarianAddrs)
-	r.querier = &fixedQuerier{
-		uploaded: make(map[string]io.Reader),
-		rng:      rng,
-	}
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+
+	go func() {
+		// admin endpoints are only up while the runner is running
+		time.Sleep(params.Duration / 2)
+		for _, endpoint := range []string{"metrics", "debug/pprof"} {
+			addr := fmt.Sprintf("http://localhost:%d/%s", DefaultAdminPort, endpoint)
+			resp, err := http.Get(addr)
+			assert.Nil(t, err)
+			assert.Equal(t, "200 OK", resp.Status)
+		}
+	}()
 
 	r.Run()
 
-	profilerAddr := fmt.Sprintf("http://localhost:%d/debug/pprof", localProfilerPort)
-	resp, err := http.Get(profilerAddr)
-	assert.Nil(t, err)
-	assert.Equal(t, "200 OK", resp.Status)
+	nUploads := testutil.ToFloat64(r.metrics.counts.WithLabelValues(uploadOp, successOutcome))
+	assert.True(t, nUploads > 0)
+
+	summary := r.Summary()
+	assert.Equal(t, uint64(nUploads), summary.Operations[uploadOp].Succeeded)
+	assert.Zero(t, summary.Operations[downloadOp].Corrupt)
+	assert.Zero(t, summary.Operations[downloadOp].Truncated)
+	assert.Zero(t, summary.Operations[downloadOp].Missing)
+	assert.True(t, summary.EndTime.After(summary.StartTime))
+	assert.Len(t, summary.Authors.Uploads, int(params.NAuthors))
+	nAuthorUploads := uint64(0)
+	for _, n := range summary.Authors.Uploads {
+		nAuthorUploads += n
+	}
+	assert.Equal(t, summary.Operations[uploadOp].Attempted, nAuthorUploads)
+	assert.True(t, summary.Content.UploadedBytes > 0)
+	assert.Equal(t, summary.Content.UploadedBytes, summary.Content.CompressedBytes)
+	assert.Equal(t, 1.0, summary.Content.CompressionRatio)
 }
 
 type fixedQuerier struct {
-	uploaded map[string]io.Reader
+	uploaded map[string][]byte
 	mu       sync.Mutex
 	rng      *rand.Rand
 }
 
-func (f *fixedQuerier) upload(author *author.Author, content io.Reader) (*api.Envelope, error) {
+func (f *fixedQuerier) upload(
+	ctx context.Context, author *author.Author, content io.Reader, mediaType string,
+) (*api.Envelope, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	env := api.NewTestEnvelope(f.rng)
 	envKey, err := api.GetKey(env)
 	maybePanic(err)
-	f.uploaded[envKey.String()] = content
-	return env, nil
+	f.uploaded[envKey.String()], err = ioutil.ReadAll(content)
+	return env, err
 }
 
-func (f *fixedQuerier) download(author *author.Author, content io.Writer, envKey id.ID) error {
+func (f *fixedQuerier) download(
+	ctx context.Context, author *author.Author, content io.Writer, envKey id.ID,
+) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if upContent, in := f.uploaded[envKey.String()]; in {
-		_, err := io.Copy(content, upContent)
+		_, err := content.Write(upContent)
 		return err
 	}
 	return nil
 }
 
 func (f *fixedQuerier) share(
-	author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
+	ctx context.Context, author *author.Author, env *api.Envelope, readerPub *ecdsa.PublicKey,
 ) (id.ID, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -85,17 +109,398 @@ func (f *fixedQuerier) share(
 
 func newDefaultParameters() *Parameters {
 	return &Parameters{
-		Duration:                DefaultDuration,
-		NAuthors:                DefaultNAuthors,
-		DocsPerDay:              DefaultDocsPerDay,
-		ContentSizeKBGammaShape: DefaultContentSizeKBGammaShape,
-		ContentSizeKBGammaRate:  DefaultContentSizeKBGammaRate,
-		SharesPerUpload:         DefaultSharesPerUpload,
-		DownloadWaitMin:         DefaultDownloadWaitMin,
-		DownloadWaitMax:         DefaultDownloadWaitMax,
-		NUploaders:              DefaultNUploaders,
-		NDownloaders:            DefaultNDownloaders,
-		Profile:                 DefaultProfile,
-		LogLevel:                DefaultLogLevel,
+		Duration:                    DefaultDuration,
+		NAuthors:                    DefaultNAuthors,
+		DocsPerDay:                  DefaultDocsPerDay,
+		ContentSizeKBGammaShape:     DefaultContentSizeKBGammaShape,
+		ContentSizeKBGammaRate:      DefaultContentSizeKBGammaRate,
+		ContentSizeDist:             DefaultContentSizeDist,
+		ContentSizeKBLogNormalMu:    DefaultContentSizeKBLogNormalMu,
+		ContentSizeKBLogNormalSigma: DefaultContentSizeKBLogNormalSigma,
+		ContentSizeKBParetoMin:      DefaultContentSizeKBParetoMin,
+		ContentSizeKBParetoAlpha:    DefaultContentSizeKBParetoAlpha,
+		ContentSizeKBFixed:          DefaultContentSizeKBFixed,
+		ContentSizeMixture:          DefaultContentSizeMixture,
+		ContentTypes:                DefaultContentTypes,
+		LargeDocFrac:                DefaultLargeDocFrac,
+		LargeDocSizeMBMin:           DefaultLargeDocSizeMBMin,
+		LargeDocSizeMBMax:           DefaultLargeDocSizeMBMax,
+		SharesPerUpload:             DefaultSharesPerUpload,
+		DownloadWaitMin:             DefaultDownloadWaitMin,
+		DownloadWaitMax:             DefaultDownloadWaitMax,
+		NUploaders:                  DefaultNUploaders,
+		NDownloaders:                DefaultNDownloaders,
+		MaxUploaders:                DefaultMaxUploaders,
+		MaxDownloaders:              DefaultMaxDownloaders,
+		OpenLoop:                    DefaultOpenLoop,
+		LoadProfile:                 DefaultLoadProfile,
+		LoadRampStart:               DefaultLoadRampStart,
+		LoadRampEnd:                 DefaultLoadRampEnd,
+		LoadRampDuration:            DefaultLoadRampDuration,
+		LoadStepDuration:            DefaultLoadStepDuration,
+		LoadStepLevels:              DefaultLoadStepLevels,
+		LoadSinePeriod:              DefaultLoadSinePeriod,
+		LoadSineAmplitude:           DefaultLoadSineAmplitude,
+		LoadSpikeInterval:           DefaultLoadSpikeInterval,
+		LoadSpikeDuration:           DefaultLoadSpikeDuration,
+		LoadSpikeScale:              DefaultLoadSpikeScale,
+		SocialGraph:                 DefaultSocialGraph,
+		SocialGraphMeanDegree:       DefaultSocialGraphMeanDegree,
+		SocialGraphCommunities:      DefaultSocialGraphCommunities,
+		SocialGraphCrossFrac:        DefaultSocialGraphCrossFrac,
+		ShareNeighborFrac:           DefaultShareNeighborFrac,
+		Activity:                    DefaultActivity,
+		ActivityZipfExponent:        DefaultActivityZipfExponent,
+		ActivityGammaShape:          DefaultActivityGammaShape,
+		ReReadsPerDay:               DefaultReReadsPerDay,
+		Popularity:                  DefaultPopularity,
+		PopularityZipfExponent:      DefaultPopularityZipfExponent,
+		PrefillDocs:                 DefaultPrefillDocs,
+		PrefillGB:                   DefaultPrefillGB,
+		QueryTimeouts:               DefaultQueryTimeouts,
+		DrainPeriod:                 DefaultDrainPeriod,
+		RetryJitter:                 DefaultRetryJitter,
+		PinLibrarians:               DefaultPinLibrarians,
+		PersistAuthors:              DefaultPersistAuthors,
+		Seed:                        DefaultSeed,
+		Profile:                     DefaultProfile,
+		LogLevel:                    DefaultLogLevel,
+	}
+}
+
+// testLibrarianAddrs are the librarian addresses of test runners.
+var testLibrarianAddrs = []*net.TCPAddr{
+	{IP: net.ParseIP("192.168.1.1"), Port: 20100},
+	{IP: net.ParseIP("192.168.1.2"), Port: 20100},
+}
+
+// newTestRunner creates a runner with the given parameters in a new data directory, which the
+// returned func removes, and with a fixedQuerier instead of libri.
+func newTestRunner(t *testing.T, params *Parameters) (*Runner, func()) {
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	assert.Nil(t, err)
+	r := newTestRunnerInDir(t, params, dataDir)
+	return r, func() { os.RemoveAll(dataDir) }
+}
+
+// newTestRunnerInDir creates a runner with the given parameters in the given data directory,
+// e.g., for a run that loads the authors persisted by an earlier one, with a fixedQuerier instead
+// of libri.
+func newTestRunnerInDir(t *testing.T, params *Parameters, dataDir string) *Runner {
+	r, err := NewRunner(params, dataDir, testLibrarianAddrs)
+	assert.Nil(t, err)
+	r.querier = &fixedQuerier{uploaded: make(map[string][]byte), rng: rand.New(rand.NewSource(0))}
+	return r
+}
+
+func TestRunner_RunLog(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 100 * time.Millisecond
+	params.NAuthors = 5
+
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dataDir)
+	logs := new(bytes.Buffer)
+	r, err := NewRunnerWithLog(params, dataDir, testLibrarianAddrs, logs)
+	assert.Nil(t, err)
+	r.querier = &fixedQuerier{uploaded: make(map[string][]byte), rng: rand.New(rand.NewSource(0))}
+	r.Run()
+
+	assert.Contains(t, logs.String(), "finished experiment duration")
+}
+
+func TestRunner_RunPhases(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	params.DocsPerDay = 1000000
+	params.LoadProfile = ConstantLoadProfile
+	params.Phases = []*Phase{
+		{Name: "first", Duration: 200 * time.Millisecond},
+		{Name: "second", Duration: 200 * time.Millisecond, DocsPerDay: 2000000},
+	}
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+
+	start := time.Now()
+	r.Run()
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.activePhase.WithLabelValues("first")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.activePhase.WithLabelValues("second")))
+}
+
+func TestRunner_RecordReplay(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	params.DocsPerDay = 100000
+	params.ReReadsPerDay = 200000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+	params.Phases = []*Phase{
+		{Name: "first", Duration: 250 * time.Millisecond},
+		{Name: "second", Duration: 250 * time.Millisecond, SharesPerUpload: 1},
+	}
+
+	// record
+	r1, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	traceBuf := new(bytes.Buffer)
+	tw, err := NewTraceWriter(traceBuf, params)
+	assert.Nil(t, err)
+	r1.RecordTrace(tw)
+	r1.Run()
+	assert.Nil(t, tw.Close())
+
+	// count recorded events
+	tr, err := NewTraceReader(bytes.NewReader(traceBuf.Bytes()))
+	assert.Nil(t, err)
+	nUploads, nShares, nReReads, nPhases := 0, 0, 0, 0
+	rec, err := tr.next()
+	for ; err == nil; rec, err = tr.next() {
+		switch rec.Kind {
+		case uploadRecord:
+			nUploads++
+			nShares += len(rec.ShareWith)
+		case reReadRecord:
+			nReReads++
+		case phaseRecord:
+			nPhases++
+		}
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, nUploads > 0)
+	assert.True(t, nReReads > 0)
+	assert.Equal(t, 1, nPhases)
+	recorded := r1.Summary()
+	assert.Equal(t, recorded.Operations[rereadOp].Attempted, uint64(nReReads))
+
+	// replay
+	tr, err = NewTraceReader(bytes.NewReader(traceBuf.Bytes()))
+	assert.Nil(t, err)
+	replayParams := tr.ReplayParameters(newDefaultParameters())
+	assert.Equal(t, params.NAuthors, replayParams.NAuthors)
+	assert.Equal(t, DefaultDrainPeriod, replayParams.DrainPeriod)
+	r2, cleanup := newTestRunner(t, replayParams)
+	defer cleanup()
+	r2.Replay(tr)
+
+	// every recorded event is replayed, and queued uploads and pending downloads are drained
+	// rather than abandoned
+	replayed := r2.Summary()
+	assert.Equal(t, uint64(nUploads), replayed.Operations[uploadOp].Attempted)
+	assert.Equal(t, uint64(nShares), replayed.Operations[shareOp].Attempted)
+	assert.Equal(t, uint64(nShares), replayed.Operations[downloadOp].Attempted)
+	assert.Equal(t, uint64(nReReads), replayed.Operations[rereadOp].Attempted)
+	for _, op := range []string{uploadOp, shareOp, downloadOp, rereadOp} {
+		assert.Zero(t, replayed.Operations[op].Abandoned, op)
+	}
+	assert.Equal(t, "second", r2.control.state().Phase)
+}
+
+func TestRunner_RunReReads(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 500 * time.Millisecond
+	params.NAuthors = 5
+	params.DocsPerDay = 100000
+	params.ReReadsPerDay = 200000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.Run()
+
+	summary := r.Summary()
+	assert.True(t, summary.Operations[rereadOp].Succeeded > 0)
+	assert.Zero(t, summary.Operations[rereadOp].Failed)
+	assert.Zero(t, summary.Operations[rereadOp].Corrupt)
+	assert.True(t, r.catalogue.len() > 0)
+}
+
+func TestRunner_RunPersistAuthors(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 300 * time.Millisecond
+	params.NAuthors = 5
+	params.DocsPerDay = 100000
+	params.ReReadsPerDay = 100000
+	params.PersistAuthors = true
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dataDir)
+
+	r1 := newTestRunnerInDir(t, params, dataDir)
+	r1.Run()
+	nShared := r1.Summary().Operations[shareOp].Succeeded
+	assert.True(t, nShared > 0)
+
+	// second run starts with the docs shared in the first in its catalogue, which it can
+	// download from the first run's querier
+	r2 := newTestRunnerInDir(t, params, dataDir)
+	assert.Equal(t, int(nShared), r2.catalogue.len())
+	r2.querier = r1.querier
+	r2.Run()
+	assert.Zero(t, r2.Summary().Operations[rereadOp].Corrupt)
+	assert.True(t, r2.catalogue.len() > int(nShared))
+}
+
+func TestRunner_RunLargeDocs(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 500 * time.Millisecond
+	params.NAuthors = 5
+	params.DocsPerDay = 100000
+	params.LargeDocFrac = 1.0
+	params.LargeDocSizeMBMin = 1.0
+	params.LargeDocSizeMBMax = 5.0
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.Run()
+
+	summary := r.Summary()
+	assert.True(t, summary.Operations[largeUploadOp].Succeeded > 0)
+	assert.Zero(t, summary.Operations[uploadOp].Attempted)
+	assert.Zero(t, summary.Operations[largeDownloadOp].Corrupt)
+	assert.Zero(t, summary.Operations[largeDownloadOp].Truncated)
+	assert.True(t, summary.LargeDocs[largeUploadOp].EstimatedPages["p50"] >= 1)
+}
+
+func TestRunner_RunPinLibrarians(t *testing.T) {
+	params := newDefaultParameters()
+	params.Duration = 300 * time.Millisecond
+	params.NAuthors = 4
+	params.DocsPerDay = 100000
+	params.PinLibrarians = true
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.Run()
+
+	summary := r.Summary()
+	assert.Len(t, summary.Librarians, len(testLibrarianAddrs))
+	nUploads := uint64(0)
+	queryFrac := 0.0
+	for _, ls := range summary.Librarians {
+		nUploads += ls.Attempted[uploadOp]
+		queryFrac += ls.QueryFrac
+	}
+	assert.Equal(t, summary.Operations[uploadOp].Attempted, nUploads)
+	assert.InDelta(t, 1.0, queryFrac, 1e-9)
+}
+
+func TestRunner_RunRetries(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	params.Duration = 300 * time.Millisecond
+	params.DocsPerDay = 100000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 0
+	params.DownloadWaitMax = 10 * time.Millisecond
+	params.RetryPolicies = []string{"upload:3:1ms:1ms"}
+
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.querier = &flakyQuerier{
+		fixedQuerier: &fixedQuerier{
+			uploaded: make(map[string][]byte),
+			rng:      rand.New(rand.NewSource(0)),
+		},
+		attempted: make(map[string]struct{}),
+	}
+	r.Run()
+
+	up := r.Summary().Operations[uploadOp]
+	assert.True(t, up.Succeeded > 0)
+	assert.Equal(t, uint64(0), up.FirstTrySucceeded)
+	assert.Equal(t, 0.0, up.FirstTrySuccessRate)
+
+	// uploads drained after the run stops aren't retried, so only those before are guaranteed
+	// to eventually succeed
+	assert.True(t, up.Retries >= up.Succeeded)
+	assert.True(t, up.SuccessRate > 0)
+}
+
+// flakyQuerier fails the first upload of each document with a retryable error.
+type flakyQuerier struct {
+	*fixedQuerier
+	attempted map[string]struct{}
+	mu        sync.Mutex
+}
+
+func (f *flakyQuerier) upload(
+	ctx context.Context, author *author.Author, content io.Reader, mediaType string,
+) (*api.Envelope, error) {
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	_, retry := f.attempted[string(buf)]
+	f.attempted[string(buf)] = struct{}{}
+	f.mu.Unlock()
+	if !retry {
+		return nil, context.DeadlineExceeded
 	}
+	return f.fixedQuerier.upload(ctx, author, bytes.NewReader(buf), mediaType)
+}
+
+func TestRunner_Latency(t *testing.T) {
+	start := time.Now()
+	scheduled := start.Add(-time.Second)
+
+	r := &Runner{params: &Parameters{OpenLoop: true}}
+	assert.True(t, r.latency(scheduled, start) >= time.Second)
+
+	r = &Runner{params: &Parameters{OpenLoop: false}}
+	assert.True(t, r.latency(scheduled, start) < time.Second)
+}
+
+func TestRunner_RunDrain(t *testing.T) {
+	// pending downloads due within the drain period run
+	params := newDefaultParameters()
+	params.NAuthors = 5
+	params.Duration = 300 * time.Millisecond
+	params.DocsPerDay = 100000
+	params.LoadProfile = ConstantLoadProfile
+	params.DownloadWaitMin = 100 * time.Millisecond
+	params.DownloadWaitMax = 100 * time.Millisecond
+	params.DrainPeriod = 5 * time.Second
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	r.Run()
+
+	summary := r.Summary()
+	shares, downloads := summary.Operations[shareOp], summary.Operations[downloadOp]
+	assert.True(t, shares.Succeeded > 0)
+	assert.Equal(t, shares.Succeeded, downloads.Attempted)
+	assert.Zero(t, downloads.Abandoned)
+	assert.True(t, summary.DrainSeconds < params.DrainPeriod.Seconds())
+
+	// pending downloads due after the drain period are abandoned
+	params.DownloadWaitMin = time.Hour
+	params.DownloadWaitMax = time.Hour
+	params.DrainPeriod = 50 * time.Millisecond
+	r, cleanup = newTestRunner(t, params)
+	defer cleanup()
+	start := time.Now()
+	r.Run()
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	summary = r.Summary()
+	shares, downloads = summary.Operations[shareOp], summary.Operations[downloadOp]
+	assert.True(t, shares.Succeeded > 0)
+	assert.Zero(t, downloads.Attempted)
+	assert.Equal(t, shares.Succeeded, downloads.Abandoned)
 }
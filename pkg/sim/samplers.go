@@ -1,9 +1,7 @@
 package sim
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"fmt"
 	"math/rand"
 	"net"
 	"sync"
@@ -11,7 +9,7 @@ import (
 
 	"github.com/drausin/libri/libri/author"
 	"github.com/drausin/libri/libri/author/keychain"
-	"github.com/drausin/libri/libri/common/logging"
+	"go.uber.org/zap"
 	erand "golang.org/x/exp/rand"
 	"gonum.org/v1/gonum/stat/distuv"
 )
@@ -22,7 +20,16 @@ const (
 
 type directory interface {
 	get(key *ecdsa.PublicKey) *author.Author
-	sample() (*author.Author, *ecdsa.PublicKey)
+
+	// sample returns a random author's index, the author, and one of its public keys.
+	sample() (int, *author.Author, *ecdsa.PublicKey)
+
+	// lookup returns the author with the given index and one of its public keys.
+	lookup(i int) (*author.Author, *ecdsa.PublicKey)
+
+	// sampleShare returns the index and one of the public keys of an author for the author with
+	// the given index to share with.
+	sampleShare(from int) (int, *ecdsa.PublicKey)
 }
 
 type directoryImpl struct {
@@ -31,6 +38,16 @@ type directoryImpl struct {
 	authorPubs map[string]*author.Author
 	rng        *rand.Rand
 	mu         sync.Mutex
+
+	// activity weights which authors upload and receive shares, or is nil for uniform activity
+	activity *weightedIndex
+
+	// nLoaded is the number of authors whose keychains were loaded from earlier runs
+	nLoaded int
+
+	// librarians contains the address of the librarian each author is pinned to, or is nil if
+	// authors use all librarians
+	librarians []string
 }
 
 func newDirectory(
@@ -39,13 +56,25 @@ func newDirectory(
 	librarianAddrs []*net.TCPAddr,
 	nAuthors uint,
 	logLevelStr string,
-) *directoryImpl {
+	logger *zap.Logger,
+	activityWeights []float64,
+	persist bool,
+	pin bool,
+) (*directoryImpl, error) {
 
 	authors := make([]*author.Author, nAuthors)
 	keys := make([]keychain.GetterSampler, nAuthors)
-	logger := logging.NewDevLogger(logging.GetLogLevel(logLevelStr))
+	loaded := make([]bool, nAuthors)
+	errs := make([]error, nAuthors)
 
 	configs := newAuthorConfigs(dataDir, librarianAddrs, nAuthors, logLevelStr)
+	var librarians []string
+	if pin {
+		var err error
+		if librarians, err = pinLibrarians(configs, librarianAddrs); err != nil {
+			return nil, err
+		}
+	}
 	nWorkers := 8
 	wg1 := new(sync.WaitGroup)
 	wg1.Add(nWorkers)
@@ -53,32 +82,69 @@ func newDirectory(
 		go func(d int, wg2 *sync.WaitGroup) {
 			defer wg2.Done()
 			for i := d; i < len(configs); i += nWorkers {
-				// create keychains
-				authorKC := keychain.New(nInitialKeys)
-				selfReaderKC := keychain.New(nInitialKeys)
-				keys[i] = authorKC
+				// create or load keychains
+				authorKC, selfReaderKC, isLoaded, err := loadOrCreateKeychains(
+					configs[i].KeychainDir, persist)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				keys[i], loaded[i] = authorKC, isLoaded
 
 				// create author
-				var err error
 				authors[i], err = author.NewAuthor(configs[i], authorKC, selfReaderKC, logger)
 				maybePanic(err)
 			}
 		}(c, wg1)
 	}
 	wg1.Wait()
-	return &directoryImpl{
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	d := &directoryImpl{
 		authors:    authors,
 		keys:       keys,
 		authorPubs: make(map[string]*author.Author),
 		rng:        rng,
+		librarians: librarians,
+	}
+	if activityWeights != nil {
+		d.activity = newWeightedIndex(activityWeights)
+	}
+	for _, isLoaded := range loaded {
+		if isLoaded {
+			d.nLoaded++
+		}
 	}
+	return d, nil
 }
 
-func (s *directoryImpl) sample() (*author.Author, *ecdsa.PublicKey) {
-	nAuthors := len(s.authors)
+func (s *directoryImpl) sample() (int, *author.Author, *ecdsa.PublicKey) {
 	s.mu.Lock()
-	i := s.rng.Int31n(int32(nAuthors))
+	i := s.sampleIdx()
 	s.mu.Unlock()
+	auth, authorPubKey := s.lookup(i)
+	return i, auth, authorPubKey
+}
+
+// sampleIdx returns a random author index according to the authors' activity. It must be called
+// with the lock held.
+func (s *directoryImpl) sampleIdx() int {
+	if s.activity == nil {
+		return int(s.rng.Int31n(int32(len(s.authors))))
+	}
+	return s.activity.sample(s.rng)
+}
+
+// sampleShare returns a random author according to the authors' activity.
+func (s *directoryImpl) sampleShare(from int) (int, *ecdsa.PublicKey) {
+	i, _, authorPubKey := s.sample()
+	return i, authorPubKey
+}
+
+func (s *directoryImpl) lookup(i int) (*author.Author, *ecdsa.PublicKey) {
 	auth := s.authors[i]
 	authorKey, err := s.keys[i].Sample()
 	maybePanic(err) // should never happen
@@ -137,56 +203,43 @@ type uploadEventSampler interface {
 type uploadEventSamplerImpl struct {
 	nSharesPerUpload uint
 	content          contentSampler
+	contentTypes     *contentTypeSampler
+	largeDocs        *largeDocSampler
+	downloadWait     durationSampler
 	authors          directory
 }
 
 func (s *uploadEventSamplerImpl) sample() *uploadEvent {
-	from, _ := s.authors.sample()
+	fromIdx, from, _ := s.authors.sample()
+	shareWithIdxs := make([]int, s.nSharesPerUpload)
 	shareWith := make([]*ecdsa.PublicKey, s.nSharesPerUpload)
+	downloadWaits := make([]time.Duration, s.nSharesPerUpload)
 	for i := range shareWith {
-		// just sample a random author, not really representative of real world, but for now gets
-		// us the Share and Download load we want
-		_, shareWith[i] = s.authors.sample()
+		shareWithIdxs[i], shareWith[i] = s.authors.sampleShare(fromIdx)
+		downloadWaits[i] = s.downloadWait.sample()
 	}
-	return &uploadEvent{
-		content:   s.content.sample(),
-		from:      from,
-		shareWith: shareWith,
+	size, contentSeed := s.content.sample()
+	ct := s.contentTypes.sample()
+	event := &uploadEvent{
+		size:          size,
+		contentSeed:   contentSeed,
+		contentType:   ct,
+		fromIdx:       fromIdx,
+		from:          from,
+		shareWithIdxs: shareWithIdxs,
+		shareWith:     shareWith,
+		downloadWaits: downloadWaits,
 	}
-}
-
-type contentSampler interface {
-	sample() *bytes.Buffer
-}
-
-type gammaContentSampler struct {
-	sizeSampler *distuv.Gamma
-	rng         *rand.Rand
-	mu          sync.Mutex
-}
-
-func newGammaContentSampler(rng *rand.Rand, shape float64, rate float64) *gammaContentSampler {
-	return &gammaContentSampler{
-		sizeSampler: &distuv.Gamma{
-			Alpha: shape,
-			Beta:  rate,
-			Src:   erand.New(erand.NewSource(rng.Uint64())),
-		},
-		rng: rng,
+	if large, largeSize := s.largeDocs.sample(); large {
+		event.large, event.size = true, largeSize
+		return event
 	}
+	event.content = newContent(size, contentSeed, ct.generator)
+	event.digest = newContentDigest(event.content.Bytes())
+	return event
 }
 
-func (s *gammaContentSampler) sample() *bytes.Buffer {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	size := int(s.sizeSampler.Rand() * 1024)
-	content := make([]byte, size)
-	for c := 0; c < 3; c++ {
-		if n, err := s.rng.Read(content); err != nil {
-			panic(err)
-		} else if n == size {
-			return bytes.NewBuffer(content)
-		}
-	}
-	panic(fmt.Errorf("unable to read %d bytes of content", size))
+type contentSampler interface {
+	// sample returns the size and seed of new content, from which newContent generates it.
+	sample() (int, int64)
 }
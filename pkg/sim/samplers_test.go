@@ -11,6 +11,7 @@ import (
 	"github.com/drausin/libri/libri/author"
 	"github.com/drausin/libri/libri/common/ecid"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func TestDirectoryImplSampleGet(t *testing.T) {
@@ -20,11 +21,14 @@ func TestDirectoryImplSampleGet(t *testing.T) {
 	defer os.RemoveAll(dataDir)
 	assert.Nil(t, err)
 	nAuthors := uint(3)
-	d := newDirectory(rng, dataDir, librarianAddrs, nAuthors, "info")
+	d, err := newDirectory(rng, dataDir, librarianAddrs, nAuthors, "info", zap.NewNop(), nil, false,
+		false)
+	assert.Nil(t, err)
 
 	// check sample behaves as expected
-	a1, pubKey := d.sample()
-	assert.NotNil(t, a1)
+	i, a1, pubKey := d.sample()
+	assert.True(t, i >= 0 && i < int(nAuthors))
+	assert.Equal(t, d.authors[i], a1)
 	assert.NotNil(t, pubKey)
 
 	// check get returns author equal to a1
@@ -32,6 +36,51 @@ func TestDirectoryImplSampleGet(t *testing.T) {
 	assert.Equal(t, a1, a2)
 }
 
+func TestDirectoryImplSample_activity(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	librarianAddrs := []*net.TCPAddr{{IP: net.ParseIP("192.168.1.1"), Port: 20100}}
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	defer os.RemoveAll(dataDir)
+	assert.Nil(t, err)
+	d, err := newDirectory(rng, dataDir, librarianAddrs, 3, "info", zap.NewNop(),
+		[]float64{0, 0, 1}, false, false)
+	assert.Nil(t, err)
+
+	// only the author with non-zero activity is ever sampled
+	for c := 0; c < 10; c++ {
+		i, _, _ := d.sample()
+		assert.Equal(t, 2, i)
+		i, _ = d.sampleShare(0)
+		assert.Equal(t, 2, i)
+	}
+}
+
+func TestDirectoryImpl_persist(t *testing.T) {
+	librarianAddrs := []*net.TCPAddr{{IP: net.ParseIP("192.168.1.1"), Port: 20100}}
+	dataDir, err := ioutil.TempDir("", "sim-data-dir")
+	defer os.RemoveAll(dataDir)
+	assert.Nil(t, err)
+	nAuthors := uint(3)
+
+	d1, err := newDirectory(rand.New(rand.NewSource(0)), dataDir, librarianAddrs, nAuthors,
+		"info", zap.NewNop(), nil, true, false)
+	assert.Nil(t, err)
+	assert.Zero(t, d1.nLoaded)
+
+	// second directory loads the first's keychains
+	d2, err := newDirectory(rand.New(rand.NewSource(0)), dataDir, librarianAddrs, nAuthors,
+		"info", zap.NewNop(), nil, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int(nAuthors), d2.nLoaded)
+	for i := range d1.keys {
+		assert.Equal(t, d1.keys[i].Len(), d2.keys[i].Len())
+		key, err := d1.keys[i].Sample()
+		assert.Nil(t, err)
+		_, in := d2.keys[i].Get(key.PublicKeyBytes())
+		assert.True(t, in)
+	}
+}
+
 func TestUploadEventSamplerImplSample(t *testing.T) {
 	rng := rand.New(rand.NewSource(0))
 	nSharesPerUpload := uint(2)
@@ -40,29 +89,99 @@ func TestUploadEventSamplerImplSample(t *testing.T) {
 		returnAuthor: &author.Author{},
 	}
 
-	cs := newGammaContentSampler(rng, DefaultContentSizeKBGammaShape, DefaultContentSizeKBGammaRate)
+	cs := newSizeContentSampler(gammaSizeDist{
+		shape: DefaultContentSizeKBGammaShape,
+		rate:  DefaultContentSizeKBGammaRate,
+	}, rng)
+	cts, err := newContentTypeSampler(DefaultContentTypes, rng)
+	assert.Nil(t, err)
 	s := uploadEventSamplerImpl{
+		contentTypes:     cts,
 		nSharesPerUpload: nSharesPerUpload,
 		content:          cs,
+		downloadWait:     newTestDownloadWaitSampler(rng),
 		authors:          d,
 	}
 	e := s.sample()
 	assert.NotNil(t, e.content)
 	assert.NotNil(t, e.from)
 	assert.True(t, len(e.shareWith) == int(nSharesPerUpload))
+	assert.True(t, len(e.shareWithIdxs) == int(nSharesPerUpload))
+	assert.True(t, len(e.downloadWaits) == int(nSharesPerUpload))
+}
+
+func TestUploadEventSamplerImplSample_reproducible(t *testing.T) {
+	newSampler := func(seed int64) uploadEventSampler {
+		params := newDefaultParameters()
+		params.Seed = seed
+		d := &fixedDirectory{
+			rng:          rand.New(rand.NewSource(0)),
+			idxRNG:       newStreamRNG(seed, directoryStream),
+			returnAuthor: &author.Author{},
+			nAuthors:     100,
+		}
+		downloadWait := newTestDownloadWaitSampler(newStreamRNG(seed, downloadWaitStream))
+		phases, err := getPhases(params)
+		assert.Nil(t, err)
+		cts, err := newContentTypeSampler([]string{"1:text:text/plain", "1:random:image/png"},
+			newStreamRNG(seed, contentTypeStream))
+		assert.Nil(t, err)
+		contentSizes, err := newContentSizeDist(phases[0])
+		assert.Nil(t, err)
+		_, s := phaseSamplers(phases[0], params.NAuthors, d, downloadWait, contentSizes, cts,
+			nil, newStreamRNG(seed, uploadWaitStream), newStreamRNG(seed, contentStream))
+		return s
+	}
+	s1, s2, s3 := newSampler(1), newSampler(1), newSampler(2)
+	for c := 0; c < 8; c++ {
+		e1, e2, e3 := s1.sample(), s2.sample(), s3.sample()
+
+		// same seed gives same events
+		assert.Equal(t, e1.fromIdx, e2.fromIdx)
+		assert.Equal(t, e1.shareWithIdxs, e2.shareWithIdxs)
+		assert.Equal(t, e1.downloadWaits, e2.downloadWaits)
+		assert.Equal(t, e1.digest, e2.digest)
+
+		// different seed gives different events
+		assert.NotEqual(t, e1.digest, e3.digest)
+	}
+}
+
+func newTestDownloadWaitSampler(rng *rand.Rand) durationSampler {
+	return &uniformDurationSampler{
+		min: DefaultDownloadWaitMin,
+		max: DefaultDownloadWaitMax,
+		rng: rng,
+	}
 }
 
 type fixedDirectory struct {
 	rng          *rand.Rand
+	idxRNG       *rand.Rand
 	returnAuthor *author.Author
+	nAuthors     int
 }
 
-func (f *fixedDirectory) sample() (*author.Author, *ecdsa.PublicKey) {
-	// just return empty author and random pub key
+func (f *fixedDirectory) sample() (int, *author.Author, *ecdsa.PublicKey) {
+	// just return empty author and random pub key, plus a random index if configured
+	i := 0
+	if f.nAuthors > 0 {
+		i = f.idxRNG.Intn(f.nAuthors)
+	}
+	id := ecid.NewPseudoRandom(f.rng)
+	return i, f.returnAuthor, &id.Key().PublicKey
+}
+
+func (f *fixedDirectory) lookup(i int) (*author.Author, *ecdsa.PublicKey) {
 	id := ecid.NewPseudoRandom(f.rng)
 	return f.returnAuthor, &id.Key().PublicKey
 }
 
+func (f *fixedDirectory) sampleShare(from int) (int, *ecdsa.PublicKey) {
+	i, _, pubKey := f.sample()
+	return i, pubKey
+}
+
 func (f *fixedDirectory) get(key *ecdsa.PublicKey) *author.Author {
 	return f.returnAuthor
 }
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"container/heap"
+	"time"
+)
+
+// downloadQueue is a min-heap of download events by scheduled time.
+type downloadQueue []*downloadEvent
+
+func (q downloadQueue) Len() int           { return len(q) }
+func (q downloadQueue) Less(i, j int) bool { return q[i].scheduled.Before(q[j].scheduled) }
+func (q downloadQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *downloadQueue) Push(x interface{}) {
+	*q = append(*q, x.(*downloadEvent))
+}
+
+func (q *downloadQueue) Pop() interface{} {
+	old := *q
+	event := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return event
+}
+
+// scheduleDownloads holds the download events sent to the schedule queue until they're due and
+// then sends them to the downloaders' queue, so downloaders are only taken by downloads that can
+// start right away. Events still held when the drain period ends are abandoned. It closes the
+// downloaders' queue once the schedule queue is closed and every held event is sent or abandoned.
+func (r *Runner) scheduleDownloads() {
+	defer close(r.toDownload)
+	pending := &downloadQueue{}
+	in, drained := r.toSchedule, r.drained
+
+	// sendStart is when the scheduler started trying to send the first due event, so the time
+	// until the send succeeds is how long it was blocked by a full downloaders' queue
+	var sendStart time.Time
+	for in != nil || pending.Len() > 0 {
+		var next *downloadEvent
+		var out chan<- *downloadEvent
+		var timer *time.Timer
+		var due <-chan time.Time
+		if pending.Len() > 0 {
+			next = (*pending)[0]
+			if wait := time.Until(next.scheduled); wait > 0 {
+				timer = time.NewTimer(wait)
+				due = timer.C
+			} else {
+				out = r.toDownload
+				if sendStart.IsZero() {
+					sendStart = time.Now()
+				}
+			}
+		}
+		select {
+		case event, ok := <-in:
+			if !ok {
+				in = nil
+			} else if drained == nil {
+				r.metrics.observeAbandoned(event.op)
+			} else {
+				heap.Push(pending, event)
+			}
+		case <-due:
+		case out <- next:
+			heap.Pop(pending)
+			r.downloaders.addBlocked(time.Since(sendStart))
+			sendStart = time.Time{}
+		case <-drained:
+			for pending.Len() > 0 {
+				r.metrics.observeAbandoned(heap.Pop(pending).(*downloadEvent).op)
+			}
+			drained = nil
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
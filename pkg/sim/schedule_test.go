@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunner_scheduleDownloads(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 3
+	r, cleanup := newTestRunner(t, params)
+	defer cleanup()
+	scheduled := make(chan struct{})
+	go func() {
+		defer close(scheduled)
+		r.scheduleDownloads()
+	}()
+
+	// events are sent to the downloaders' queue in scheduled order once they're due
+	start := time.Now()
+	r.toSchedule <- &downloadEvent{op: downloadOp, scheduled: start.Add(100 * time.Millisecond)}
+	r.toSchedule <- &downloadEvent{op: rereadOp, scheduled: start.Add(50 * time.Millisecond)}
+	r.toSchedule <- &downloadEvent{op: shareOp, scheduled: start}
+	assert.Equal(t, shareOp, (<-r.toDownload).op)
+	assert.Equal(t, rereadOp, (<-r.toDownload).op)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, downloadOp, (<-r.toDownload).op)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// events still held or sent once the drain period ends are abandoned
+	r.toSchedule <- &downloadEvent{op: downloadOp, scheduled: time.Now().Add(time.Hour)}
+	time.Sleep(10 * time.Millisecond)
+	r.endDrain()
+	r.toSchedule <- &downloadEvent{op: downloadOp, scheduled: time.Now()}
+	close(r.toSchedule)
+	<-scheduled
+	_, open := <-r.toDownload
+	assert.False(t, open)
+	assert.Equal(t, uint64(2), r.metrics.abandonedCount(downloadOp))
+}
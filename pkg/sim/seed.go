@@ -0,0 +1,37 @@
+package sim
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+)
+
+const (
+	directoryStream    = "directory"
+	uploadWaitStream   = "upload-wait"
+	contentStream      = "content"
+	contentTypeStream  = "content-type"
+	largeDocStream     = "large-doc"
+	downloadWaitStream = "download-wait"
+	socialGraphStream  = "social-graph"
+	activityStream     = "activity"
+	rereadStream       = "reread"
+	retryStream        = "retry"
+)
+
+// newStreamRNG returns a random number generator for the named stream of the experiment seed.
+// Each stream's source is seeded by a hash of the experiment seed and the stream name, so streams
+// for the same seed are independent of each other, and the same seed always gives the same
+// streams.
+func newStreamRNG(seed int64, stream string) *rand.Rand {
+	return rand.New(rand.NewSource(deriveSeed(seed, stream)))
+}
+
+func deriveSeed(seed int64, stream string) int64 {
+	h := fnv.New64a()
+	err := binary.Write(h, binary.BigEndian, seed)
+	maybePanic(err) // should never happen
+	_, err = h.Write([]byte(stream))
+	maybePanic(err) // should never happen
+	return int64(h.Sum64())
+}
@@ -0,0 +1,25 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStreamRNG(t *testing.T) {
+	// same seed and stream give the same values
+	assert.Equal(t,
+		newStreamRNG(1, contentStream).Int63(),
+		newStreamRNG(1, contentStream).Int63(),
+	)
+
+	// different seeds or streams give different values
+	assert.NotEqual(t,
+		newStreamRNG(1, contentStream).Int63(),
+		newStreamRNG(2, contentStream).Int63(),
+	)
+	assert.NotEqual(t,
+		newStreamRNG(1, contentStream).Int63(),
+		newStreamRNG(1, uploadWaitStream).Int63(),
+	)
+}
@@ -0,0 +1,254 @@
+package sim
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+var summaryQuantiles = []struct {
+	name string
+	q    float64
+}{
+	{"p50", 0.50},
+	{"p90", 0.90},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// Summary is a machine-readable record of an experiment run.
+type Summary struct {
+	Parameters *Parameters
+	StartTime  time.Time
+	EndTime    time.Time
+	Operations map[string]*OperationSummary
+	Pools      map[string]*PoolSummary
+	Authors    *AuthorsSummary
+	Content    *ContentSummary
+
+	// LargeDocs summarizes the page counts and per-page latencies of large document uploads and
+	// downloads, whose end-to-end latencies are in Operations.
+	LargeDocs map[string]*LargeDocSummary
+
+	// Librarians summarizes the queries sent to each librarian, keyed by address, when authors
+	// are pinned to librarians. Queries are attributed to the pinned librarian the author sent
+	// them to, not to the other librarians it queried in turn.
+	Librarians map[string]*LibrarianSummary `json:",omitempty"`
+
+	// Prefill summarizes the documents uploaded before the experiment was measured, if any.
+	Prefill *PrefillSummary `json:",omitempty"`
+
+	// DrainSeconds is how long in-flight and pending queries took to drain after the runner was
+	// stopped, up to the drain period.
+	DrainSeconds float64
+
+	// Workers contains the summary of each worker of a coordinated experiment, whose merged
+	// results are the rest of the summary.
+	Workers []*Summary `json:",omitempty"`
+}
+
+// OperationSummary summarizes the queries made for a single operation (upload, share, or
+// download).
+type OperationSummary struct {
+	Attempted uint64
+	Succeeded uint64
+	Failed    uint64
+
+	// Corrupt, Truncated, and Missing count downloads that returned without error but whose
+	// content did not match what was uploaded.
+	Corrupt   uint64
+	Truncated uint64
+	Missing   uint64
+
+	// TimedOut counts queries that didn't return before TimeoutMS, the operation's timeout in
+	// milliseconds (0 if it has none). They're also counted by Errors but not by Failed.
+	TimedOut  uint64
+	TimeoutMS float64
+
+	// Errors contains the number of failed queries by error class, e.g., deadline_exceeded.
+	Errors map[string]uint64
+
+	// Abandoned counts queries that were generated but never made because the runner stopped
+	// before or drained after they were due. They aren't counted by Attempted.
+	Abandoned uint64
+
+	// FirstTrySucceeded is the number of queries that succeeded on their first attempt, and
+	// Retries is the total number of retries. Failed queries are only counted once, after their
+	// last retry.
+	FirstTrySucceeded uint64
+	Retries           uint64
+
+	// FirstTrySuccessRate and SuccessRate are the fractions of queries that succeeded on their
+	// first attempt and eventually.
+	FirstTrySuccessRate float64
+	SuccessRate         float64
+
+	// Throughput is the number of successful queries per second over the run.
+	Throughput float64
+
+	// LatencyMS contains percentiles (p50, p90, ...) of successful query latency in milliseconds.
+	LatencyMS map[string]float64
+
+	// ScheduleLagMS contains percentiles and the max of how far behind schedule queries started,
+	// in milliseconds.
+	ScheduleLagMS map[string]float64
+}
+
+// PoolSummary summarizes the size and saturation of a worker pool.
+type PoolSummary struct {
+	InitialWorkers uint
+	MaxWorkers     uint
+	FinalWorkers   uint
+
+	// SaturatedSeconds is the time the pool spent backlogged at its max number of workers, i.e.,
+	// when the sim rather than the cluster was the bottleneck.
+	SaturatedSeconds float64
+
+	// BlockedSeconds is the time producers spent blocked sending to the pool's full queue.
+	BlockedSeconds float64
+}
+
+// AuthorsSummary summarizes the realized distribution of activity over authors.
+type AuthorsSummary struct {
+	// Uploads and SharesReceived contain the number of each author's uploads and received
+	// shares, indexed by author.
+	Uploads        []uint64
+	SharesReceived []uint64
+
+	// TopUploadsFrac and TopSharesReceivedFrac are the fractions of all uploads and received
+	// shares from the most active 10% of authors.
+	TopUploadsFrac        float64
+	TopSharesReceivedFrac float64
+}
+
+// ContentSummary summarizes the content successfully uploaded.
+type ContentSummary struct {
+	// UploadedBytes is the total size of uploaded content.
+	UploadedBytes uint64
+
+	// CompressedBytes is the total size of uploaded content after the author compresses it. Content
+	// with already-compressed media types counts at its uploaded size.
+	CompressedBytes uint64
+
+	// CompressionRatio is UploadedBytes / CompressedBytes.
+	CompressionRatio float64
+}
+
+// LargeDocSummary summarizes the successful queries of large, multi-page documents for a single
+// operation. Page counts are estimated from the documents' gzip-compressed sizes rather than
+// reported by libri.
+type LargeDocSummary struct {
+	// EstimatedPages contains percentiles of the estimated number of pages per document.
+	EstimatedPages map[string]float64
+
+	// PageLatencyMS contains percentiles of the mean latency per estimated page of each document
+	// in milliseconds.
+	PageLatencyMS map[string]float64
+}
+
+// LibrarianSummary summarizes the queries authors pinned to a single librarian sent to it.
+type LibrarianSummary struct {
+	// Attempted and Succeeded contain the number of queries by operation.
+	Attempted map[string]uint64
+	Succeeded map[string]uint64
+
+	// Errors contains the number of failed queries by error class.
+	Errors map[string]uint64
+
+	// QueryFrac is the fraction of all queries sent to the librarian.
+	QueryFrac float64
+
+	// LatencyMS contains percentiles of successful query latency over all operations in
+	// milliseconds.
+	LatencyMS map[string]float64
+}
+
+func newSummary(
+	params *Parameters, start, end time.Time, metrics *queryMetrics, pools ...*workerPool,
+) *Summary {
+	elapsed := end.Sub(start).Seconds()
+	ops := make(map[string]*OperationSummary)
+	for _, op := range operations {
+		succeeded := metrics.count(op, successOutcome)
+		failed := metrics.count(op, errorOutcome)
+		corrupt := metrics.count(op, corruptOutcome)
+		truncated := metrics.count(op, truncatedOutcome)
+		missing := metrics.count(op, missingOutcome)
+		timedOut := metrics.count(op, timeoutOutcome)
+		opSummary := &OperationSummary{
+			Attempted: succeeded + failed + corrupt + truncated + missing + timedOut,
+			Succeeded: succeeded,
+			Failed:    failed,
+			Corrupt:   corrupt,
+			Truncated: truncated,
+			Missing:   missing,
+			TimedOut:  timedOut,
+			Abandoned: metrics.abandonedCount(op),
+			Errors:    metrics.errorCounts(op),
+			LatencyMS: latencyPercentilesMS(metrics.latencySamples(op).durations()),
+		}
+		opSummary.FirstTrySucceeded, opSummary.Retries = metrics.retries.counts(op)
+		if opSummary.Attempted > 0 {
+			attempted := float64(opSummary.Attempted)
+			opSummary.FirstTrySuccessRate = float64(opSummary.FirstTrySucceeded) / attempted
+			opSummary.SuccessRate = float64(succeeded) / attempted
+		}
+		opSummary.ScheduleLagMS = lagPercentilesMS(metrics.scheduleLagSamples(op))
+		if elapsed > 0 {
+			opSummary.Throughput = float64(succeeded) / elapsed
+		}
+		ops[op] = opSummary
+	}
+	poolSummaries := make(map[string]*PoolSummary)
+	for _, pool := range pools {
+		poolSummaries[pool.name] = pool.summary()
+	}
+	return &Summary{
+		Parameters: params,
+		StartTime:  start,
+		EndTime:    end,
+		Operations: ops,
+		Pools:      poolSummaries,
+	}
+}
+
+// Write writes the summary as indented JSON.
+func (s *Summary) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// latencyPercentilesMS returns the nearest-rank summary quantiles of the given latencies.
+func latencyPercentilesMS(latencies []time.Duration) map[string]float64 {
+	percentiles := make(map[string]float64)
+	if len(latencies) == 0 {
+		return percentiles
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, sq := range summaryQuantiles {
+		percentiles[sq.name] = latencies[nearestRank(sq.q, len(latencies))].Seconds() * 1e3
+	}
+	return percentiles
+}
+
+// nearestRank returns the index of the q quantile of n sorted values.
+func nearestRank(q float64, n int) int {
+	rank := int(math.Ceil(q*float64(n))) - 1
+	if rank < 0 {
+		return 0
+	}
+	return rank
+}
+
+// lagPercentilesMS returns the summary quantiles of the given sample of schedule lags along
+// with their max.
+func lagPercentilesMS(lags *Reservoir) map[string]float64 {
+	percentiles := latencyPercentilesMS(lags.durations())
+	if len(percentiles) > 0 {
+		percentiles["max"] = time.Duration(lags.Max).Seconds() * 1e3
+	}
+	return percentiles
+}
@@ -0,0 +1,62 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSummary(t *testing.T) {
+	params := newDefaultParameters()
+	m := newQueryMetrics()
+	for c := 1; c <= 10; c++ {
+		m.observe(uploadOp, time.Duration(c)*time.Millisecond, nil)
+	}
+	m.observe(uploadOp, time.Second, errors.New("some upload error"))
+	m.observe(downloadOp, 5*time.Millisecond, nil)
+	m.observeLag(downloadOp, 2*time.Millisecond)
+	m.observeLag(downloadOp, 4*time.Millisecond)
+	m.observeLag(downloadOp, -time.Millisecond) // started early counts as no lag
+	start := time.Now()
+	end := start.Add(5 * time.Second)
+
+	s := newSummary(params, start, end, m)
+	assert.Equal(t, params, s.Parameters)
+	assert.Equal(t, start, s.StartTime)
+	assert.Equal(t, end, s.EndTime)
+
+	up := s.Operations[uploadOp]
+	assert.Equal(t, uint64(11), up.Attempted)
+	assert.Equal(t, uint64(10), up.Succeeded)
+	assert.Equal(t, uint64(1), up.Failed)
+	assert.Equal(t, 2.0, up.Throughput)
+	assert.Equal(t, 5.0, up.LatencyMS["p50"])
+	assert.Equal(t, 10.0, up.LatencyMS["p99"])
+
+	share := s.Operations[shareOp]
+	assert.Equal(t, uint64(0), share.Attempted)
+	assert.Empty(t, share.LatencyMS)
+
+	down := s.Operations[downloadOp]
+	assert.Equal(t, uint64(1), down.Succeeded)
+	assert.Equal(t, 5.0, down.LatencyMS["p95"])
+	assert.Equal(t, 2.0, down.ScheduleLagMS["p50"])
+	assert.Equal(t, 4.0, down.ScheduleLagMS["max"])
+}
+
+func TestSummary_Write(t *testing.T) {
+	s := newSummary(newDefaultParameters(), time.Now(), time.Now(), newQueryMetrics())
+	buf := new(bytes.Buffer)
+	err := s.Write(buf)
+	assert.Nil(t, err)
+
+	s2 := &Summary{}
+	err = json.Unmarshal(buf.Bytes(), s2)
+	assert.Nil(t, err)
+	assert.Equal(t, s.Parameters, s2.Parameters)
+	assert.Len(t, s2.Operations, len(operations))
+}
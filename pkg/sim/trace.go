@@ -0,0 +1,262 @@
+package sim
+
+import (
+	"compress/gzip"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// uploadRecord, reReadRecord, and phaseRecord are the kinds of trace records. Uploads, by
+	// far the most common, are the zero value so their kind is omitted.
+	uploadRecord = ""
+	reReadRecord = "r"
+	phaseRecord  = "p"
+)
+
+// traceHeader is the first line of a trace and describes the experiment it was recorded from.
+type traceHeader struct {
+	NAuthors      uint     `json:"n_authors"`
+	Seed          int64    `json:"seed"`
+	ReReadsPerDay uint     `json:"re_reads_per_day,omitempty"`
+	Phases        []*Phase `json:"phases,omitempty"`
+}
+
+// traceRecord is a single generated event: an upload and the downloads that follow it, a re-read
+// of a shared document, or the start of a phase. Keys are abbreviated to keep traces compact.
+type traceRecord struct {
+	// Offset is the time since the start of the experiment the event was scheduled for.
+	Offset time.Duration `json:"t"`
+	Kind   string        `json:"k,omitempty"`
+
+	// upload fields
+	Author        int             `json:"a"`
+	ContentSize   int             `json:"s,omitempty"`
+	ContentSeed   int64           `json:"cs,omitempty"`
+	Generator     string          `json:"g,omitempty"`
+	MediaType     string          `json:"mt,omitempty"`
+	Large         bool            `json:"l,omitempty"`
+	ShareWith     []int           `json:"sw,omitempty"`
+	DownloadWaits []time.Duration `json:"dw,omitempty"`
+
+	// Doc is the re-read document.
+	Doc *catalogueRef `json:"d,omitempty"`
+
+	// Phase is the index of the started phase.
+	Phase int `json:"p,omitempty"`
+}
+
+// TraceWriter writes generated events to a gzipped, newline-delimited JSON trace.
+type TraceWriter struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// NewTraceWriter creates a new TraceWriter for an experiment with the given parameters.
+func NewTraceWriter(w io.Writer, params *Parameters) (*TraceWriter, error) {
+	gz := gzip.NewWriter(w)
+	tw := &TraceWriter{
+		gz:  gz,
+		enc: json.NewEncoder(gz),
+	}
+	header := &traceHeader{
+		NAuthors:      params.NAuthors,
+		Seed:          params.Seed,
+		ReReadsPerDay: params.ReReadsPerDay,
+		Phases:        params.Phases,
+	}
+	if err := tw.enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+// writeUpload writes an upload event record.
+func (tw *TraceWriter) writeUpload(start time.Time, event *uploadEvent) error {
+	return tw.write(&traceRecord{
+		Offset:        event.scheduled.Sub(start),
+		Author:        event.fromIdx,
+		ContentSize:   event.size,
+		ContentSeed:   event.contentSeed,
+		Generator:     event.contentType.generator,
+		MediaType:     event.contentType.mediaType,
+		Large:         event.large,
+		ShareWith:     event.shareWithIdxs,
+		DownloadWaits: event.downloadWaits,
+	})
+}
+
+// writeReRead writes a record of a re-read of the given document scheduled for the given time.
+func (tw *TraceWriter) writeReRead(start, scheduled time.Time, doc catalogueRef) error {
+	return tw.write(&traceRecord{
+		Offset: scheduled.Sub(start),
+		Kind:   reReadRecord,
+		Doc:    &doc,
+	})
+}
+
+// writePhase writes a record of the start of the phase with the given index at the given time.
+func (tw *TraceWriter) writePhase(start, phaseStart time.Time, phase int) error {
+	return tw.write(&traceRecord{
+		Offset: phaseStart.Sub(start),
+		Kind:   phaseRecord,
+		Phase:  phase,
+	})
+}
+
+// write writes the record, which may come from any of the runner's event generators.
+func (tw *TraceWriter) write(rec *traceRecord) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.enc.Encode(rec)
+}
+
+// Close flushes any remaining trace records. It does not close the underlying writer.
+func (tw *TraceWriter) Close() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.gz.Close()
+}
+
+// TraceReader reads events from a trace written by a TraceWriter.
+type TraceReader struct {
+	gz     *gzip.Reader
+	dec    *json.Decoder
+	header *traceHeader
+}
+
+// NewTraceReader creates a new TraceReader, reading the trace header.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := &TraceReader{
+		gz:     gz,
+		dec:    json.NewDecoder(gz),
+		header: &traceHeader{},
+	}
+	if err := tr.dec.Decode(tr.header); err != nil {
+		return nil, fmt.Errorf("unable to read trace header: %s", err)
+	}
+	if tr.header.NAuthors == 0 {
+		return nil, errors.New("trace header has zero authors")
+	}
+	return tr, nil
+}
+
+// NAuthors returns the number of authors in the experiment the trace was recorded from.
+func (tr *TraceReader) NAuthors() uint {
+	return tr.header.NAuthors
+}
+
+// Seed returns the seed of the experiment the trace was recorded from.
+func (tr *TraceReader) Seed() int64 {
+	return tr.header.Seed
+}
+
+// ReplayParameters returns a copy of the given parameters for replaying the trace. The number of
+// authors and seed come from the trace so the trace's author indices refer to the same authors of
+// the replay and its other sampling, e.g., of retry jitter, matches the recording's. Authors only
+// have the same keys as in the recording when both persist them to the same data directory. The
+// phases and re-read rate come from the trace so the replay has the same phases and keeps the
+// documents it re-reads. The load profile is unused since the trace fixes when every
+// event happens.
+func (tr *TraceReader) ReplayParameters(params *Parameters) *Parameters {
+	replay := *params
+	replay.NAuthors = tr.header.NAuthors
+	replay.Seed = tr.header.Seed
+	replay.ReReadsPerDay = tr.header.ReReadsPerDay
+	replay.Phases = tr.header.Phases
+	replay.LoadProfile = ConstantLoadProfile
+	return &replay
+}
+
+// next returns the next trace record or io.EOF if there are no more.
+func (tr *TraceReader) next() (*traceRecord, error) {
+	rec := &traceRecord{}
+	if err := tr.dec.Decode(rec); err != nil {
+		return nil, err
+	}
+	if err := tr.validate(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (tr *TraceReader) validate(rec *traceRecord) error {
+	switch rec.Kind {
+	case uploadRecord:
+	case reReadRecord:
+		if rec.Doc == nil {
+			return errors.New("trace re-read record has no document")
+		}
+		return nil
+	case phaseRecord:
+		if rec.Phase <= 0 || rec.Phase >= len(tr.header.Phases) {
+			return fmt.Errorf("trace record phase %d out of range", rec.Phase)
+		}
+		return nil
+	default:
+		return fmt.Errorf("trace record has unknown kind %q", rec.Kind)
+	}
+	nAuthors := int(tr.header.NAuthors)
+	if rec.Author < 0 || rec.Author >= nAuthors {
+		return fmt.Errorf("trace record author %d out of range", rec.Author)
+	}
+	for _, i := range rec.ShareWith {
+		if i < 0 || i >= nAuthors {
+			return fmt.Errorf("trace record share author %d out of range", i)
+		}
+	}
+	if len(rec.ShareWith) != len(rec.DownloadWaits) {
+		return fmt.Errorf("trace record has %d shares but %d download waits",
+			len(rec.ShareWith), len(rec.DownloadWaits))
+	}
+	switch rec.Generator {
+	case RandomContent, TextContent, RepetitiveContent:
+	default:
+		return fmt.Errorf("trace record has unknown content generator %q", rec.Generator)
+	}
+	if rec.MediaType == "" {
+		return errors.New("trace record has no media type")
+	}
+	if rec.ContentSize < 0 {
+		return fmt.Errorf("trace record has negative content size %d", rec.ContentSize)
+	}
+	return nil
+}
+
+// uploadEvent recreates the upload event with the given sequence number for an upload trace
+// record.
+func (rec *traceRecord) uploadEvent(start time.Time, seq int, authors directory) *uploadEvent {
+	from, _ := authors.lookup(rec.Author)
+	ct := &contentType{generator: rec.Generator, mediaType: rec.MediaType}
+	event := &uploadEvent{
+		seq:           seq,
+		scheduled:     start.Add(rec.Offset),
+		size:          rec.ContentSize,
+		contentSeed:   rec.ContentSeed,
+		contentType:   ct,
+		large:         rec.Large,
+		fromIdx:       rec.Author,
+		from:          from,
+		shareWithIdxs: rec.ShareWith,
+		shareWith:     make([]*ecdsa.PublicKey, len(rec.ShareWith)),
+		downloadWaits: rec.DownloadWaits,
+	}
+	for i, idx := range rec.ShareWith {
+		_, event.shareWith[i] = authors.lookup(idx)
+	}
+	if !event.large {
+		event.content = newContent(event.size, event.contentSeed, ct.generator)
+		event.digest = newContentDigest(event.content.Bytes())
+	}
+	return event
+}
@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/drausin/libri/libri/author"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceWriterReader(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	params := newDefaultParameters()
+	params.NAuthors = 10
+	params.Seed = 3
+	d := &fixedDirectory{
+		rng:          rng,
+		idxRNG:       rng,
+		returnAuthor: &author.Author{},
+		nAuthors:     int(params.NAuthors),
+	}
+	cts, err := newContentTypeSampler([]string{"1:text:text/plain", "1:random:image/png"}, rng)
+	assert.Nil(t, err)
+	s := &uploadEventSamplerImpl{
+		contentTypes:     cts,
+		nSharesPerUpload: 2,
+		content:          newSizeContentSampler(gammaSizeDist{shape: 1.5, rate: 1.0 / 16}, rng),
+		downloadWait:     newTestDownloadWaitSampler(rng),
+		authors:          d,
+	}
+	start := time.Now()
+	events := make([]*uploadEvent, 4)
+	buf := new(bytes.Buffer)
+	tw, err := NewTraceWriter(buf, params)
+	assert.Nil(t, err)
+	for i := range events {
+		events[i] = s.sample()
+		events[i].scheduled = start.Add(time.Duration(i) * time.Second)
+		err = tw.writeUpload(start, events[i])
+		assert.Nil(t, err)
+	}
+	err = tw.Close()
+	assert.Nil(t, err)
+
+	tr, err := NewTraceReader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, params.NAuthors, tr.NAuthors())
+	replayStart := start.Add(time.Hour)
+	for _, expected := range events {
+		rec, err := tr.next()
+		assert.Nil(t, err)
+		actual := rec.uploadEvent(replayStart, 0, d)
+		assert.Equal(t, expected.scheduled.Sub(start), actual.scheduled.Sub(replayStart))
+		assert.Equal(t, expected.fromIdx, actual.fromIdx)
+		assert.Equal(t, expected.shareWithIdxs, actual.shareWithIdxs)
+		assert.Equal(t, len(expected.shareWithIdxs), len(actual.shareWith))
+		assert.Equal(t, expected.downloadWaits, actual.downloadWaits)
+		assert.Equal(t, expected.digest, actual.digest)
+		assert.Equal(t, expected.contentType, actual.contentType)
+		assert.Equal(t, expected.content.Bytes(), actual.content.Bytes())
+	}
+	rec, err := tr.next()
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, rec)
+}
+
+func TestTraceWriterReader_reReadsPhases(t *testing.T) {
+	params := newDefaultParameters()
+	params.NAuthors = 10
+	params.ReReadsPerDay = 5
+	params.Phases = []*Phase{
+		{Name: "first", Duration: time.Minute},
+		{Name: "second", Duration: time.Minute},
+	}
+	start := time.Now()
+	docs := []catalogueRef{{Upload: 3, Share: 1}, {EnvKey: "abcd"}}
+	buf := new(bytes.Buffer)
+	tw, err := NewTraceWriter(buf, params)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.writeReRead(start, start.Add(time.Second), docs[0]))
+	assert.Nil(t, tw.writePhase(start, start.Add(time.Minute), 1))
+	assert.Nil(t, tw.writeReRead(start, start.Add(2*time.Minute), docs[1]))
+	assert.Nil(t, tw.Close())
+
+	tr, err := NewTraceReader(buf)
+	assert.Nil(t, err)
+	replayParams := tr.ReplayParameters(newDefaultParameters())
+	assert.Equal(t, params.ReReadsPerDay, replayParams.ReReadsPerDay)
+	assert.Equal(t, params.Phases, replayParams.Phases)
+
+	rec, err := tr.next()
+	assert.Nil(t, err)
+	assert.Equal(t, &traceRecord{Offset: time.Second, Kind: reReadRecord, Doc: &docs[0]}, rec)
+	rec, err = tr.next()
+	assert.Nil(t, err)
+	assert.Equal(t, &traceRecord{Offset: time.Minute, Kind: phaseRecord, Phase: 1}, rec)
+	rec, err = tr.next()
+	assert.Nil(t, err)
+	assert.Equal(t, &traceRecord{Offset: 2 * time.Minute, Kind: reReadRecord, Doc: &docs[1]},
+		rec)
+	_, err = tr.next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNewTraceReader_err(t *testing.T) {
+	// not gzipped
+	tr, err := NewTraceReader(bytes.NewBufferString("{}"))
+	assert.NotNil(t, err)
+	assert.Nil(t, tr)
+
+	// zero authors
+	tr, err = NewTraceReader(gzipped(t, "{\"n_authors\": 0}\n"))
+	assert.NotNil(t, err)
+	assert.Nil(t, tr)
+}
+
+func TestTraceReader_next_err(t *testing.T) {
+	cases := []string{
+		"not json\n",
+		"{\"a\": 5, \"g\": \"random\", \"mt\": \"a/b\"}\n", // author out of range
+		// share author out of range
+		"{\"a\": 0, \"g\": \"random\", \"mt\": \"a/b\", \"sw\": [-1], \"dw\": [1]}\n",
+		// mismatched download waits
+		"{\"a\": 0, \"g\": \"random\", \"mt\": \"a/b\", \"sw\": [1, 2], \"dw\": [1]}\n",
+		"{\"a\": 0, \"g\": \"random\", \"mt\": \"a/b\", \"s\": -1}\n", // negative size
+		"{\"a\": 0, \"g\": \"unknown\", \"mt\": \"a/b\"}\n",           // unknown generator
+		"{\"a\": 0, \"mt\": \"a/b\"}\n",                               // no generator
+		"{\"a\": 0, \"g\": \"random\"}\n",                             // no media type
+		"{\"k\": \"x\"}\n",                                            // unknown kind
+		"{\"k\": \"r\"}\n",                                            // re-read without a doc
+		"{\"k\": \"p\", \"p\": 1}\n",                                  // phase out of range
+	}
+	for _, c := range cases {
+		tr, err := NewTraceReader(gzipped(t, "{\"n_authors\": 3}\n"+c))
+		assert.Nil(t, err)
+		rec, err := tr.next()
+		assert.NotNil(t, err, c)
+		assert.Nil(t, rec)
+	}
+}
+
+func gzipped(t *testing.T, content string) io.Reader {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write([]byte(content))
+	assert.Nil(t, err)
+	assert.Nil(t, gz.Close())
+	return buf
+}